@@ -51,6 +51,37 @@ type NASDirectoryLocal struct {
 	UIDStart int64  `json:"uidStart,omitempty"`
 	GIDStart int64  `json:"gidStart,omitempty"`
 	Strategy string `json:"strategy,omitempty"`
+
+	// Homes provisions a home dataset per NASUser in this directory.
+	Homes *NASDirectoryHomes `json:"homes,omitempty"`
+}
+
+// NASDirectoryHomes describes the per-user home datasets created for local users.
+type NASDirectoryHomes struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// NodeName is the node that hosts the parent dataset.
+	NodeName string `json:"nodeName"`
+	// ParentDataset receives one child per user: <parentDataset>/<username>.
+	ParentDataset string `json:"parentDataset"`
+	// MountPath is the mountpoint of ParentDataset; used by the homes share.
+	MountPath string `json:"mountPath,omitempty"`
+	// Mode is applied to each home directory (default 0700).
+	Mode string `json:"mode,omitempty"`
+	// Quota is the default zfs quota for each home; NASUser.homeQuota overrides it.
+	Quota string `json:"quota,omitempty"`
+	// Properties are extra zfs properties set on each home dataset.
+	Properties map[string]string `json:"properties,omitempty"`
+	// Snapshots, when set, creates a ZSnapshotSchedule per home dataset.
+	Snapshots *NASDirectoryHomesSnapshots `json:"snapshots,omitempty"`
+	// SMBShare renders a Samba [homes] share over MountPath.
+	SMBShare bool `json:"smbShare,omitempty"`
+}
+
+type NASDirectoryHomesSnapshots struct {
+	Schedule   string                      `json:"schedule"`
+	NamePrefix string                      `json:"namePrefix,omitempty"`
+	Format     string                      `json:"format,omitempty"`
+	Retention  *ZSnapshotScheduleRetention `json:"retention,omitempty"`
 }
 
 type NASDirectoryStatus struct {
@@ -59,6 +90,9 @@ type NASDirectoryStatus struct {
 	AppliedHash        string `json:"appliedHash,omitempty"`
 	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
 	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+	// SkippedHomes lists NASUsers (with the reason) that got no home
+	// dataset, e.g. because they have no uid.
+	SkippedHomes []string `json:"skippedHomes,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -132,7 +166,13 @@ func (in *NASDirectoryGroupResolution) DeepCopy() *NASDirectoryGroupResolution {
 	return out
 }
 
-func (in *NASDirectoryLocal) DeepCopyInto(out *NASDirectoryLocal) { *out = *in }
+func (in *NASDirectoryLocal) DeepCopyInto(out *NASDirectoryLocal) {
+	*out = *in
+	if in.Homes != nil {
+		out.Homes = new(NASDirectoryHomes)
+		in.Homes.DeepCopyInto(out.Homes)
+	}
+}
 
 func (in *NASDirectoryLocal) DeepCopy() *NASDirectoryLocal {
 	if in == nil {
@@ -143,6 +183,46 @@ func (in *NASDirectoryLocal) DeepCopy() *NASDirectoryLocal {
 	return out
 }
 
+func (in *NASDirectoryHomes) DeepCopyInto(out *NASDirectoryHomes) {
+	*out = *in
+	if in.Properties != nil {
+		out.Properties = make(map[string]string, len(in.Properties))
+		for k, v := range in.Properties {
+			out.Properties[k] = v
+		}
+	}
+	if in.Snapshots != nil {
+		out.Snapshots = new(NASDirectoryHomesSnapshots)
+		in.Snapshots.DeepCopyInto(out.Snapshots)
+	}
+}
+
+func (in *NASDirectoryHomes) DeepCopy() *NASDirectoryHomes {
+	if in == nil {
+		return nil
+	}
+	out := new(NASDirectoryHomes)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *NASDirectoryHomesSnapshots) DeepCopyInto(out *NASDirectoryHomesSnapshots) {
+	*out = *in
+	if in.Retention != nil {
+		out.Retention = new(ZSnapshotScheduleRetention)
+		in.Retention.DeepCopyInto(out.Retention)
+	}
+}
+
+func (in *NASDirectoryHomesSnapshots) DeepCopy() *NASDirectoryHomesSnapshots {
+	if in == nil {
+		return nil
+	}
+	out := new(NASDirectoryHomesSnapshots)
+	in.DeepCopyInto(out)
+	return out
+}
+
 func (in *NASDirectorySpec) DeepCopyInto(out *NASDirectorySpec) {
 	*out = *in
 	if in.Servers != nil {
@@ -186,6 +266,10 @@ func (in *NASDirectoryStatus) DeepCopyInto(out *NASDirectoryStatus) {
 		out.Conditions = make([]metav1.Condition, len(in.Conditions))
 		copy(out.Conditions, in.Conditions)
 	}
+	if in.SkippedHomes != nil {
+		out.SkippedHomes = make([]string, len(in.SkippedHomes))
+		copy(out.SkippedHomes, in.SkippedHomes)
+	}
 }
 
 func (in *NASDirectoryStatus) DeepCopy() *NASDirectoryStatus {
@@ -11,6 +11,39 @@ type NASNFSExport struct {
 	Options string   `json:"options,omitempty"`
 }
 
+// NASISCSITarget exports a zvol as a LUN through the kernel (LIO) iSCSI target.
+type NASISCSITarget struct {
+	// IQN of the target; defaults to iqn.2024-01.io.nas:<shareName>.
+	IQN string `json:"iqn,omitempty"`
+	// ZVolumeRef names a ZVolume in the share namespace. When empty,
+	// spec.datasetName is used as the zvol name.
+	ZVolumeRef string `json:"zvolumeRef,omitempty"`
+	LUN        int32  `json:"lun,omitempty"`
+	// Portals are ip[:port] bind addresses; defaults to 0.0.0.0:3260.
+	Portals []string `json:"portals,omitempty"`
+	// InitiatorACLs lists the initiator IQNs allowed to log in.
+	InitiatorACLs []string `json:"initiatorACLs"`
+	// CHAPSecretRef names a Secret with "username" and "password" keys and
+	// optional "mutualUsername" and "mutualPassword" for mutual CHAP.
+	CHAPSecretRef *SecretRef `json:"chapSecretRef,omitempty"`
+}
+
+// NASNVMeoFTarget exports a zvol as an NVMe/TCP namespace through the
+// kernel nvmet target.
+type NASNVMeoFTarget struct {
+	// NQN of the subsystem; defaults to nqn.2024-01.io.nas:<shareName>.
+	NQN string `json:"nqn,omitempty"`
+	// ZVolumeRef names a ZVolume in the share namespace. When empty,
+	// spec.datasetName is used as the zvol name.
+	ZVolumeRef string `json:"zvolumeRef,omitempty"`
+	// NSID is the namespace id; defaults to 1.
+	NSID int32 `json:"nsid,omitempty"`
+	// Portals are ip[:port] listen addresses; defaults to 0.0.0.0:4420.
+	Portals []string `json:"portals,omitempty"`
+	// Hosts lists the host NQNs allowed to connect.
+	Hosts []string `json:"hosts"`
+}
+
 type NASSharePrincipalSelector struct {
 	Users  []string `json:"users,omitempty"`
 	Groups []string `json:"groups,omitempty"`
@@ -21,7 +54,7 @@ type NASSharePermissions struct {
 	ReadOnly NASSharePrincipalSelector `json:"readOnly,omitempty"`
 }
 
-// NASShareSpec defines an abstract share across SMB/NFS.
+// NASShareSpec defines an abstract share across SMB/NFS/iSCSI/NVMe-oF.
 type NASShareSpec struct {
 	Protocol    string         `json:"protocol"`
 	DatasetName string         `json:"datasetName"`
@@ -35,12 +68,44 @@ type NASShareSpec struct {
 	Permissions *NASSharePermissions `json:"permissions,omitempty"`
 	Options     map[string]any `json:"options,omitempty"`
 	NFS         *NASNFSExport  `json:"nfs,omitempty"`
+	ISCSI       *NASISCSITarget `json:"iscsi,omitempty"`
+	NVMeoF      *NASNVMeoFTarget `json:"nvmeof,omitempty"`
+}
+
+// NASShareSession is a block-protocol client logged in to the share. For
+// NVMe-oF, Initiator is the host NQN and State the controller state.
+type NASShareSession struct {
+	Initiator string   `json:"initiator"`
+	Addresses []string `json:"addresses,omitempty"`
+	State     string   `json:"state,omitempty"`
+}
+
+// NASShareISCSIStatus records the applied target so it can be torn down
+// after the spec changes.
+type NASShareISCSIStatus struct {
+	IQN       string            `json:"iqn,omitempty"`
+	Backstore string            `json:"backstore,omitempty"`
+	Portals   []string          `json:"portals,omitempty"`
+	Sessions  []NASShareSession `json:"sessions,omitempty"`
+}
+
+// NASShareNVMeoFStatus records the applied subsystem and its controllers.
+type NASShareNVMeoFStatus struct {
+	NQN     string   `json:"nqn,omitempty"`
+	NSID    int32    `json:"nsid,omitempty"`
+	Portals []string `json:"portals,omitempty"`
+	// SessionsKnown is false when the kernel does not expose nvmet
+	// controllers (debugfs missing), so Sessions cannot be trusted.
+	SessionsKnown bool              `json:"sessionsKnown,omitempty"`
+	Sessions      []NASShareSession `json:"sessions,omitempty"`
 }
 
 type NASShareStatus struct {
-	Phase    string `json:"phase,omitempty"`
-	Message  string `json:"message,omitempty"`
-	Endpoint string `json:"endpoint,omitempty"`
+	Phase    string                `json:"phase,omitempty"`
+	Message  string                `json:"message,omitempty"`
+	Endpoint string                `json:"endpoint,omitempty"`
+	ISCSI    *NASShareISCSIStatus  `json:"iscsi,omitempty"`
+	NVMeoF   *NASShareNVMeoFStatus `json:"nvmeof,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -77,6 +142,52 @@ func (in *NASNFSExport) DeepCopy() *NASNFSExport {
 	return out
 }
 
+func (in *NASISCSITarget) DeepCopyInto(out *NASISCSITarget) {
+	*out = *in
+	if in.Portals != nil {
+		out.Portals = make([]string, len(in.Portals))
+		copy(out.Portals, in.Portals)
+	}
+	if in.InitiatorACLs != nil {
+		out.InitiatorACLs = make([]string, len(in.InitiatorACLs))
+		copy(out.InitiatorACLs, in.InitiatorACLs)
+	}
+	if in.CHAPSecretRef != nil {
+		out.CHAPSecretRef = new(SecretRef)
+		*out.CHAPSecretRef = *in.CHAPSecretRef
+	}
+}
+
+func (in *NASISCSITarget) DeepCopy() *NASISCSITarget {
+	if in == nil {
+		return nil
+	}
+	out := new(NASISCSITarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *NASNVMeoFTarget) DeepCopyInto(out *NASNVMeoFTarget) {
+	*out = *in
+	if in.Portals != nil {
+		out.Portals = make([]string, len(in.Portals))
+		copy(out.Portals, in.Portals)
+	}
+	if in.Hosts != nil {
+		out.Hosts = make([]string, len(in.Hosts))
+		copy(out.Hosts, in.Hosts)
+	}
+}
+
+func (in *NASNVMeoFTarget) DeepCopy() *NASNVMeoFTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(NASNVMeoFTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
 func (in *NASSharePrincipalSelector) DeepCopyInto(out *NASSharePrincipalSelector) {
 	*out = *in
 	if in.Users != nil {
@@ -129,6 +240,14 @@ func (in *NASShareSpec) DeepCopyInto(out *NASShareSpec) {
 		out.NFS = new(NASNFSExport)
 		in.NFS.DeepCopyInto(out.NFS)
 	}
+	if in.ISCSI != nil {
+		out.ISCSI = new(NASISCSITarget)
+		in.ISCSI.DeepCopyInto(out.ISCSI)
+	}
+	if in.NVMeoF != nil {
+		out.NVMeoF = new(NASNVMeoFTarget)
+		in.NVMeoF.DeepCopyInto(out.NVMeoF)
+	}
 }
 
 func (in *NASShareSpec) DeepCopy() *NASShareSpec {
@@ -140,7 +259,53 @@ func (in *NASShareSpec) DeepCopy() *NASShareSpec {
 	return out
 }
 
-func (in *NASShareStatus) DeepCopyInto(out *NASShareStatus) { *out = *in }
+func (in *NASShareSession) DeepCopyInto(out *NASShareSession) {
+	*out = *in
+	if in.Addresses != nil {
+		out.Addresses = make([]string, len(in.Addresses))
+		copy(out.Addresses, in.Addresses)
+	}
+}
+
+func (in *NASShareISCSIStatus) DeepCopyInto(out *NASShareISCSIStatus) {
+	*out = *in
+	if in.Portals != nil {
+		out.Portals = make([]string, len(in.Portals))
+		copy(out.Portals, in.Portals)
+	}
+	if in.Sessions != nil {
+		out.Sessions = make([]NASShareSession, len(in.Sessions))
+		for i := range in.Sessions {
+			in.Sessions[i].DeepCopyInto(&out.Sessions[i])
+		}
+	}
+}
+
+func (in *NASShareNVMeoFStatus) DeepCopyInto(out *NASShareNVMeoFStatus) {
+	*out = *in
+	if in.Portals != nil {
+		out.Portals = make([]string, len(in.Portals))
+		copy(out.Portals, in.Portals)
+	}
+	if in.Sessions != nil {
+		out.Sessions = make([]NASShareSession, len(in.Sessions))
+		for i := range in.Sessions {
+			in.Sessions[i].DeepCopyInto(&out.Sessions[i])
+		}
+	}
+}
+
+func (in *NASShareStatus) DeepCopyInto(out *NASShareStatus) {
+	*out = *in
+	if in.ISCSI != nil {
+		out.ISCSI = new(NASShareISCSIStatus)
+		in.ISCSI.DeepCopyInto(out.ISCSI)
+	}
+	if in.NVMeoF != nil {
+		out.NVMeoF = new(NASShareNVMeoFStatus)
+		in.NVMeoF.DeepCopyInto(out.NVMeoF)
+	}
+}
 
 func (in *NASShareStatus) DeepCopy() *NASShareStatus {
 	if in == nil {
@@ -12,6 +12,8 @@ type NASUserSpec struct {
 	UID               int64             `json:"uid,omitempty"`
 	PrimaryGroup      string            `json:"primaryGroup,omitempty"`
 	PasswordSecretRef PasswordSecretRef `json:"passwordSecretRef"`
+	// HomeQuota overrides the directory's default home quota (e.g. "20G").
+	HomeQuota string `json:"homeQuota,omitempty"`
 }
 
 type NASUserStatus struct {
@@ -0,0 +1,86 @@
+package v1alpha1
+
+// SnapshotHooks run commands in application pods around a snapshot so it
+// captures a consistent state, e.g. fsfreeze or pg_backup_start before and
+// the matching thaw after. Post hooks always run once pre hooks have started,
+// whether or not the snapshot was taken.
+type SnapshotHooks struct {
+	Pre  []SnapshotHook `json:"pre,omitempty"`
+	Post []SnapshotHook `json:"post,omitempty"`
+	// OnPreHookFailure is "Skip" (default), which takes no snapshot, or
+	// "Proceed", which takes a crash-consistent one anyway.
+	OnPreHookFailure string `json:"onPreHookFailure,omitempty"`
+	// TimeoutSeconds bounds each hook command in each pod (default 30).
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+}
+
+// SnapshotHook runs Command in Container of every running pod matching
+// PodSelector in the namespace of the snapshot object.
+type SnapshotHook struct {
+	Name        string            `json:"name,omitempty"`
+	PodSelector map[string]string `json:"podSelector"`
+	// Container defaults to the pod's first container.
+	Container string   `json:"container,omitempty"`
+	Command   []string `json:"command"`
+}
+
+// SnapshotHookResult records one hook command in one pod.
+type SnapshotHookResult struct {
+	Hook      string `json:"hook"`
+	Stage     string `json:"stage"` // pre or post
+	Pod       string `json:"pod,omitempty"`
+	Container string `json:"container,omitempty"`
+	ExitCode  int32  `json:"exitCode,omitempty"`
+	// Output is the combined stdout and stderr, truncated to its last 1KiB.
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Time   string `json:"time"`
+}
+
+func (in *SnapshotHooks) DeepCopyInto(out *SnapshotHooks) {
+	*out = *in
+	if in.Pre != nil {
+		out.Pre = make([]SnapshotHook, len(in.Pre))
+		for i := range in.Pre {
+			in.Pre[i].DeepCopyInto(&out.Pre[i])
+		}
+	}
+	if in.Post != nil {
+		out.Post = make([]SnapshotHook, len(in.Post))
+		for i := range in.Post {
+			in.Post[i].DeepCopyInto(&out.Post[i])
+		}
+	}
+}
+
+func (in *SnapshotHooks) DeepCopy() *SnapshotHooks {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapshotHooks)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *SnapshotHook) DeepCopyInto(out *SnapshotHook) {
+	*out = *in
+	if in.PodSelector != nil {
+		out.PodSelector = make(map[string]string, len(in.PodSelector))
+		for k, v := range in.PodSelector {
+			out.PodSelector[k] = v
+		}
+	}
+	if in.Command != nil {
+		out.Command = make([]string, len(in.Command))
+		copy(out.Command, in.Command)
+	}
+}
+
+func (in *SnapshotHook) DeepCopy() *SnapshotHook {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapshotHook)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -11,14 +11,129 @@ import (
 type ZDatasetSpec struct {
 	NodeName    string `json:"nodeName"`
 	DatasetName string `json:"datasetName"`
-	// Preset indicates intent for ACL and dataset defaults (generic/smb/multiprotocol).
+	// Preset selects ACL handling and default properties. Built-in presets are
+	// generic, smb, multiprotocol, timemachine, media, database, vm and
+	// backup-target; more can be defined in the nas-dataset-presets ConfigMap.
 	Preset     string            `json:"preset,omitempty"`
 	Properties map[string]string `json:"properties"`
+	// UserQuotas caps space per NASUser (zfs userquota@<uid>).
+	UserQuotas []ZDatasetQuota `json:"userQuotas,omitempty"`
+	// GroupQuotas caps space per NASGroup (zfs groupquota@<gid>).
+	GroupQuotas []ZDatasetQuota `json:"groupQuotas,omitempty"`
+	// Owner sets ownership and mode of the dataset mountpoint.
+	Owner *ZDatasetOwner `json:"owner,omitempty"`
+	// ACL declares NFSv4 or POSIX ACLs on the mountpoint and sub-paths.
+	ACL *ZDatasetACL `json:"acl,omitempty"`
+}
+
+// ZDatasetACL is reconciled against nfs4_getfacl/getfacl output on every pass.
+type ZDatasetACL struct {
+	// Type is nfsv4 or posix. It defaults from the acltype property or the
+	// preset and must match the dataset's acltype.
+	Type string `json:"type,omitempty"`
+	// Entries apply to the dataset root.
+	Entries []ZDatasetACLEntry `json:"entries,omitempty"`
+	// Paths apply entries to directories relative to the mountpoint.
+	Paths []ZDatasetACLPath `json:"paths,omitempty"`
+	// ResetToken triggers a recursive reset of every path when changed.
+	ResetToken string `json:"resetToken,omitempty"`
+}
+
+type ZDatasetACLPath struct {
+	Path    string             `json:"path"`
+	Entries []ZDatasetACLEntry `json:"entries"`
+}
+
+// ZDatasetACLEntry is one ACE. Exactly one of User, Group, Principal or
+// Special must be set.
+type ZDatasetACLEntry struct {
+	// Type is allow (default) or deny. Deny requires nfsv4.
+	Type string `json:"type,omitempty"`
+	// User is a NASUser name, resolved to its UID.
+	User string `json:"user,omitempty"`
+	// Group is a NASGroup name, resolved to its GID.
+	Group string `json:"group,omitempty"`
+	// Principal is a directory principal passed through as-is (e.g. alice@example.com).
+	Principal string `json:"principal,omitempty"`
+	// PrincipalIsGroup marks Principal as a group.
+	PrincipalIsGroup bool `json:"principalIsGroup,omitempty"`
+	// Special is owner@, group@, everyone@ or (posix only) mask@.
+	Special string `json:"special,omitempty"`
+	// Permissions is full, modify, read, traverse, raw nfs4 letters (e.g.
+	// rxtncy) for nfsv4, or rwx letters for posix.
+	Permissions string `json:"permissions"`
+	// Flags are file_inherit, dir_inherit, no_propagate and inherit_only (nfsv4 only).
+	Flags []string `json:"flags,omitempty"`
+	// Default makes this a POSIX default ACL entry (setfacl -d).
+	Default bool `json:"default,omitempty"`
+}
+
+// ZDatasetOwner is applied to the top of the mountpoint only (not recursively).
+type ZDatasetOwner struct {
+	UID  int64  `json:"uid"`
+	GID  int64  `json:"gid"`
+	Mode string `json:"mode,omitempty"`
+}
+
+// ZDatasetQuota references a NASUser or NASGroup by name.
+type ZDatasetQuota struct {
+	Name string `json:"name"`
+	// Quota is a zfs size such as "10G", or "none" to clear.
+	Quota string `json:"quota"`
+}
+
+// ZDatasetQuotaStatus reports the applied quota and current usage of a principal.
+type ZDatasetQuotaStatus struct {
+	Name       string `json:"name"`
+	ID         int64  `json:"id"`
+	Quota      string `json:"quota,omitempty"`
+	QuotaBytes int64  `json:"quotaBytes,omitempty"`
+	UsedBytes  int64  `json:"usedBytes"`
 }
 
 type ZDatasetStatus struct {
 	Phase   string `json:"phase,omitempty"`
 	Message string `json:"message,omitempty"`
+	// AppliedDatasetName is the dataset name last ensured on the node. A
+	// mismatch with spec.datasetName is treated as a rename.
+	AppliedDatasetName string `json:"appliedDatasetName,omitempty"`
+	// AppliedMountpoint is the mountpoint reported by the node for
+	// AppliedDatasetName. It lets a retried rename retarget shares even when
+	// the node no longer knows where the dataset used to be mounted.
+	AppliedMountpoint string `json:"appliedMountpoint,omitempty"`
+
+	UserQuotas  []ZDatasetQuotaStatus `json:"userQuotas,omitempty"`
+	GroupQuotas []ZDatasetQuotaStatus `json:"groupQuotas,omitempty"`
+	// MissingQuotaPrincipals lists quota entries (nasuser/<name> or
+	// nasgroup/<name>) skipped because the principal does not exist or has
+	// no id.
+	MissingQuotaPrincipals []string `json:"missingQuotaPrincipals,omitempty"`
+
+	Preset *ZDatasetPresetStatus `json:"preset,omitempty"`
+	ACL    *ZDatasetACLStatus    `json:"acl,omitempty"`
+}
+
+type ZDatasetACLStatus struct {
+	// Changed lists paths whose ACL differed and was rewritten on the last pass.
+	Changed     []string `json:"changed,omitempty"`
+	LastApplied string   `json:"lastApplied,omitempty"`
+	// ResetToken is the token of the last recursive reset started on the node.
+	ResetToken   string `json:"resetToken,omitempty"`
+	ResetState   string `json:"resetState,omitempty"`
+	ResetMessage string `json:"resetMessage,omitempty"`
+}
+
+// ZDatasetPresetStatus reports how the preset was resolved.
+type ZDatasetPresetStatus struct {
+	Name string `json:"name"`
+	// ACL is the ACL handling passed to the node (generic/smb/multiprotocol).
+	ACL string `json:"acl,omitempty"`
+	// Source is "builtin" or "configmap".
+	Source string `json:"source,omitempty"`
+	// Applied holds preset properties that were set on the dataset.
+	Applied map[string]string `json:"applied,omitempty"`
+	// Overridden holds preset values that lost to spec.properties.
+	Overridden map[string]string `json:"overridden,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -38,6 +153,142 @@ type ZDatasetList struct {
 	Items           []ZDataset `json:"items"`
 }
 
+func (in *ZDatasetQuota) DeepCopyInto(out *ZDatasetQuota) { *out = *in }
+
+func (in *ZDatasetQuota) DeepCopy() *ZDatasetQuota {
+	if in == nil {
+		return nil
+	}
+	out := new(ZDatasetQuota)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *ZDatasetOwner) DeepCopyInto(out *ZDatasetOwner) { *out = *in }
+
+func (in *ZDatasetOwner) DeepCopy() *ZDatasetOwner {
+	if in == nil {
+		return nil
+	}
+	out := new(ZDatasetOwner)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *ZDatasetQuotaStatus) DeepCopyInto(out *ZDatasetQuotaStatus) { *out = *in }
+
+func (in *ZDatasetQuotaStatus) DeepCopy() *ZDatasetQuotaStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ZDatasetQuotaStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *ZDatasetPresetStatus) DeepCopyInto(out *ZDatasetPresetStatus) {
+	*out = *in
+	if in.Applied != nil {
+		out.Applied = make(map[string]string, len(in.Applied))
+		for k, v := range in.Applied {
+			out.Applied[k] = v
+		}
+	}
+	if in.Overridden != nil {
+		out.Overridden = make(map[string]string, len(in.Overridden))
+		for k, v := range in.Overridden {
+			out.Overridden[k] = v
+		}
+	}
+}
+
+func (in *ZDatasetPresetStatus) DeepCopy() *ZDatasetPresetStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ZDatasetPresetStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *ZDatasetACL) DeepCopyInto(out *ZDatasetACL) {
+	*out = *in
+	if in.Entries != nil {
+		out.Entries = make([]ZDatasetACLEntry, len(in.Entries))
+		for i := range in.Entries {
+			in.Entries[i].DeepCopyInto(&out.Entries[i])
+		}
+	}
+	if in.Paths != nil {
+		out.Paths = make([]ZDatasetACLPath, len(in.Paths))
+		for i := range in.Paths {
+			in.Paths[i].DeepCopyInto(&out.Paths[i])
+		}
+	}
+}
+
+func (in *ZDatasetACL) DeepCopy() *ZDatasetACL {
+	if in == nil {
+		return nil
+	}
+	out := new(ZDatasetACL)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *ZDatasetACLPath) DeepCopyInto(out *ZDatasetACLPath) {
+	*out = *in
+	if in.Entries != nil {
+		out.Entries = make([]ZDatasetACLEntry, len(in.Entries))
+		for i := range in.Entries {
+			in.Entries[i].DeepCopyInto(&out.Entries[i])
+		}
+	}
+}
+
+func (in *ZDatasetACLPath) DeepCopy() *ZDatasetACLPath {
+	if in == nil {
+		return nil
+	}
+	out := new(ZDatasetACLPath)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *ZDatasetACLEntry) DeepCopyInto(out *ZDatasetACLEntry) {
+	*out = *in
+	if in.Flags != nil {
+		out.Flags = make([]string, len(in.Flags))
+		copy(out.Flags, in.Flags)
+	}
+}
+
+func (in *ZDatasetACLEntry) DeepCopy() *ZDatasetACLEntry {
+	if in == nil {
+		return nil
+	}
+	out := new(ZDatasetACLEntry)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *ZDatasetACLStatus) DeepCopyInto(out *ZDatasetACLStatus) {
+	*out = *in
+	if in.Changed != nil {
+		out.Changed = make([]string, len(in.Changed))
+		copy(out.Changed, in.Changed)
+	}
+}
+
+func (in *ZDatasetACLStatus) DeepCopy() *ZDatasetACLStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ZDatasetACLStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 func (in *ZDataset) DeepCopyInto(out *ZDataset) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
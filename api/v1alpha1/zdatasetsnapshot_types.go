@@ -0,0 +1,107 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ZDatasetSnapshotSpec is an on-demand ZFS snapshot of a dataset, taken
+// through the node-agent rather than CSI.
+type ZDatasetSnapshotSpec struct {
+	NodeName string `json:"nodeName"`
+	// Dataset is the full dataset name, e.g. tank/home.
+	Dataset string `json:"dataset"`
+	// Name is the part after "@"; defaults to the object name.
+	Name      string `json:"name,omitempty"`
+	Recursive bool   `json:"recursive,omitempty"`
+	// DeletionPolicy is Retain (default) or Delete. Delete destroys the
+	// snapshot when the ZDatasetSnapshot is deleted, but only if the operator
+	// took it; a snapshot that already existed is left alone.
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
+}
+
+type ZDatasetSnapshotStatus struct {
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Snapshot is the full dataset@name taken.
+	Snapshot string `json:"snapshot,omitempty"`
+	// Created is set when the operator took the snapshot rather than
+	// adopting an existing one.
+	Created         bool   `json:"created,omitempty"`
+	CreationTime    string `json:"creationTime,omitempty"`
+	UsedBytes       int64  `json:"usedBytes,omitempty"`
+	ReferencedBytes int64  `json:"referencedBytes,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+type ZDatasetSnapshot struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ZDatasetSnapshotSpec   `json:"spec,omitempty"`
+	Status ZDatasetSnapshotStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+type ZDatasetSnapshotList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ZDatasetSnapshot `json:"items"`
+}
+
+func (in *ZDatasetSnapshot) DeepCopyInto(out *ZDatasetSnapshot) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	out.Status = in.Status
+}
+
+func (in *ZDatasetSnapshot) DeepCopy() *ZDatasetSnapshot {
+	if in == nil {
+		return nil
+	}
+	out := new(ZDatasetSnapshot)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *ZDatasetSnapshot) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+func (in *ZDatasetSnapshotList) DeepCopyInto(out *ZDatasetSnapshotList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]ZDatasetSnapshot, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+}
+
+func (in *ZDatasetSnapshotList) DeepCopy() *ZDatasetSnapshotList {
+	if in == nil {
+		return nil
+	}
+	out := new(ZDatasetSnapshotList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *ZDatasetSnapshotList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+func init() {
+	SchemeBuilder.Register(&ZDatasetSnapshot{}, &ZDatasetSnapshotList{})
+}
@@ -170,6 +170,22 @@ func (in *ZDatasetSpec) DeepCopyInto(out *ZDatasetSpec) {
 			out.Properties[k] = v
 		}
 	}
+	if in.UserQuotas != nil {
+		out.UserQuotas = make([]ZDatasetQuota, len(in.UserQuotas))
+		copy(out.UserQuotas, in.UserQuotas)
+	}
+	if in.GroupQuotas != nil {
+		out.GroupQuotas = make([]ZDatasetQuota, len(in.GroupQuotas))
+		copy(out.GroupQuotas, in.GroupQuotas)
+	}
+	if in.Owner != nil {
+		out.Owner = new(ZDatasetOwner)
+		in.Owner.DeepCopyInto(out.Owner)
+	}
+	if in.ACL != nil {
+		out.ACL = new(ZDatasetACL)
+		in.ACL.DeepCopyInto(out.ACL)
+	}
 }
 
 func (in *ZDatasetSpec) DeepCopy() *ZDatasetSpec {
@@ -183,6 +199,26 @@ func (in *ZDatasetSpec) DeepCopy() *ZDatasetSpec {
 
 func (in *ZDatasetStatus) DeepCopyInto(out *ZDatasetStatus) {
 	*out = *in
+	if in.UserQuotas != nil {
+		out.UserQuotas = make([]ZDatasetQuotaStatus, len(in.UserQuotas))
+		copy(out.UserQuotas, in.UserQuotas)
+	}
+	if in.GroupQuotas != nil {
+		out.GroupQuotas = make([]ZDatasetQuotaStatus, len(in.GroupQuotas))
+		copy(out.GroupQuotas, in.GroupQuotas)
+	}
+	if in.MissingQuotaPrincipals != nil {
+		out.MissingQuotaPrincipals = make([]string, len(in.MissingQuotaPrincipals))
+		copy(out.MissingQuotaPrincipals, in.MissingQuotaPrincipals)
+	}
+	if in.Preset != nil {
+		out.Preset = new(ZDatasetPresetStatus)
+		in.Preset.DeepCopyInto(out.Preset)
+	}
+	if in.ACL != nil {
+		out.ACL = new(ZDatasetACLStatus)
+		in.ACL.DeepCopyInto(out.ACL)
+	}
 }
 
 func (in *ZDatasetStatus) DeepCopy() *ZDatasetStatus {
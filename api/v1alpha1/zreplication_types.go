@@ -0,0 +1,191 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ZReplicationSpec replicates a dataset to another node with zfs send and
+// receive. Every tick snapshots the source and streams the changes since the
+// last replicated snapshot directly from the source node-agent to the target
+// node-agent. The stream is authenticated but not encrypted.
+type ZReplicationSpec struct {
+	Source ZReplicationEndpoint `json:"source"`
+	// Target.Dataset must not exist yet or hold an earlier replica of the
+	// source; it is created read-only.
+	Target ZReplicationEndpoint `json:"target"`
+	// Schedule is a cron expression, evaluated in UTC.
+	Schedule  string `json:"schedule"`
+	Recursive bool   `json:"recursive,omitempty"`
+	// Raw sends encrypted datasets as stored (zfs send -w), so the target
+	// never needs the key.
+	Raw bool `json:"raw,omitempty"`
+	// Retention prunes replicated snapshots on the target, with the same
+	// rules as ZSnapshotSchedule. The newest is always kept as the base for
+	// the next increment. On the source only that base is kept.
+	Retention *ZSnapshotScheduleRetention `json:"retention,omitempty"`
+	// Suspend stops scheduled replication. A run-now annotation still runs
+	// once.
+	Suspend bool `json:"suspend,omitempty"`
+	// DeletionPolicy decides what deleting the ZReplication does to the
+	// target: Retain (default) keeps the replica and its snapshots, Delete
+	// destroys the replicated snapshots. The replica dataset itself is never
+	// destroyed, and the base snapshots on the source always are.
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
+}
+
+type ZReplicationEndpoint struct {
+	NodeName string `json:"nodeName"`
+	Dataset  string `json:"dataset"`
+}
+
+type ZReplicationStatus struct {
+	// Phase is Idle, Replicating or Error.
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	// LastReplicatedSnapshot is the newest source snapshot on the target,
+	// taken at LastReplicatedTime.
+	LastReplicatedSnapshot string `json:"lastReplicatedSnapshot,omitempty"`
+	LastReplicatedTime     string `json:"lastReplicatedTime,omitempty"`
+	LastSuccessTime        string `json:"lastSuccessTime,omitempty"`
+	// LastBytesTransferred is the size of the last stream;
+	// TotalBytesTransferred adds up every successful one.
+	LastBytesTransferred  int64 `json:"lastBytesTransferred,omitempty"`
+	TotalBytesTransferred int64 `json:"totalBytesTransferred,omitempty"`
+	// Lag is how far the target trails the source, e.g. "1h2m3s".
+	Lag string `json:"lag,omitempty"`
+
+	LastError           string `json:"lastError,omitempty"`
+	LastErrorTime       string `json:"lastErrorTime,omitempty"`
+	ConsecutiveFailures int32  `json:"consecutiveFailures,omitempty"`
+
+	LastScheduleTime  string `json:"lastScheduleTime,omitempty"`
+	NextRunTime       string `json:"nextRunTime,omitempty"`
+	LastManualTrigger string `json:"lastManualTrigger,omitempty"`
+	// Transfer is the stream in flight, if any.
+	Transfer   *ZReplicationTransfer `json:"transfer,omitempty"`
+	LastPruned []string              `json:"lastPruned,omitempty"`
+}
+
+type ZReplicationTransfer struct {
+	// Token identifies the stream on both node-agents.
+	Token    string `json:"token"`
+	Snapshot string `json:"snapshot"`
+	// Base is the incremental source; empty for a full send.
+	Base         string `json:"base,omitempty"`
+	SnapshotTime string `json:"snapshotTime"`
+	Started      string `json:"started"`
+	Bytes        int64  `json:"bytes,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+type ZReplication struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ZReplicationSpec   `json:"spec,omitempty"`
+	Status ZReplicationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+type ZReplicationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ZReplication `json:"items"`
+}
+
+func (in *ZReplicationSpec) DeepCopyInto(out *ZReplicationSpec) {
+	*out = *in
+	if in.Retention != nil {
+		out.Retention = new(ZSnapshotScheduleRetention)
+		in.Retention.DeepCopyInto(out.Retention)
+	}
+}
+
+func (in *ZReplicationSpec) DeepCopy() *ZReplicationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ZReplicationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *ZReplicationStatus) DeepCopyInto(out *ZReplicationStatus) {
+	*out = *in
+	if in.Transfer != nil {
+		t := *in.Transfer
+		out.Transfer = &t
+	}
+	if in.LastPruned != nil {
+		out.LastPruned = make([]string, len(in.LastPruned))
+		copy(out.LastPruned, in.LastPruned)
+	}
+}
+
+func (in *ZReplicationStatus) DeepCopy() *ZReplicationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ZReplicationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *ZReplication) DeepCopyInto(out *ZReplication) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+func (in *ZReplication) DeepCopy() *ZReplication {
+	if in == nil {
+		return nil
+	}
+	out := new(ZReplication)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *ZReplication) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+func (in *ZReplicationList) DeepCopyInto(out *ZReplicationList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]ZReplication, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+}
+
+func (in *ZReplicationList) DeepCopy() *ZReplicationList {
+	if in == nil {
+		return nil
+	}
+	out := new(ZReplicationList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *ZReplicationList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+func init() {
+	SchemeBuilder.Register(&ZReplication{}, &ZReplicationList{})
+}
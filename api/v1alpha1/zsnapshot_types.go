@@ -11,12 +11,27 @@ import (
 type ZSnapshotSpec struct {
 	PVCName           string `json:"pvcName"`
 	SnapshotClassName string `json:"snapshotClassName,omitempty"`
+	// Hooks quiesce applications while the VolumeSnapshot is cut.
+	Hooks *SnapshotHooks `json:"hooks,omitempty"`
+	// DeletionPolicy is Delete (default) or Retain. Delete deletes the
+	// VolumeSnapshot with the ZSnapshot; whether the snapshot data goes too
+	// is up to the VolumeSnapshotClass's own deletionPolicy.
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
 }
 
 type ZSnapshotStatus struct {
-	Phase              string `json:"phase,omitempty"`
-	Message            string `json:"message,omitempty"`
-	VolumeSnapshotName string `json:"volumeSnapshotName,omitempty"`
+	Phase              string               `json:"phase,omitempty"`
+	Message            string               `json:"message,omitempty"`
+	VolumeSnapshotName string               `json:"volumeSnapshotName,omitempty"`
+	HookResults        []SnapshotHookResult `json:"hookResults,omitempty"`
+
+	// Mirrored from the VolumeSnapshot's status.
+	ReadyToUse                     bool   `json:"readyToUse,omitempty"`
+	CreationTime                   string `json:"creationTime,omitempty"`
+	RestoreSize                    string `json:"restoreSize,omitempty"`
+	BoundVolumeSnapshotContentName string `json:"boundVolumeSnapshotContentName,omitempty"`
+	// Error is the last error the CSI snapshotter reported, if any.
+	Error string `json:"error,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -36,7 +51,12 @@ type ZSnapshotList struct {
 	Items           []ZSnapshot `json:"items"`
 }
 
-func (in *ZSnapshotSpec) DeepCopyInto(out *ZSnapshotSpec) { *out = *in }
+func (in *ZSnapshotSpec) DeepCopyInto(out *ZSnapshotSpec) {
+	*out = *in
+	if in.Hooks != nil {
+		out.Hooks = in.Hooks.DeepCopy()
+	}
+}
 
 func (in *ZSnapshotSpec) DeepCopy() *ZSnapshotSpec {
 	if in == nil {
@@ -47,7 +67,13 @@ func (in *ZSnapshotSpec) DeepCopy() *ZSnapshotSpec {
 	return out
 }
 
-func (in *ZSnapshotStatus) DeepCopyInto(out *ZSnapshotStatus) { *out = *in }
+func (in *ZSnapshotStatus) DeepCopyInto(out *ZSnapshotStatus) {
+	*out = *in
+	if in.HookResults != nil {
+		out.HookResults = make([]SnapshotHookResult, len(in.HookResults))
+		copy(out.HookResults, in.HookResults)
+	}
+}
 
 func (in *ZSnapshotStatus) DeepCopy() *ZSnapshotStatus {
 	if in == nil {
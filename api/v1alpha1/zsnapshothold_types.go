@@ -0,0 +1,123 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ZSnapshotHoldSpec pins a ZFS snapshot with a user hold ("zfs hold"), so
+// neither retention pruning nor "zfs destroy" can remove it.
+type ZSnapshotHoldSpec struct {
+	NodeName string `json:"nodeName"`
+	// Snapshot is the full snapshot name, e.g. tank/home@GMT-2025.01.01-00.00.00.
+	Snapshot string `json:"snapshot"`
+	// Tag is the hold tag; defaults to nas-<name>.
+	Tag string `json:"tag,omitempty"`
+	// Recursive also holds the snapshot of the same name on every descendant.
+	Recursive bool `json:"recursive,omitempty"`
+	// ExpiresAt (RFC3339) releases the hold automatically once reached.
+	ExpiresAt string `json:"expiresAt,omitempty"`
+}
+
+type ZSnapshotHoldStatus struct {
+	// Phase is Held, Expired or Error.
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Snapshot and Tag record the hold placed, so it can be released after
+	// the spec changes.
+	Snapshot string `json:"snapshot,omitempty"`
+	Tag      string `json:"tag,omitempty"`
+	HeldAt   string `json:"heldAt,omitempty"`
+	// Holds lists every tag on the snapshot, including other holders.
+	Holds []string `json:"holds,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+type ZSnapshotHold struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ZSnapshotHoldSpec   `json:"spec,omitempty"`
+	Status ZSnapshotHoldStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+type ZSnapshotHoldList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ZSnapshotHold `json:"items"`
+}
+
+func (in *ZSnapshotHoldStatus) DeepCopyInto(out *ZSnapshotHoldStatus) {
+	*out = *in
+	if in.Holds != nil {
+		out.Holds = make([]string, len(in.Holds))
+		copy(out.Holds, in.Holds)
+	}
+}
+
+func (in *ZSnapshotHoldStatus) DeepCopy() *ZSnapshotHoldStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ZSnapshotHoldStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *ZSnapshotHold) DeepCopyInto(out *ZSnapshotHold) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+func (in *ZSnapshotHold) DeepCopy() *ZSnapshotHold {
+	if in == nil {
+		return nil
+	}
+	out := new(ZSnapshotHold)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *ZSnapshotHold) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+func (in *ZSnapshotHoldList) DeepCopyInto(out *ZSnapshotHoldList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]ZSnapshotHold, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+}
+
+func (in *ZSnapshotHoldList) DeepCopy() *ZSnapshotHoldList {
+	if in == nil {
+		return nil
+	}
+	out := new(ZSnapshotHoldList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *ZSnapshotHoldList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+func init() {
+	SchemeBuilder.Register(&ZSnapshotHold{}, &ZSnapshotHoldList{})
+}
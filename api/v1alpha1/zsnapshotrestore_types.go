@@ -9,17 +9,30 @@ import (
 //
 // NOTE: This matches the existing CRD schema in config/crd/bases.
 type ZSnapshotRestoreSpec struct {
-	// mode: "clone" (ZFS dataset clone via node-agent) OR "csi" (PVC restore from VolumeSnapshot).
+	// mode: "clone" (ZFS dataset clone via node-agent), "rollback" (zfs rollback of
+	// the snapshot's dataset) OR "csi" (PVC restore from VolumeSnapshot).
 	Mode string `json:"mode"`
 
-	// clone mode
-	NodeName          string `json:"nodeName,omitempty"`
-	SourceSnapshot    string `json:"sourceSnapshot,omitempty"`
-	TargetDataset     string `json:"targetDataset,omitempty"`
-	ForceRollback     bool   `json:"forceRollback,omitempty"`
+	// clone and rollback modes
+	NodeName       string `json:"nodeName,omitempty"`
+	SourceSnapshot string `json:"sourceSnapshot,omitempty"`
+	TargetDataset  string `json:"targetDataset,omitempty"`
+	// ForceRollback destroys snapshots newer than sourceSnapshot (zfs rollback -r).
+	ForceRollback bool `json:"forceRollback,omitempty"`
+	// ConfirmationToken must match the token reported in status before a
+	// rollback runs.
 	ConfirmationToken string `json:"confirmationToken,omitempty"`
 
-	// csi mode
+	// Promote runs zfs promote on the clone so it no longer depends on
+	// sourceSnapshot. Not allowed with ttl or deletionPolicy Delete.
+	Promote bool `json:"promote,omitempty"`
+	// TTL destroys the clone this long after it was created, e.g. "24h" or "7d".
+	TTL string `json:"ttl,omitempty"`
+	// DeletionPolicy Delete destroys the clone with the object; Retain (default) keeps it.
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
+
+	// csi mode. The StorageClass must use the driver that took the
+	// snapshot; resources.requests.storage defaults to its restoreSize.
 	SourceVolumeSnapshot string         `json:"sourceVolumeSnapshot,omitempty"`
 	TargetPVC            string         `json:"targetPVC,omitempty"`
 	StorageClassName     string         `json:"storageClassName,omitempty"`
@@ -32,6 +45,19 @@ type ZSnapshotRestoreStatus struct {
 	Message       string `json:"message,omitempty"`
 	ResultDataset string `json:"resultDataset,omitempty"`
 	ResultPVC     string `json:"resultPVC,omitempty"`
+
+	// ClonedAt is when the clone was created; ExpiresAt is when ttl destroys it.
+	ClonedAt  string `json:"clonedAt,omitempty"`
+	ExpiresAt string `json:"expiresAt,omitempty"`
+	Promoted  bool   `json:"promoted,omitempty"`
+
+	// SafetySnapshot is the copy of the dataset's state taken before a rollback.
+	SafetySnapshot string `json:"safetySnapshot,omitempty"`
+	// PausedShares lists the NASShares (namespace/name) taken offline for a rollback.
+	PausedShares []string `json:"pausedShares,omitempty"`
+	// ConfirmedSnapshot is the snapshot the confirmation token was accepted
+	// for; a rollback in progress only continues while spec still matches it.
+	ConfirmedSnapshot string `json:"confirmedSnapshot,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -71,7 +97,13 @@ func (in *ZSnapshotRestoreSpec) DeepCopy() *ZSnapshotRestoreSpec {
 	return out
 }
 
-func (in *ZSnapshotRestoreStatus) DeepCopyInto(out *ZSnapshotRestoreStatus) { *out = *in }
+func (in *ZSnapshotRestoreStatus) DeepCopyInto(out *ZSnapshotRestoreStatus) {
+	*out = *in
+	if in.PausedShares != nil {
+		out.PausedShares = make([]string, len(in.PausedShares))
+		copy(out.PausedShares, in.PausedShares)
+	}
+}
 
 func (in *ZSnapshotRestoreStatus) DeepCopy() *ZSnapshotRestoreStatus {
 	if in == nil {
@@ -5,25 +5,95 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// Defaults for ZSnapshotScheduleSpec.NamePrefix and Format.
+const (
+	DefaultSnapshotNamePrefix = "GMT"
+	DefaultSnapshotFormat     = "%Y.%m.%d-%H.%M.%S"
+)
+
 // ZSnapshotScheduleSpec defines the desired state of ZSnapshotSchedule.
 //
 // NOTE: This matches the existing CRD schema in config/crd/bases.
+//
+// Snapshot names are NamePrefix, a dash and the strftime Format rendered in
+// TimeZone (an IANA zone such as "Europe/Berlin", default UTC). The schedule
+// is evaluated in the same zone. Ticks in the hour repeated when DST ends
+// are skipped if they would reuse a name from the first pass; %z and %%
+// are never valid in a snapshot name.
 type ZSnapshotScheduleSpec struct {
-	NodeName    string                      `json:"nodeName"`
-	DatasetName string                      `json:"datasetName"`
+	NodeName    string                      `json:"nodeName,omitempty"`
+	DatasetName string                      `json:"datasetName,omitempty"`
 	Recursive   bool                        `json:"recursive,omitempty"`
 	Schedule    string                      `json:"schedule"`
+	TimeZone    string                      `json:"timeZone,omitempty"`
 	NamePrefix  string                      `json:"namePrefix,omitempty"`
 	Format      string                      `json:"format,omitempty"`
 	Retention   *ZSnapshotScheduleRetention `json:"retention,omitempty"`
+
+	// Exclude lists child datasets, relative to DatasetName, that a
+	// recursive schedule never snapshots. Their own children are excluded
+	// too.
+	Exclude []string `json:"exclude,omitempty"`
+	// Suspend stops scheduled snapshots and pruning. A run-now annotation
+	// still takes a snapshot.
+	Suspend bool `json:"suspend,omitempty"`
+	// StartingDeadlineSeconds skips a tick that could not start within this
+	// many seconds of its scheduled time, like CronJob's.
+	StartingDeadlineSeconds *int64 `json:"startingDeadlineSeconds,omitempty"`
+	// CatchUpPolicy decides what happens to ticks missed while the operator
+	// was down: "Once" (default) takes a single snapshot for all of them,
+	// "Skip" drops them and waits for the next tick.
+	CatchUpPolicy string `json:"catchUpPolicy,omitempty"`
+	// RunHistoryLimit bounds status.history (default 10, max 100).
+	RunHistoryLimit int32 `json:"runHistoryLimit,omitempty"`
+	// Hooks quiesce applications around each snapshot.
+	Hooks *SnapshotHooks `json:"hooks,omitempty"`
+
+	// PVCName or PVCSelector snapshot PVCs in the schedule's namespace as
+	// CSI VolumeSnapshots instead of a dataset through the node-agent; the
+	// selector picks up PVCs as they come and go. They cannot be combined
+	// with DatasetName. Retention applies to each PVC's snapshots
+	// separately.
+	PVCName     string            `json:"pvcName,omitempty"`
+	PVCSelector map[string]string `json:"pvcSelector,omitempty"`
+	// SnapshotClassName defaults to nas-zfspv-snapclass.
+	SnapshotClassName string `json:"snapshotClassName,omitempty"`
+}
+
+// ZSnapshotScheduleRun records one run or skipped tick, newest first in
+// status.history.
+type ZSnapshotScheduleRun struct {
+	Time string `json:"time"`
+	// ScheduledTime is the tick the run was for; empty for manual runs.
+	ScheduledTime string `json:"scheduledTime,omitempty"`
+	// Trigger is Schedule, CatchUp, Manual or Skipped.
+	Trigger  string   `json:"trigger"`
+	Snapshot string   `json:"snapshot,omitempty"`
+	Pruned   []string `json:"pruned,omitempty"`
+	Message  string   `json:"message,omitempty"`
+	Error    string   `json:"error,omitempty"`
 }
 
+// ZSnapshotScheduleRetention is grandfather-father-son retention: a snapshot
+// is kept if any rule selects it. KeepHourly/Daily/Weekly/Monthly keep the
+// newest snapshot of each of the most recent N hours, days, ISO weeks and
+// months that have one.
 type ZSnapshotScheduleRetention struct {
 	KeepLast    int64 `json:"keepLast,omitempty"`
 	KeepHourly  int64 `json:"keepHourly,omitempty"`
 	KeepDaily   int64 `json:"keepDaily,omitempty"`
 	KeepWeekly  int64 `json:"keepWeekly,omitempty"`
 	KeepMonthly int64 `json:"keepMonthly,omitempty"`
+	// KeepWithin keeps every snapshot younger than this duration
+	// (e.g. "36h", "7d", "2w").
+	KeepWithin string `json:"keepWithin,omitempty"`
+}
+
+// ZSnapshotCloneDependency is a snapshot retention would prune but cannot
+// destroy because clones were made from it.
+type ZSnapshotCloneDependency struct {
+	Snapshot string   `json:"snapshot"`
+	Clones   []string `json:"clones"`
 }
 
 type ZSnapshotScheduleStatus struct {
@@ -31,6 +101,25 @@ type ZSnapshotScheduleStatus struct {
 	LastRunTime      string `json:"lastRunTime,omitempty"`
 	NextRunTime      string `json:"nextRunTime,omitempty"`
 	Message          string `json:"message,omitempty"`
+	// PruneCandidates is a dry run: the snapshots the next run would destroy.
+	PruneCandidates []string `json:"pruneCandidates,omitempty"`
+	// LastPruned lists the snapshots destroyed by the last run.
+	LastPruned []string `json:"lastPruned,omitempty"`
+	// HeldSnapshots lists snapshots retention would prune but kept because
+	// they carry a hold (see ZSnapshotHold).
+	HeldSnapshots []string `json:"heldSnapshots,omitempty"`
+	// CloneBlockedSnapshots lists snapshots retention would prune but kept
+	// because clones depend on them; promote or destroy the clones to free them.
+	CloneBlockedSnapshots []ZSnapshotCloneDependency `json:"cloneBlockedSnapshots,omitempty"`
+	// LastScheduleTime is the latest tick handled, whether run or skipped.
+	LastScheduleTime string `json:"lastScheduleTime,omitempty"`
+	// LastManualTrigger is the last nas.io/run-now value acted on.
+	LastManualTrigger   string                 `json:"lastManualTrigger,omitempty"`
+	ConsecutiveFailures int32                  `json:"consecutiveFailures,omitempty"`
+	History             []ZSnapshotScheduleRun `json:"history,omitempty"`
+	Conditions          []metav1.Condition     `json:"conditions,omitempty"`
+	// LastHookResults are the hook commands of the last run that had hooks.
+	LastHookResults []SnapshotHookResult `json:"lastHookResults,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -67,6 +156,23 @@ func (in *ZSnapshotScheduleSpec) DeepCopyInto(out *ZSnapshotScheduleSpec) {
 		out.Retention = new(ZSnapshotScheduleRetention)
 		in.Retention.DeepCopyInto(out.Retention)
 	}
+	if in.Exclude != nil {
+		out.Exclude = make([]string, len(in.Exclude))
+		copy(out.Exclude, in.Exclude)
+	}
+	if in.StartingDeadlineSeconds != nil {
+		v := *in.StartingDeadlineSeconds
+		out.StartingDeadlineSeconds = &v
+	}
+	if in.Hooks != nil {
+		out.Hooks = in.Hooks.DeepCopy()
+	}
+	if in.PVCSelector != nil {
+		out.PVCSelector = make(map[string]string, len(in.PVCSelector))
+		for k, v := range in.PVCSelector {
+			out.PVCSelector[k] = v
+		}
+	}
 }
 
 func (in *ZSnapshotScheduleSpec) DeepCopy() *ZSnapshotScheduleSpec {
@@ -78,7 +184,77 @@ func (in *ZSnapshotScheduleSpec) DeepCopy() *ZSnapshotScheduleSpec {
 	return out
 }
 
-func (in *ZSnapshotScheduleStatus) DeepCopyInto(out *ZSnapshotScheduleStatus) { *out = *in }
+func (in *ZSnapshotScheduleRun) DeepCopyInto(out *ZSnapshotScheduleRun) {
+	*out = *in
+	if in.Pruned != nil {
+		out.Pruned = make([]string, len(in.Pruned))
+		copy(out.Pruned, in.Pruned)
+	}
+}
+
+func (in *ZSnapshotScheduleRun) DeepCopy() *ZSnapshotScheduleRun {
+	if in == nil {
+		return nil
+	}
+	out := new(ZSnapshotScheduleRun)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *ZSnapshotScheduleStatus) DeepCopyInto(out *ZSnapshotScheduleStatus) {
+	*out = *in
+	if in.History != nil {
+		out.History = make([]ZSnapshotScheduleRun, len(in.History))
+		for i := range in.History {
+			in.History[i].DeepCopyInto(&out.History[i])
+		}
+	}
+	if in.Conditions != nil {
+		out.Conditions = make([]metav1.Condition, len(in.Conditions))
+		for i := range in.Conditions {
+			in.Conditions[i].DeepCopyInto(&out.Conditions[i])
+		}
+	}
+	if in.PruneCandidates != nil {
+		out.PruneCandidates = make([]string, len(in.PruneCandidates))
+		copy(out.PruneCandidates, in.PruneCandidates)
+	}
+	if in.LastPruned != nil {
+		out.LastPruned = make([]string, len(in.LastPruned))
+		copy(out.LastPruned, in.LastPruned)
+	}
+	if in.HeldSnapshots != nil {
+		out.HeldSnapshots = make([]string, len(in.HeldSnapshots))
+		copy(out.HeldSnapshots, in.HeldSnapshots)
+	}
+	if in.LastHookResults != nil {
+		out.LastHookResults = make([]SnapshotHookResult, len(in.LastHookResults))
+		copy(out.LastHookResults, in.LastHookResults)
+	}
+	if in.CloneBlockedSnapshots != nil {
+		out.CloneBlockedSnapshots = make([]ZSnapshotCloneDependency, len(in.CloneBlockedSnapshots))
+		for i := range in.CloneBlockedSnapshots {
+			in.CloneBlockedSnapshots[i].DeepCopyInto(&out.CloneBlockedSnapshots[i])
+		}
+	}
+}
+
+func (in *ZSnapshotCloneDependency) DeepCopyInto(out *ZSnapshotCloneDependency) {
+	*out = *in
+	if in.Clones != nil {
+		out.Clones = make([]string, len(in.Clones))
+		copy(out.Clones, in.Clones)
+	}
+}
+
+func (in *ZSnapshotCloneDependency) DeepCopy() *ZSnapshotCloneDependency {
+	if in == nil {
+		return nil
+	}
+	out := new(ZSnapshotCloneDependency)
+	in.DeepCopyInto(out)
+	return out
+}
 
 func (in *ZSnapshotScheduleStatus) DeepCopy() *ZSnapshotScheduleStatus {
 	if in == nil {
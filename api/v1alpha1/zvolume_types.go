@@ -0,0 +1,141 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ZVolumeSpec defines a ZFS volume (zvol) exposed as a block device.
+type ZVolumeSpec struct {
+	NodeName string `json:"nodeName"`
+	// Pool is the ZFS pool (or parent dataset) holding the zvol.
+	Pool string `json:"pool"`
+	// Name is the zvol name under Pool; the full name is <pool>/<name>.
+	Name string `json:"name"`
+	// Size is the volsize (e.g. "100G"). Increasing it grows the zvol online;
+	// shrinking is refused.
+	Size string `json:"size"`
+	// VolBlockSize is fixed at creation (e.g. "16K").
+	VolBlockSize string `json:"volblocksize,omitempty"`
+	// Sparse creates a thin-provisioned zvol without a refreservation.
+	Sparse      bool              `json:"sparse,omitempty"`
+	Compression string            `json:"compression,omitempty"`
+	Properties  map[string]string `json:"properties,omitempty"`
+	// DeletionPolicy is Retain (default) or Delete. Delete destroys the zvol
+	// when the ZVolume is deleted.
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
+}
+
+type ZVolumeStatus struct {
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message,omitempty"`
+	// DevicePath is the block device on the node, e.g. /dev/zvol/tank/vm1.
+	DevicePath      string `json:"devicePath,omitempty"`
+	SizeBytes       int64  `json:"sizeBytes,omitempty"`
+	UsedBytes       int64  `json:"usedBytes,omitempty"`
+	ReferencedBytes int64  `json:"referencedBytes,omitempty"`
+	VolBlockSize    string `json:"volblocksize,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+type ZVolume struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ZVolumeSpec   `json:"spec,omitempty"`
+	Status ZVolumeStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+type ZVolumeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ZVolume `json:"items"`
+}
+
+func (in *ZVolumeSpec) DeepCopyInto(out *ZVolumeSpec) {
+	*out = *in
+	if in.Properties != nil {
+		out.Properties = make(map[string]string, len(in.Properties))
+		for k, v := range in.Properties {
+			out.Properties[k] = v
+		}
+	}
+}
+
+func (in *ZVolumeSpec) DeepCopy() *ZVolumeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ZVolumeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *ZVolumeStatus) DeepCopyInto(out *ZVolumeStatus) { *out = *in }
+
+func (in *ZVolumeStatus) DeepCopy() *ZVolumeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ZVolumeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *ZVolume) DeepCopyInto(out *ZVolume) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+func (in *ZVolume) DeepCopy() *ZVolume {
+	if in == nil {
+		return nil
+	}
+	out := new(ZVolume)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *ZVolume) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+func (in *ZVolumeList) DeepCopyInto(out *ZVolumeList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]ZVolume, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+}
+
+func (in *ZVolumeList) DeepCopy() *ZVolumeList {
+	if in == nil {
+		return nil
+	}
+	out := new(ZVolumeList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *ZVolumeList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+func init() {
+	SchemeBuilder.Register(&ZVolume{}, &ZVolumeList{})
+}
@@ -3,19 +3,28 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/user"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -84,6 +93,74 @@ type NFSSSSDApplyResponse struct {
 	Error  string `json:"error,omitempty"`
 }
 
+type ISCSICHAP struct {
+	UserID         string `json:"userid"`
+	Password       string `json:"password"`
+	MutualUserID   string `json:"mutualUserid,omitempty"`
+	MutualPassword string `json:"mutualPassword,omitempty"`
+}
+
+type ISCSITargetRequest struct {
+	IQN string `json:"iqn"`
+	// Backstore is the LIO block backstore name bound to Device.
+	Backstore  string     `json:"backstore"`
+	Device     string     `json:"device,omitempty"`
+	LUN        int        `json:"lun,omitempty"`
+	ReadOnly   bool       `json:"readOnly,omitempty"`
+	Portals    []string   `json:"portals,omitempty"`
+	Initiators []string   `json:"initiators,omitempty"`
+	CHAP       *ISCSICHAP `json:"chap,omitempty"`
+}
+
+type ISCSISession struct {
+	Initiator string   `json:"initiator"`
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+type ISCSITargetResponse struct {
+	OK       bool           `json:"ok"`
+	IQN      string         `json:"iqn,omitempty"`
+	Portals  []string       `json:"portals,omitempty"`
+	Changed  []string       `json:"changed,omitempty"`
+	Sessions []ISCSISession `json:"sessions,omitempty"`
+	Output   string         `json:"output,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+type NVMeoFRequest struct {
+	NQN     string   `json:"nqn"`
+	NSID    int      `json:"nsid,omitempty"`
+	Device  string   `json:"device,omitempty"`
+	Portals []string `json:"portals,omitempty"`
+	Hosts   []string `json:"hosts,omitempty"`
+}
+
+type NVMeoFNamespace struct {
+	NSID    int    `json:"nsid"`
+	Device  string `json:"device,omitempty"`
+	Enabled bool   `json:"enabled"`
+}
+
+type NVMeoFController struct {
+	ID      string `json:"id"`
+	HostNQN string `json:"hostnqn,omitempty"`
+	Address string `json:"address,omitempty"`
+	State   string `json:"state,omitempty"`
+}
+
+type NVMeoFResponse struct {
+	OK         bool              `json:"ok"`
+	NQN        string            `json:"nqn,omitempty"`
+	Changed    []string          `json:"changed,omitempty"`
+	Namespaces []NVMeoFNamespace `json:"namespaces,omitempty"`
+	Portals    []string          `json:"portals,omitempty"`
+	Hosts      []string          `json:"hosts,omitempty"`
+	// ControllersKnown is false when nvmet debugfs is unavailable.
+	ControllersKnown bool               `json:"controllersKnown"`
+	Controllers      []NVMeoFController `json:"controllers,omitempty"`
+	Error            string             `json:"error,omitempty"`
+}
+
 var diskCache struct {
 	mu      sync.RWMutex
 	disks   []Disk
@@ -92,6 +169,112 @@ var diskCache struct {
 
 var diskRefreshCh = make(chan struct{}, 1)
 
+// aclResets tracks recursive ACL resets by dataset. Only the latest token per
+// dataset is kept.
+var aclResets struct {
+	mu   sync.Mutex
+	jobs map[string]*ZDatasetACLResetStatus
+}
+
+// jobTable runs background jobs of type J under a caller-chosen token, so a
+// repeated start reports the job instead of restarting it and the caller can
+// poll. S is the status reported for a job, copied under the lock. Finished
+// jobs are kept for a day so callers can read the results.
+type jobTable[J, S any] struct {
+	mu   sync.Mutex
+	jobs map[string]*J
+	// finished returns when the job finished (RFC 3339), or "" while it runs.
+	finished func(*J) string
+	view     func(*J) S
+}
+
+// start returns the status of the job under token or, if there is none,
+// registers the job prepare returns and runs work in the background. prepare
+// runs with the table locked, so two starts cannot both begin a job; the
+// function work returns records the outcome and also runs locked.
+func (t *jobTable[J, S]) start(token string, prepare func() (*J, error), work func(*J) func()) (S, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if job, ok := t.jobs[token]; ok {
+		return t.view(job), nil
+	}
+	for k, job := range t.jobs {
+		if done, err := time.Parse(time.RFC3339, t.finished(job)); err == nil && time.Since(done) > 24*time.Hour {
+			delete(t.jobs, k)
+		}
+	}
+	job, err := prepare()
+	if err != nil {
+		var zero S
+		return zero, err
+	}
+	if t.jobs == nil {
+		t.jobs = map[string]*J{}
+	}
+	t.jobs[token] = job
+	go func() {
+		record := work(job)
+		t.mu.Lock()
+		record()
+		t.mu.Unlock()
+	}()
+	return t.view(job), nil
+}
+
+// get returns the status of the job under token.
+func (t *jobTable[J, S]) get(token string) (S, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job, ok := t.jobs[token]
+	if !ok {
+		var zero S
+		return zero, false
+	}
+	return t.view(job), true
+}
+
+// fileRestores tracks snapshot file restores by token (the caller's UID).
+var fileRestores = &jobTable[SnapshotFileRestoreStatus, SnapshotFileRestoreStatus]{
+	finished: func(j *SnapshotFileRestoreStatus) string { return j.Finished },
+	view:     func(j *SnapshotFileRestoreStatus) SnapshotFileRestoreStatus { return *j },
+}
+
+// rollbacks tracks dataset rollbacks by token (the caller's UID).
+var rollbacks = &jobTable[ZSnapshotRollbackStatus, ZSnapshotRollbackStatus]{
+	finished: func(j *ZSnapshotRollbackStatus) string { return j.Finished },
+	view:     func(j *ZSnapshotRollbackStatus) ZSnapshotRollbackStatus { return *j },
+}
+
+// replicationSends tracks outgoing replication streams by token.
+var replicationSends = &jobTable[replicationSend, ZReplicationStatus]{
+	finished: func(j *replicationSend) string { return j.status.Finished },
+	view: func(j *replicationSend) ZReplicationStatus {
+		st := j.status
+		st.Bytes = j.bytes.Load()
+		return st
+	},
+}
+
+type replicationSend struct {
+	status ZReplicationStatus
+	bytes  atomic.Int64
+}
+
+// replicationReceives holds registered receives until a stream claims them.
+var replicationReceives struct {
+	mu      sync.Mutex
+	pending map[string]pendingReceive
+}
+
+type pendingReceive struct {
+	req     ZReplicationReceiveRequest
+	expires time.Time
+}
+
+// replicationReceiveTTL bounds how long a registered receive waits for its
+// stream.
+const replicationReceiveTTL = 10 * time.Minute
+
 const nfsExportsPath = "/etc/exports.d/nas.exports"
 
 // Legacy pool create (kept for backward compatibility)
@@ -165,6 +348,13 @@ type ZDatasetEnsureRequest struct {
 	Mountpoint string            `json:"mountpoint,omitempty"` // optional
 	Preset     string            `json:"preset,omitempty"`
 	Properties map[string]string `json:"properties,omitempty"`
+	Owner      *DatasetOwner     `json:"owner,omitempty"`
+}
+
+type DatasetOwner struct {
+	UID  int64  `json:"uid"`
+	GID  int64  `json:"gid"`
+	Mode string `json:"mode,omitempty"`
 }
 
 type ZDatasetEnsureRequestV2 struct {
@@ -183,9 +373,128 @@ type ZDatasetMountRequest struct {
 }
 
 type ZDatasetStatusResponse struct {
-	OK     bool   `json:"ok"`
-	Output string `json:"output,omitempty"`
-	Error  string `json:"error,omitempty"`
+	OK         bool   `json:"ok"`
+	Mountpoint string `json:"mountpoint,omitempty"`
+	Output     string `json:"output,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type ZDatasetRenameRequest struct {
+	Source     string `json:"source"`
+	Target     string `json:"target"`
+	Mountpoint string `json:"mountpoint,omitempty"` // optional, applied after rename
+}
+
+type ZDatasetRenameResponse struct {
+	OK            bool   `json:"ok"`
+	OldMountpoint string `json:"oldMountpoint,omitempty"`
+	NewMountpoint string `json:"newMountpoint,omitempty"`
+	Output        string `json:"output,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+type ZDatasetQuotaEntry struct {
+	ID    int64  `json:"id"`
+	Quota string `json:"quota"` // zfs size, or "none" to clear
+}
+
+type ZDatasetQuotaRequest struct {
+	Dataset     string               `json:"dataset"`
+	UserQuotas  []ZDatasetQuotaEntry `json:"userQuotas,omitempty"`
+	GroupQuotas []ZDatasetQuotaEntry `json:"groupQuotas,omitempty"`
+}
+
+type ZDatasetQuotaUsage struct {
+	ID    int64 `json:"id"`
+	Used  int64 `json:"used"`
+	Quota int64 `json:"quota,omitempty"`
+}
+
+type ZDatasetQuotaResponse struct {
+	OK     bool                 `json:"ok"`
+	Users  []ZDatasetQuotaUsage `json:"users,omitempty"`
+	Groups []ZDatasetQuotaUsage `json:"groups,omitempty"`
+	Output string               `json:"output,omitempty"`
+	Error  string               `json:"error,omitempty"`
+}
+
+// DatasetACE is one NFSv4 or POSIX ACE. Principal is OWNER@, GROUP@,
+// EVERYONE@ (MASK@ for posix), a numeric id, or a directory name such as
+// alice@example.com.
+type DatasetACE struct {
+	Type      string   `json:"type,omitempty"` // allow (default) or deny; nfsv4 only
+	Principal string   `json:"principal"`
+	Group     bool     `json:"group,omitempty"`
+	Perms     string   `json:"perms"`             // full|modify|read|traverse, nfs4 letters or rwx
+	Flags     []string `json:"flags,omitempty"`   // nfsv4 only
+	Default   bool     `json:"default,omitempty"` // posix only (setfacl -d)
+}
+
+type DatasetACLPath struct {
+	Path string       `json:"path,omitempty"` // relative to the mountpoint; empty is the root
+	ACEs []DatasetACE `json:"aces"`
+}
+
+type ZDatasetACLRequest struct {
+	Dataset    string           `json:"dataset"`
+	Mountpoint string           `json:"mountpoint,omitempty"`
+	Type       string           `json:"type,omitempty"` // nfsv4 (default) or posix; must match acltype
+	Paths      []DatasetACLPath `json:"paths"`
+	// ResetToken starts a recursive reset in the background; repeat the
+	// request with the same token to poll it.
+	ResetToken string `json:"resetToken,omitempty"`
+}
+
+type ZDatasetACLResetStatus struct {
+	Token    string `json:"token"`
+	State    string `json:"state"` // Running, Complete, Failed
+	Message  string `json:"message,omitempty"`
+	Started  string `json:"started,omitempty"`
+	Finished string `json:"finished,omitempty"`
+}
+
+type ZDatasetACLResponse struct {
+	OK      bool                    `json:"ok"`
+	Changed []string                `json:"changed,omitempty"`
+	Reset   *ZDatasetACLResetStatus `json:"reset,omitempty"`
+	Output  string                  `json:"output,omitempty"`
+	Error   string                  `json:"error,omitempty"`
+}
+
+type ZVolumeEnsureRequest struct {
+	Name         string            `json:"name"` // full name, e.g. "tank/vm1"
+	Size         string            `json:"size"`
+	VolBlockSize string            `json:"volblocksize,omitempty"`
+	Sparse       bool              `json:"sparse,omitempty"`
+	Compression  string            `json:"compression,omitempty"`
+	Properties   map[string]string `json:"properties,omitempty"`
+}
+
+type ZVolumeResizeRequest struct {
+	Name string `json:"name"`
+	Size string `json:"size"`
+}
+
+type ZVolumeDestroyRequest struct {
+	Name string `json:"name"`
+}
+
+type ZVolumeInfo struct {
+	Name         string `json:"name"`
+	DevicePath   string `json:"devicePath"`
+	SizeBytes    int64  `json:"sizeBytes"`
+	UsedBytes    int64  `json:"usedBytes"`
+	Referenced   int64  `json:"referencedBytes"`
+	VolBlockSize string `json:"volblocksize,omitempty"`
+}
+
+type ZVolumeResponse struct {
+	OK      bool         `json:"ok"`
+	Volume  *ZVolumeInfo `json:"volume,omitempty"`
+	Created bool         `json:"created,omitempty"`
+	Resized bool         `json:"resized,omitempty"`
+	Output  string       `json:"output,omitempty"`
+	Error   string       `json:"error,omitempty"`
 }
 
 type ZPoolDestroyRequest struct {
@@ -196,10 +505,213 @@ type ZSnapshotCreateRequest struct {
 	Dataset   string `json:"dataset"`
 	Name      string `json:"name"`
 	Recursive bool   `json:"recursive,omitempty"`
+	// Exclude names descendant datasets (and their children) left out of a
+	// recursive snapshot.
+	Exclude []string `json:"exclude,omitempty"`
 }
 
 type ZSnapshotDestroyRequest struct {
 	Snapshot string `json:"snapshot"`
+	// Recursive destroys the snapshot of the same name on every descendant.
+	Recursive bool `json:"recursive,omitempty"`
+}
+
+type ZSnapshotInfo struct {
+	Name    string `json:"name"`
+	Dataset string `json:"dataset,omitempty"`
+	// Creation is the unix time the snapshot was taken.
+	Creation        int64 `json:"creation"`
+	UsedBytes       int64 `json:"usedBytes"`
+	ReferencedBytes int64 `json:"referencedBytes"`
+	// WrittenBytes is the data written to the dataset between the previous
+	// snapshot and this one.
+	WrittenBytes int64    `json:"writtenBytes"`
+	Holds        []string `json:"holds,omitempty"`
+	Clones       []string `json:"clones,omitempty"`
+}
+
+type ZSnapshotDetailsResponse struct {
+	OK    bool            `json:"ok"`
+	Error string          `json:"error,omitempty"`
+	Items []ZSnapshotInfo `json:"items,omitempty"`
+}
+
+type ZSnapshotStatusResponse struct {
+	OK       bool           `json:"ok"`
+	Error    string         `json:"error,omitempty"`
+	Exists   bool           `json:"exists"`
+	Snapshot *ZSnapshotInfo `json:"snapshot,omitempty"`
+}
+
+// SnapshotFileEntry describes a path inside a snapshot. Path is relative to
+// the dataset root.
+type SnapshotFileEntry struct {
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	Type       string `json:"type"` // file, directory, symlink, other
+	Size       int64  `json:"size"`
+	Mode       string `json:"mode"`
+	UID        uint32 `json:"uid"`
+	GID        uint32 `json:"gid"`
+	ModTime    string `json:"modTime"`
+	LinkTarget string `json:"linkTarget,omitempty"`
+}
+
+type SnapshotFilesResponse struct {
+	OK       bool                `json:"ok"`
+	Error    string              `json:"error,omitempty"`
+	Snapshot string              `json:"snapshot,omitempty"`
+	Entry    *SnapshotFileEntry  `json:"entry,omitempty"`
+	Entries  []SnapshotFileEntry `json:"entries,omitempty"`
+}
+
+type SnapshotFileRestoreRequest struct {
+	Token    string   `json:"token"`
+	Snapshot string   `json:"snapshot"`
+	Paths    []string `json:"paths"`
+	// TargetDirectory, relative to the dataset root, receives the files
+	// under their original relative paths; empty restores in place.
+	TargetDirectory string `json:"targetDirectory,omitempty"`
+	// ConflictPolicy is overwrite, rename or skip.
+	ConflictPolicy string `json:"conflictPolicy,omitempty"`
+}
+
+type SnapshotFileRestoreResult struct {
+	Path        string `json:"path"`
+	Destination string `json:"destination,omitempty"`
+	Action      string `json:"action"` // restored, renamed, skipped, failed
+	Error       string `json:"error,omitempty"`
+}
+
+type SnapshotFileRestoreStatus struct {
+	Token    string                      `json:"token"`
+	State    string                      `json:"state"` // Running, Complete, Failed
+	Message  string                      `json:"message,omitempty"`
+	Started  string                      `json:"started,omitempty"`
+	Finished string                      `json:"finished,omitempty"`
+	Results  []SnapshotFileRestoreResult `json:"results,omitempty"`
+}
+
+type SnapshotFileRestoreResponse struct {
+	OK      bool                       `json:"ok"`
+	Error   string                     `json:"error,omitempty"`
+	Restore *SnapshotFileRestoreStatus `json:"restore,omitempty"`
+}
+
+type ZSnapshotRollbackRequest struct {
+	Token    string `json:"token"`
+	Snapshot string `json:"snapshot"`
+	// Force passes -r, destroying snapshots newer than Snapshot.
+	Force bool `json:"force,omitempty"`
+	// SafetyName is the snapshot taken of the current state first. It is
+	// copied to SafetyDataset, since the rollback would destroy it in place.
+	SafetyName    string `json:"safetyName"`
+	SafetyDataset string `json:"safetyDataset"`
+	// CopyOnly takes the full safety copy, from SafetyName-base, and stops.
+	// It is meant to run while the dataset is still shared; the request
+	// without CopyOnly then only copies the changes since and rolls back.
+	CopyOnly bool `json:"copyOnly,omitempty"`
+}
+
+type ZSnapshotRollbackStatus struct {
+	Token          string `json:"token"`
+	State          string `json:"state"` // Running, Complete, Failed
+	Message        string `json:"message,omitempty"`
+	Started        string `json:"started,omitempty"`
+	Finished       string `json:"finished,omitempty"`
+	SafetySnapshot string `json:"safetySnapshot,omitempty"`
+}
+
+type ZSnapshotRollbackResponse struct {
+	OK       bool                     `json:"ok"`
+	Error    string                   `json:"error,omitempty"`
+	Rollback *ZSnapshotRollbackStatus `json:"rollback,omitempty"`
+}
+
+// ZReplicationReceiveRequest registers a receive on the target node-agent.
+// The first stream that presents Token and Secret is received into Dataset;
+// the registration is then spent.
+type ZReplicationReceiveRequest struct {
+	Token   string `json:"token"`
+	Secret  string `json:"secret"`
+	Dataset string `json:"dataset"`
+	// Base names the snapshot (without dataset) an incremental stream starts
+	// from. The target and its children are rolled back to it before the
+	// receive, discarding changes made there since; empty for a full stream.
+	Base string `json:"base,omitempty"`
+}
+
+// ZReplicationSendRequest streams Snapshot, incrementally from Base if set,
+// to the receive registered under Token on the node-agent at TargetURL.
+type ZReplicationSendRequest struct {
+	Token     string `json:"token"`
+	Snapshot  string `json:"snapshot"`
+	Base      string `json:"base,omitempty"`
+	Recursive bool   `json:"recursive,omitempty"`
+	// Raw sends encrypted datasets as stored (zfs send -w).
+	Raw       bool   `json:"raw,omitempty"`
+	TargetURL string `json:"targetURL"`
+	Secret    string `json:"secret"`
+}
+
+type ZReplicationStatus struct {
+	Token    string `json:"token"`
+	State    string `json:"state"` // Running, Complete, Failed
+	Message  string `json:"message,omitempty"`
+	Bytes    int64  `json:"bytes"`
+	Started  string `json:"started,omitempty"`
+	Finished string `json:"finished,omitempty"`
+}
+
+type ZReplicationResponse struct {
+	OK          bool                `json:"ok"`
+	Error       string              `json:"error,omitempty"`
+	Replication *ZReplicationStatus `json:"replication,omitempty"`
+}
+
+// ZReplicationTargetResponse describes a replication target dataset.
+// Snapshots are its own snapshot names (after "@"), oldest first.
+type ZReplicationTargetResponse struct {
+	OK        bool     `json:"ok"`
+	Error     string   `json:"error,omitempty"`
+	Exists    bool     `json:"exists"`
+	Snapshots []string `json:"snapshots,omitempty"`
+}
+
+// ZSnapshotDiffEntry is one line of "zfs diff -FH". The diff endpoint
+// streams these as newline-delimited JSON; a failure after the first line is
+// reported as a final entry with only Error set.
+type ZSnapshotDiffEntry struct {
+	// Change is added, removed, modified or renamed.
+	Change string `json:"change,omitempty"`
+	// FileType is file, directory, symlink, block, char, fifo, socket, door
+	// or port.
+	FileType string `json:"fileType,omitempty"`
+	Path     string `json:"path,omitempty"`
+	// RelativePath is Path relative to the dataset mountpoint.
+	RelativePath string `json:"relativePath,omitempty"`
+	// NewPath is the rename target.
+	NewPath string `json:"newPath,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type ZSnapshotHoldRequest struct {
+	Snapshot  string `json:"snapshot"`
+	Tag       string `json:"tag"`
+	Recursive bool   `json:"recursive,omitempty"`
+}
+
+type ZSnapshotHold struct {
+	Snapshot string `json:"snapshot"`
+	Tag      string `json:"tag"`
+	// Created is the unix time the hold was placed.
+	Created int64 `json:"created,omitempty"`
+}
+
+type ZSnapshotHoldsResponse struct {
+	OK    bool            `json:"ok"`
+	Error string          `json:"error,omitempty"`
+	Items []ZSnapshotHold `json:"items,omitempty"`
 }
 
 type ZSnapshotCloneRequest struct {
@@ -207,6 +719,12 @@ type ZSnapshotCloneRequest struct {
 	TargetDataset  string `json:"targetDataset"`
 }
 
+// ZCloneRequest names a clone for promote and destroy. Both refuse datasets
+// that are not clones, so they cannot be pointed at ordinary data.
+type ZCloneRequest struct {
+	Dataset string `json:"dataset"`
+}
+
 type ZSnapshotListResponse struct {
 	OK    bool     `json:"ok"`
 	Error string   `json:"error,omitempty"`
@@ -374,6 +892,152 @@ func main() {
 		writeJSON(w, http.StatusOK, NFSExportResponse{OK: true, Path: req.Path, Output: out})
 	})
 
+	// ----- iSCSI targets -----
+	mux.HandleFunc("/v1/iscsi/target/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		iqn := strings.TrimSpace(r.URL.Query().Get("iqn"))
+		if !iscsiNameRe.MatchString(iqn) {
+			writeJSON(w, http.StatusBadRequest, ISCSITargetResponse{OK: false, Error: "valid iqn required"})
+			return
+		}
+		if !fileExists(iscsiTPGPath(iqn)) {
+			writeJSON(w, http.StatusNotFound, ISCSITargetResponse{OK: false, IQN: iqn, Error: "target not found"})
+			return
+		}
+		sessions, err := iscsiSessions(iqn)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ISCSITargetResponse{OK: false, IQN: iqn, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, ISCSITargetResponse{OK: true, IQN: iqn, Portals: iscsiPortals(iqn), Sessions: sessions})
+	})
+
+	mux.HandleFunc("/v1/iscsi/target/ensure", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ISCSITargetRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ISCSITargetResponse{OK: false, Error: "invalid json"})
+			return
+		}
+		if err := validateISCSITarget(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ISCSITargetResponse{OK: false, IQN: req.IQN, Error: err.Error()})
+			return
+		}
+		changed, out, err := ensureISCSITarget(req)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ISCSITargetResponse{OK: false, IQN: req.IQN, Changed: changed, Output: out, Error: err.Error()})
+			return
+		}
+		sessions, err := iscsiSessions(req.IQN)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ISCSITargetResponse{OK: false, IQN: req.IQN, Changed: changed, Output: out, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, ISCSITargetResponse{OK: true, IQN: req.IQN, Portals: iscsiPortals(req.IQN), Changed: changed, Sessions: sessions, Output: out})
+	})
+
+	mux.HandleFunc("/v1/iscsi/target/delete", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ISCSITargetRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ISCSITargetResponse{OK: false, Error: "invalid json"})
+			return
+		}
+		req.IQN = strings.TrimSpace(req.IQN)
+		req.Backstore = strings.TrimSpace(req.Backstore)
+		if req.IQN != "" && !iscsiNameRe.MatchString(req.IQN) {
+			writeJSON(w, http.StatusBadRequest, ISCSITargetResponse{OK: false, Error: "invalid iqn"})
+			return
+		}
+		if req.Backstore != "" && !iscsiBackstoreRe.MatchString(req.Backstore) {
+			writeJSON(w, http.StatusBadRequest, ISCSITargetResponse{OK: false, Error: "invalid backstore name"})
+			return
+		}
+		changed, out, err := deleteISCSITarget(req.IQN, req.Backstore)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ISCSITargetResponse{OK: false, IQN: req.IQN, Changed: changed, Output: out, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, ISCSITargetResponse{OK: true, IQN: req.IQN, Changed: changed, Output: out})
+	})
+
+	// ----- NVMe-oF (nvmet) -----
+	mux.HandleFunc("/v1/nvmeof/subsystem/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		nqn := strings.TrimSpace(r.URL.Query().Get("nqn"))
+		if !nvmeNQNRe.MatchString(nqn) {
+			writeJSON(w, http.StatusBadRequest, NVMeoFResponse{OK: false, Error: "valid nqn required"})
+			return
+		}
+		if !fileExists(nvmetSubsysPath(nqn)) {
+			writeJSON(w, http.StatusNotFound, NVMeoFResponse{OK: false, NQN: nqn, Error: "subsystem not found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, nvmeofStatus(nqn))
+	})
+
+	// Every mutating endpoint takes an NVMeoFRequest and answers with the
+	// resulting subsystem state.
+	nvmeofHandler := func(apply func(req NVMeoFRequest) ([]string, error)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			var req NVMeoFRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSON(w, http.StatusBadRequest, NVMeoFResponse{OK: false, Error: "invalid json"})
+				return
+			}
+			req.NQN = strings.TrimSpace(req.NQN)
+			if !nvmeNQNRe.MatchString(req.NQN) {
+				writeJSON(w, http.StatusBadRequest, NVMeoFResponse{OK: false, Error: "valid nqn required"})
+				return
+			}
+			changed, err := apply(req)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, NVMeoFResponse{OK: false, NQN: req.NQN, Changed: changed, Error: err.Error()})
+				return
+			}
+			resp := NVMeoFResponse{OK: true, NQN: req.NQN}
+			if fileExists(nvmetSubsysPath(req.NQN)) {
+				resp = nvmeofStatus(req.NQN)
+			}
+			resp.Changed = changed
+			writeJSON(w, http.StatusOK, resp)
+		}
+	}
+	mux.HandleFunc("/v1/nvmeof/subsystem/ensure", nvmeofHandler(func(req NVMeoFRequest) ([]string, error) {
+		return ensureNVMeoFSubsystem(req.NQN)
+	}))
+	mux.HandleFunc("/v1/nvmeof/subsystem/delete", nvmeofHandler(func(req NVMeoFRequest) ([]string, error) {
+		return deleteNVMeoFSubsystem(req.NQN)
+	}))
+	mux.HandleFunc("/v1/nvmeof/namespace/ensure", nvmeofHandler(func(req NVMeoFRequest) ([]string, error) {
+		return ensureNVMeoFNamespace(req.NQN, req.NSID, req.Device)
+	}))
+	mux.HandleFunc("/v1/nvmeof/namespace/delete", nvmeofHandler(func(req NVMeoFRequest) ([]string, error) {
+		return deleteNVMeoFNamespace(req.NQN, req.NSID)
+	}))
+	mux.HandleFunc("/v1/nvmeof/port/apply", nvmeofHandler(func(req NVMeoFRequest) ([]string, error) {
+		return applyNVMeoFPorts(req.NQN, req.Portals)
+	}))
+	mux.HandleFunc("/v1/nvmeof/host/apply", nvmeofHandler(func(req NVMeoFRequest) ([]string, error) {
+		return applyNVMeoFHosts(req.NQN, req.Hosts)
+	}))
+
 	// ----- Pools -----
 	// legacy list
 	mux.HandleFunc("/v1/zfs/pool/list", func(w http.ResponseWriter, r *http.Request) {
@@ -528,12 +1192,25 @@ func main() {
 			writeJSON(w, http.StatusBadRequest, ZDatasetStatusResponse{OK: false, Error: "dataset required"})
 			return
 		}
+		if req.Owner != nil && strings.TrimSpace(req.Owner.Mode) != "" && !isOctalMode(strings.TrimSpace(req.Owner.Mode)) {
+			writeJSON(w, http.StatusBadRequest, ZDatasetStatusResponse{OK: false, Error: "owner.mode must be octal (e.g. 0700)"})
+			return
+		}
 		out, err := ensureDataset(req.Dataset, req.Mountpoint, req.Preset, req.Properties)
 		if err != nil {
 			writeJSON(w, http.StatusInternalServerError, ZDatasetStatusResponse{OK: false, Output: out, Error: err.Error()})
 			return
 		}
-		writeJSON(w, http.StatusOK, ZDatasetStatusResponse{OK: true, Output: out})
+		if req.Owner != nil {
+			ownOut, err := ensureDatasetOwner(req.Dataset, req.Mountpoint, *req.Owner)
+			out = strings.TrimSpace(out + "\n" + ownOut)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, ZDatasetStatusResponse{OK: false, Output: out, Error: err.Error()})
+				return
+			}
+		}
+		mp, _ := getDatasetMountpoint(req.Dataset)
+		writeJSON(w, http.StatusOK, ZDatasetStatusResponse{OK: true, Mountpoint: mp, Output: out})
 	})
 
 	// v2 ensure
@@ -587,48 +1264,271 @@ func main() {
 		writeJSON(w, http.StatusOK, ZDatasetStatusResponse{OK: true, Output: out})
 	})
 
-	// ----- Snapshots -----
-	mux.HandleFunc("/v1/zfs/snapshot/list", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
+	mux.HandleFunc("/v1/zfs/dataset/rename", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		ds := strings.TrimSpace(r.URL.Query().Get("dataset"))
-		items, out, err := listSnapshotNames(ds)
+		var req ZDatasetRenameRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ZDatasetRenameResponse{OK: false, Error: "invalid json"})
+			return
+		}
+		if strings.TrimSpace(req.Source) == "" || strings.TrimSpace(req.Target) == "" {
+			writeJSON(w, http.StatusBadRequest, ZDatasetRenameResponse{OK: false, Error: "source and target required"})
+			return
+		}
+		resp, err := renameDataset(req.Source, req.Target, req.Mountpoint)
 		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, ZSnapshotListResponse{OK: false, Error: err.Error()})
+			resp.OK = false
+			resp.Error = err.Error()
+			writeJSON(w, http.StatusInternalServerError, resp)
 			return
 		}
-		_ = out
-		writeJSON(w, http.StatusOK, ZSnapshotListResponse{OK: true, Items: items})
+		resp.OK = true
+		writeJSON(w, http.StatusOK, resp)
 	})
 
-	mux.HandleFunc("/v1/zfs/snapshot/create", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/v1/zfs/dataset/quotas", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			ds := strings.TrimSpace(r.URL.Query().Get("dataset"))
+			if ds == "" {
+				writeJSON(w, http.StatusBadRequest, ZDatasetQuotaResponse{OK: false, Error: "dataset required"})
+				return
+			}
+			users, groups, err := getDatasetQuotaUsage(ds)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, ZDatasetQuotaResponse{OK: false, Error: err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, ZDatasetQuotaResponse{OK: true, Users: users, Groups: groups})
+		case http.MethodPost:
+			var req ZDatasetQuotaRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSON(w, http.StatusBadRequest, ZDatasetQuotaResponse{OK: false, Error: "invalid json"})
+				return
+			}
+			if strings.TrimSpace(req.Dataset) == "" {
+				writeJSON(w, http.StatusBadRequest, ZDatasetQuotaResponse{OK: false, Error: "dataset required"})
+				return
+			}
+			out, err := applyDatasetQuotas(req)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, ZDatasetQuotaResponse{OK: false, Output: out, Error: err.Error()})
+				return
+			}
+			users, groups, err := getDatasetQuotaUsage(req.Dataset)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, ZDatasetQuotaResponse{OK: false, Output: out, Error: err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, ZDatasetQuotaResponse{OK: true, Users: users, Groups: groups, Output: out})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/v1/zfs/dataset/acl", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		var req ZSnapshotCreateRequest
+		var req ZDatasetACLRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			writeJSON(w, http.StatusBadRequest, ZPoolOpResponse{OK: false, Error: "invalid json"})
+			writeJSON(w, http.StatusBadRequest, ZDatasetACLResponse{OK: false, Error: "invalid json"})
 			return
 		}
-		if strings.TrimSpace(req.Dataset) == "" || strings.TrimSpace(req.Name) == "" {
-			writeJSON(w, http.StatusBadRequest, ZPoolOpResponse{OK: false, Error: "dataset and name required"})
+		if strings.TrimSpace(req.Dataset) == "" {
+			writeJSON(w, http.StatusBadRequest, ZDatasetACLResponse{OK: false, Error: "dataset required"})
 			return
 		}
-		snap := strings.TrimSpace(req.Dataset) + "@" + strings.TrimSpace(req.Name)
-		args := []string{"snapshot"}
-		if req.Recursive {
-			args = append(args, "-r")
+		typ, err := normalizeACLType(req.Type)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, ZDatasetACLResponse{OK: false, Error: err.Error()})
+			return
 		}
-		args = append(args, snap)
-		out, err := runCmdCombined(r.Context(), 120*time.Second, "zfs", args...)
+		specs, err := aclSpecs(typ, req.Paths)
 		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, ZPoolOpResponse{OK: false, Output: out, Error: err.Error()})
+			writeJSON(w, http.StatusBadRequest, ZDatasetACLResponse{OK: false, Error: err.Error()})
 			return
 		}
-		writeJSON(w, http.StatusOK, ZPoolOpResponse{OK: true, Output: out})
+		mp, out, err := aclMountpoint(req.Dataset, req.Mountpoint, typ)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ZDatasetACLResponse{OK: false, Output: out, Error: err.Error()})
+			return
+		}
+		if token := strings.TrimSpace(req.ResetToken); token != "" {
+			reset := startACLReset(req.Dataset, typ, token, mp, specs)
+			writeJSON(w, http.StatusOK, ZDatasetACLResponse{OK: true, Reset: &reset})
+			return
+		}
+		changed, out, err := applyACLs(typ, mp, specs)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ZDatasetACLResponse{OK: false, Changed: changed, Output: out, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, ZDatasetACLResponse{OK: true, Changed: changed, Output: out})
+	})
+
+	// ----- Volumes -----
+	mux.HandleFunc("/v1/zfs/zvol/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		name := strings.TrimSpace(r.URL.Query().Get("name"))
+		if name == "" {
+			writeJSON(w, http.StatusBadRequest, ZVolumeResponse{OK: false, Error: "name required"})
+			return
+		}
+		info, err := getZVolumeInfo(name)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, ZVolumeResponse{OK: false, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, ZVolumeResponse{OK: true, Volume: &info})
+	})
+
+	mux.HandleFunc("/v1/zfs/zvol/ensure", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ZVolumeEnsureRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ZVolumeResponse{OK: false, Error: "invalid json"})
+			return
+		}
+		if strings.TrimSpace(req.Name) == "" || !isZFSSize(req.Size) || strings.EqualFold(strings.TrimSpace(req.Size), "none") {
+			writeJSON(w, http.StatusBadRequest, ZVolumeResponse{OK: false, Error: "name and size required"})
+			return
+		}
+		resp, err := ensureZVolume(req)
+		if err != nil {
+			resp.OK = false
+			resp.Error = err.Error()
+			writeJSON(w, http.StatusInternalServerError, resp)
+			return
+		}
+		resp.OK = true
+		writeJSON(w, http.StatusOK, resp)
+	})
+
+	mux.HandleFunc("/v1/zfs/zvol/resize", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ZVolumeResizeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ZVolumeResponse{OK: false, Error: "invalid json"})
+			return
+		}
+		if strings.TrimSpace(req.Name) == "" || !isZFSSize(req.Size) || strings.EqualFold(strings.TrimSpace(req.Size), "none") {
+			writeJSON(w, http.StatusBadRequest, ZVolumeResponse{OK: false, Error: "name and size required"})
+			return
+		}
+		resized, out, err := resizeZVolume(req.Name, req.Size)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ZVolumeResponse{OK: false, Output: out, Error: err.Error()})
+			return
+		}
+		info, err := getZVolumeInfo(req.Name)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ZVolumeResponse{OK: false, Output: out, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, ZVolumeResponse{OK: true, Volume: &info, Resized: resized, Output: out})
+	})
+
+	mux.HandleFunc("/v1/zfs/zvol/destroy", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ZVolumeDestroyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ZVolumeResponse{OK: false, Error: "invalid json"})
+			return
+		}
+		name := strings.TrimSpace(req.Name)
+		if name == "" || !strings.Contains(name, "/") {
+			writeJSON(w, http.StatusBadRequest, ZVolumeResponse{OK: false, Error: "name must be <pool>/<volume>"})
+			return
+		}
+		if !datasetExists(name) {
+			writeJSON(w, http.StatusOK, ZVolumeResponse{OK: true})
+			return
+		}
+		if t, err := getDatasetPropertyValue(name, "type"); err == nil && strings.TrimSpace(t) != "volume" {
+			writeJSON(w, http.StatusBadRequest, ZVolumeResponse{OK: false, Error: fmt.Sprintf("%s is a %s, not a volume", name, strings.TrimSpace(t))})
+			return
+		}
+		out, err := runCmdCombined(r.Context(), 60*time.Second, "zfs", "destroy", name)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ZVolumeResponse{OK: false, Output: out, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, ZVolumeResponse{OK: true, Output: out})
+	})
+
+	// ----- Snapshots -----
+	mux.HandleFunc("/v1/zfs/snapshot/list", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		ds := strings.TrimSpace(r.URL.Query().Get("dataset"))
+		items, out, err := listSnapshotNames(ds)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ZSnapshotListResponse{OK: false, Error: err.Error()})
+			return
+		}
+		_ = out
+		writeJSON(w, http.StatusOK, ZSnapshotListResponse{OK: true, Items: items})
+	})
+
+	mux.HandleFunc("/v1/zfs/snapshot/create", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ZSnapshotCreateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ZPoolOpResponse{OK: false, Error: "invalid json"})
+			return
+		}
+		if strings.TrimSpace(req.Dataset) == "" || strings.TrimSpace(req.Name) == "" {
+			writeJSON(w, http.StatusBadRequest, ZPoolOpResponse{OK: false, Error: "dataset and name required"})
+			return
+		}
+		ds := strings.TrimSpace(req.Dataset)
+		name := strings.TrimSpace(req.Name)
+		args := []string{"snapshot"}
+		switch {
+		case req.Recursive && len(req.Exclude) > 0:
+			// zfs snapshot -r cannot skip children; naming every included
+			// dataset in one command keeps the snapshot atomic.
+			datasets, err := snapshotDatasets(r.Context(), ds, req.Exclude)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, ZPoolOpResponse{OK: false, Error: err.Error()})
+				return
+			}
+			for _, d := range datasets {
+				args = append(args, d+"@"+name)
+			}
+		case req.Recursive:
+			args = append(args, "-r", ds+"@"+name)
+		default:
+			args = append(args, ds+"@"+name)
+		}
+		out, err := runCmdCombined(r.Context(), 120*time.Second, "zfs", args...)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ZPoolOpResponse{OK: false, Output: out, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, ZPoolOpResponse{OK: true, Output: out})
 	})
 
 	mux.HandleFunc("/v1/zfs/snapshot/destroy", func(w http.ResponseWriter, r *http.Request) {
@@ -645,6 +1545,15 @@ func main() {
 			writeJSON(w, http.StatusBadRequest, ZPoolOpResponse{OK: false, Error: "snapshot required"})
 			return
 		}
+		if req.Recursive {
+			out, err := destroySnapshotFamily(r.Context(), strings.TrimSpace(req.Snapshot))
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, ZPoolOpResponse{OK: false, Output: out, Error: err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, ZPoolOpResponse{OK: true, Output: out})
+			return
+		}
 		out, err := runCmdCombined(r.Context(), 120*time.Second, "zfs", "destroy", strings.TrimSpace(req.Snapshot))
 		if err != nil {
 			writeJSON(w, http.StatusInternalServerError, ZPoolOpResponse{OK: false, Output: out, Error: err.Error()})
@@ -653,6 +1562,245 @@ func main() {
 		writeJSON(w, http.StatusOK, ZPoolOpResponse{OK: true, Output: out})
 	})
 
+	// details?dataset=<ds>[&recursive=true] lists snapshots with space
+	// accounting, holds and clones.
+	mux.HandleFunc("/v1/zfs/snapshot/details", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		ds := strings.TrimSpace(r.URL.Query().Get("dataset"))
+		if ds == "" {
+			writeJSON(w, http.StatusBadRequest, ZSnapshotDetailsResponse{OK: false, Error: "dataset required"})
+			return
+		}
+		recursive, _ := strconv.ParseBool(r.URL.Query().Get("recursive"))
+		items, err := listSnapshotDetails(r.Context(), ds, recursive)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ZSnapshotDetailsResponse{OK: false, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, ZSnapshotDetailsResponse{OK: true, Items: items})
+	})
+
+	// diff?from=<ds@a>[&to=<ds@b>] streams "zfs diff -FH"; without to the
+	// snapshot is compared against the live dataset.
+	mux.HandleFunc("/v1/zfs/snapshot/diff", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		from := strings.TrimSpace(r.URL.Query().Get("from"))
+		to := strings.TrimSpace(r.URL.Query().Get("to"))
+		at := strings.Index(from, "@")
+		if at <= 0 {
+			writeJSON(w, http.StatusBadRequest, ZPoolOpResponse{OK: false, Error: "from (dataset@snapshot) required"})
+			return
+		}
+		ds := from[:at]
+		if to != "" && to != ds && !strings.HasPrefix(to, ds+"@") {
+			writeJSON(w, http.StatusBadRequest, ZPoolOpResponse{OK: false, Error: "to must be a snapshot of the same dataset or the dataset itself"})
+			return
+		}
+		for _, snap := range []string{from, to} {
+			if strings.Contains(snap, "@") && !datasetExists(snap) {
+				writeJSON(w, http.StatusNotFound, ZPoolOpResponse{OK: false, Error: fmt.Sprintf("snapshot %s not found", snap)})
+				return
+			}
+		}
+		if err := streamSnapshotDiff(r.Context(), w, ds, from, to); err != nil {
+			writeJSON(w, http.StatusInternalServerError, ZPoolOpResponse{OK: false, Error: err.Error()})
+		}
+	})
+
+	// ----- Snapshot files -----
+	mux.HandleFunc("/v1/zfs/snapshot/files/list", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		snap := strings.TrimSpace(r.URL.Query().Get("snapshot"))
+		root, full, _, err := resolveSnapshotPath(snap, r.URL.Query().Get("path"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, SnapshotFilesResponse{OK: false, Error: err.Error()})
+			return
+		}
+		dirents, err := os.ReadDir(full)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, SnapshotFilesResponse{OK: false, Error: err.Error()})
+			return
+		}
+		entries := make([]SnapshotFileEntry, 0, len(dirents))
+		for _, d := range dirents {
+			if e, err := snapshotFileEntry(root, filepath.Join(full, d.Name())); err == nil {
+				entries = append(entries, e)
+			}
+		}
+		writeJSON(w, http.StatusOK, SnapshotFilesResponse{OK: true, Snapshot: snap, Entries: entries})
+	})
+
+	mux.HandleFunc("/v1/zfs/snapshot/files/stat", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		snap := strings.TrimSpace(r.URL.Query().Get("snapshot"))
+		root, full, _, err := resolveSnapshotPath(snap, r.URL.Query().Get("path"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, SnapshotFilesResponse{OK: false, Error: err.Error()})
+			return
+		}
+		e, err := snapshotFileEntry(root, full)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, SnapshotFilesResponse{OK: false, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, SnapshotFilesResponse{OK: true, Snapshot: snap, Entry: &e})
+	})
+
+	mux.HandleFunc("/v1/zfs/snapshot/files/download", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		_, full, _, err := resolveSnapshotPath(strings.TrimSpace(r.URL.Query().Get("snapshot")), r.URL.Query().Get("path"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, ZPoolOpResponse{OK: false, Error: err.Error()})
+			return
+		}
+		fi, err := os.Lstat(full)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, ZPoolOpResponse{OK: false, Error: err.Error()})
+			return
+		}
+		if !fi.Mode().IsRegular() {
+			writeJSON(w, http.StatusBadRequest, ZPoolOpResponse{OK: false, Error: "only regular files can be downloaded"})
+			return
+		}
+		f, err := os.Open(full)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ZPoolOpResponse{OK: false, Error: err.Error()})
+			return
+		}
+		defer f.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fi.Name()}))
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+	})
+
+	mux.HandleFunc("/v1/zfs/snapshot/files/restore", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req SnapshotFileRestoreRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, SnapshotFileRestoreResponse{OK: false, Error: "invalid json"})
+			return
+		}
+		st, err := startFileRestore(req)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, SnapshotFileRestoreResponse{OK: false, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, SnapshotFileRestoreResponse{OK: true, Restore: &st})
+	})
+
+	mux.HandleFunc("/v1/zfs/snapshot/rollback", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ZSnapshotRollbackRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ZSnapshotRollbackResponse{OK: false, Error: "invalid json"})
+			return
+		}
+		st, err := startRollback(req)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, ZSnapshotRollbackResponse{OK: false, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, ZSnapshotRollbackResponse{OK: true, Rollback: &st})
+	})
+
+	mux.HandleFunc("/v1/zfs/snapshot/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		snap := strings.TrimSpace(r.URL.Query().Get("snapshot"))
+		if !strings.Contains(snap, "@") {
+			writeJSON(w, http.StatusBadRequest, ZSnapshotStatusResponse{OK: false, Error: "snapshot (dataset@name) required"})
+			return
+		}
+		if !datasetExists(snap) {
+			writeJSON(w, http.StatusOK, ZSnapshotStatusResponse{OK: true, Exists: false})
+			return
+		}
+		info, err := getSnapshotInfo(r.Context(), snap)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ZSnapshotStatusResponse{OK: false, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, ZSnapshotStatusResponse{OK: true, Exists: true, Snapshot: &info})
+	})
+
+	// holds?snapshot=<ds@name> lists one snapshot's holds; holds?dataset=<ds>
+	// lists every held snapshot of ds and its descendants.
+	mux.HandleFunc("/v1/zfs/snapshot/holds", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		snap := strings.TrimSpace(r.URL.Query().Get("snapshot"))
+		ds := strings.TrimSpace(r.URL.Query().Get("dataset"))
+		if (snap == "") == (ds == "") {
+			writeJSON(w, http.StatusBadRequest, ZSnapshotHoldsResponse{OK: false, Error: "exactly one of snapshot or dataset required"})
+			return
+		}
+		var items []ZSnapshotHold
+		var err error
+		if snap != "" {
+			items, err = listSnapshotHolds(r.Context(), []string{snap})
+		} else {
+			items, err = listDatasetHolds(r.Context(), ds)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ZSnapshotHoldsResponse{OK: false, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, ZSnapshotHoldsResponse{OK: true, Items: items})
+	})
+
+	holdHandler := func(release bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			var req ZSnapshotHoldRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSON(w, http.StatusBadRequest, ZPoolOpResponse{OK: false, Error: "invalid json"})
+				return
+			}
+			snap := strings.TrimSpace(req.Snapshot)
+			tag := strings.TrimSpace(req.Tag)
+			if !strings.Contains(snap, "@") || tag == "" {
+				writeJSON(w, http.StatusBadRequest, ZPoolOpResponse{OK: false, Error: "snapshot (dataset@name) and tag required"})
+				return
+			}
+			out, err := applySnapshotHold(r.Context(), snap, tag, req.Recursive, release)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, ZPoolOpResponse{OK: false, Output: out, Error: err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, ZPoolOpResponse{OK: true, Output: out})
+		}
+	}
+	mux.HandleFunc("/v1/zfs/snapshot/hold", holdHandler(false))
+	mux.HandleFunc("/v1/zfs/snapshot/release", holdHandler(true))
+
 	mux.HandleFunc("/v1/zfs/snapshot/clone", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -675,7 +1823,177 @@ func main() {
 		writeJSON(w, http.StatusOK, ZPoolOpResponse{OK: true, Output: out})
 	})
 
-	refreshDiskCache()
+	// promote makes a clone independent of its origin snapshot, which moves
+	// the older snapshots over to it. Promoting a dataset that is no longer a
+	// clone is a no-op.
+	mux.HandleFunc("/v1/zfs/snapshot/clone/promote", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ZCloneRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ZPoolOpResponse{OK: false, Error: "invalid json"})
+			return
+		}
+		ds := strings.TrimSpace(req.Dataset)
+		if ds == "" || !datasetExists(ds) {
+			writeJSON(w, http.StatusBadRequest, ZPoolOpResponse{OK: false, Error: fmt.Sprintf("dataset %q not found", ds)})
+			return
+		}
+		origin, err := getDatasetPropertyValue(ds, "origin")
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ZPoolOpResponse{OK: false, Output: origin, Error: err.Error()})
+			return
+		}
+		if origin == "-" || origin == "" {
+			writeJSON(w, http.StatusOK, ZPoolOpResponse{OK: true})
+			return
+		}
+		out, err := runCmdCombined(r.Context(), 120*time.Second, "zfs", "promote", ds)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ZPoolOpResponse{OK: false, Output: out, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, ZPoolOpResponse{OK: true, Output: out})
+	})
+
+	// destroy removes a clone and the snapshots taken on it. A missing
+	// dataset is success.
+	mux.HandleFunc("/v1/zfs/snapshot/clone/destroy", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ZCloneRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ZPoolOpResponse{OK: false, Error: "invalid json"})
+			return
+		}
+		ds := strings.TrimSpace(req.Dataset)
+		if ds == "" || strings.Contains(ds, "@") {
+			writeJSON(w, http.StatusBadRequest, ZPoolOpResponse{OK: false, Error: "dataset required"})
+			return
+		}
+		if !datasetExists(ds) {
+			writeJSON(w, http.StatusOK, ZPoolOpResponse{OK: true})
+			return
+		}
+		if origin, err := getDatasetPropertyValue(ds, "origin"); err != nil || origin == "-" || origin == "" {
+			writeJSON(w, http.StatusBadRequest, ZPoolOpResponse{OK: false, Error: fmt.Sprintf("%s is not a clone", ds)})
+			return
+		}
+		out, err := runCmdCombined(r.Context(), 120*time.Second, "zfs", "destroy", "-r", ds)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ZPoolOpResponse{OK: false, Output: out, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, ZPoolOpResponse{OK: true, Output: out})
+	})
+
+	// ----- Replication -----
+	// target?dataset=<ds> reports whether a replication target exists and
+	// which snapshots it has.
+	mux.HandleFunc("/v1/zfs/replication/target", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		ds := strings.TrimSpace(r.URL.Query().Get("dataset"))
+		if ds == "" || strings.Contains(ds, "@") {
+			writeJSON(w, http.StatusBadRequest, ZReplicationTargetResponse{OK: false, Error: "dataset required"})
+			return
+		}
+		if !datasetExists(ds) {
+			writeJSON(w, http.StatusOK, ZReplicationTargetResponse{OK: true})
+			return
+		}
+		out, err := runCmdCombined(r.Context(), 30*time.Second, "zfs", "list", "-H", "-o", "name", "-t", "snapshot", "-s", "createtxg", "-d", "1", ds)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ZReplicationTargetResponse{OK: false, Error: fmt.Sprintf("zfs list failed: %v %s", err, strings.TrimSpace(out))})
+			return
+		}
+		snaps := []string{}
+		for _, name := range splitLines(out) {
+			if _, short, ok := strings.Cut(name, "@"); ok {
+				snaps = append(snaps, short)
+			}
+		}
+		writeJSON(w, http.StatusOK, ZReplicationTargetResponse{OK: true, Exists: true, Snapshots: snaps})
+	})
+
+	mux.HandleFunc("/v1/zfs/replication/receive/prepare", requireOperatorAuth(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ZReplicationReceiveRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ZPoolOpResponse{OK: false, Error: "invalid json"})
+			return
+		}
+		if err := prepareReplicationReceive(req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ZPoolOpResponse{OK: false, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, ZPoolOpResponse{OK: true})
+	}))
+
+	// receive takes a send stream from another node-agent as the request
+	// body, authenticated by the token and secret of a prepared receive.
+	mux.HandleFunc("/v1/zfs/replication/receive", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		secret, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		req, err := claimReplicationReceive(r.Header.Get(replicationTokenHeader), secret)
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, ZPoolOpResponse{OK: false, Error: err.Error()})
+			return
+		}
+		out, err := receiveReplicationStream(r.Context(), req, r.Body)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ZPoolOpResponse{OK: false, Output: out, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, ZPoolOpResponse{OK: true, Output: out})
+	})
+
+	mux.HandleFunc("/v1/zfs/replication/send", requireOperatorAuth(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ZReplicationSendRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ZReplicationResponse{OK: false, Error: "invalid json"})
+			return
+		}
+		st, err := startReplicationSend(req)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, ZReplicationResponse{OK: false, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, ZReplicationResponse{OK: true, Replication: &st})
+	}))
+
+	// status?token=<token> reports an outgoing stream; 404 once the
+	// node-agent no longer knows it, e.g. after a restart.
+	mux.HandleFunc("/v1/zfs/replication/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		st, ok := replicationSendStatus(strings.TrimSpace(r.URL.Query().Get("token")))
+		if !ok {
+			writeJSON(w, http.StatusNotFound, ZReplicationResponse{OK: false, Error: "unknown token"})
+			return
+		}
+		writeJSON(w, http.StatusOK, ZReplicationResponse{OK: true, Replication: &st})
+	})
+
+	refreshDiskCache()
 	go startDiskRefreshLoop(context.Background())
 	go startUdevMonitor(context.Background())
 
@@ -1030,6 +2348,358 @@ func listSnapshotNames(dataset string) ([]string, string, error) {
 	return splitLines(out), out, nil
 }
 
+// snapshotDatasets lists ds and its descendant filesystems and volumes,
+// minus the excluded datasets and everything below them.
+func snapshotDatasets(ctx context.Context, ds string, exclude []string) ([]string, error) {
+	out, err := runCmdCombined(ctx, 30*time.Second, "zfs", "list", "-H", "-o", "name", "-t", "filesystem,volume", "-r", ds)
+	if err != nil {
+		return nil, fmt.Errorf("zfs list %s failed: %w", ds, err)
+	}
+	var keep []string
+	for _, d := range splitLines(out) {
+		skip := false
+		for _, ex := range exclude {
+			ex = strings.Trim(strings.TrimSpace(ex), "/")
+			if ex != "" && (d == ex || strings.HasPrefix(d, ex+"/")) {
+				skip = true
+				break
+			}
+		}
+		if d == ds && skip {
+			return nil, fmt.Errorf("cannot exclude %s from its own snapshot", ds)
+		}
+		if !skip {
+			keep = append(keep, d)
+		}
+	}
+	return keep, nil
+}
+
+// destroySnapshotFamily removes ds@name from ds and every descendant. When
+// ds@name exists a single "zfs destroy -r" removes the family atomically;
+// otherwise the topmost orphaned child snapshots are destroyed one by one.
+func destroySnapshotFamily(ctx context.Context, snapshot string) (string, error) {
+	at := strings.Index(snapshot, "@")
+	if at <= 0 || at == len(snapshot)-1 {
+		return "", fmt.Errorf("invalid snapshot %q", snapshot)
+	}
+	ds, name := snapshot[:at], snapshot[at+1:]
+	items, out, err := listSnapshotNames(ds)
+	if err != nil {
+		if strings.Contains(out, "does not exist") {
+			return "", nil
+		}
+		return out, err
+	}
+	var family []string
+	for _, it := range items {
+		if strings.HasSuffix(it, "@"+name) {
+			family = append(family, it)
+		}
+	}
+	if len(family) == 0 {
+		return "", nil
+	}
+	if slices.Contains(family, snapshot) {
+		return runCmdCombined(ctx, 120*time.Second, "zfs", "destroy", "-r", snapshot)
+	}
+	var outs []string
+	var tops []string
+	for _, f := range family {
+		child := strings.TrimSuffix(f, "@"+name)
+		covered := false
+		for _, t := range tops {
+			if strings.HasPrefix(child, strings.TrimSuffix(t, "@"+name)+"/") {
+				covered = true
+				break
+			}
+		}
+		if covered {
+			continue
+		}
+		tops = append(tops, f)
+		o, err := runCmdCombined(ctx, 120*time.Second, "zfs", "destroy", "-r", f)
+		outs = append(outs, o)
+		if err != nil {
+			return strings.Join(outs, "\n"), err
+		}
+	}
+	return strings.Join(outs, "\n"), nil
+}
+
+func getSnapshotInfo(ctx context.Context, snap string) (ZSnapshotInfo, error) {
+	out, err := runCmdCombined(ctx, 15*time.Second, "zfs", "get", "-H", "-p", "-o", "property,value", "creation,used,referenced", snap)
+	if err != nil {
+		return ZSnapshotInfo{}, fmt.Errorf("zfs get %s: %s", snap, strings.TrimSpace(out))
+	}
+	info := ZSnapshotInfo{Name: snap}
+	for _, line := range splitLines(out) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "creation":
+			info.Creation = parseInt64(fields[1])
+		case "used":
+			info.UsedBytes = parseInt64(fields[1])
+		case "referenced":
+			info.ReferencedBytes = parseInt64(fields[1])
+		}
+	}
+	return info, nil
+}
+
+var (
+	diffChanges = map[string]string{"+": "added", "-": "removed", "M": "modified", "R": "renamed"}
+	diffTypes   = map[string]string{
+		"F": "file", "/": "directory", "@": "symlink", "B": "block", "C": "char",
+		"|": "fifo", "=": "socket", ">": "door", "P": "port",
+	}
+)
+
+// streamSnapshotDiff runs zfs diff and writes one JSON entry per line as it
+// is read, so large diffs are neither buffered nor held up. The returned
+// error is only non-nil if nothing was written yet. zfs diff is killed when
+// the client goes away.
+func streamSnapshotDiff(ctx context.Context, w http.ResponseWriter, ds, from, to string) error {
+	mountpoint := ""
+	if out, err := runCmdCombined(ctx, 15*time.Second, "zfs", "get", "-H", "-o", "value", "mountpoint", ds); err == nil {
+		if mp := strings.TrimSpace(out); strings.HasPrefix(mp, "/") {
+			mountpoint = strings.TrimRight(mp, "/")
+		}
+	}
+	args := []string{"diff", "-FH", from}
+	if to != "" {
+		args = append(args, to)
+	}
+	cmd := exec.CommandContext(ctx, "zfs", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	started := false
+	n := 0
+	for scanner.Scan() {
+		f := strings.Split(scanner.Text(), "\t")
+		if len(f) < 3 {
+			continue
+		}
+		e := ZSnapshotDiffEntry{
+			Change:   diffChanges[f[0]],
+			FileType: diffTypes[f[1]],
+			Path:     unescapeZFSDiffPath(f[2]),
+		}
+		if len(f) > 3 {
+			e.NewPath = unescapeZFSDiffPath(f[3])
+		}
+		if mountpoint != "" {
+			if rel, ok := strings.CutPrefix(e.Path, mountpoint+"/"); ok {
+				e.RelativePath = rel
+			}
+		}
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		if err := enc.Encode(e); err != nil {
+			break
+		}
+		if n++; n%256 == 0 && flusher != nil {
+			flusher.Flush()
+		}
+	}
+	waitErr := cmd.Wait()
+	if waitErr != nil && ctx.Err() == nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = waitErr.Error()
+		}
+		if !started {
+			return fmt.Errorf("zfs diff failed: %s", msg)
+		}
+		_ = enc.Encode(ZSnapshotDiffEntry{Error: "zfs diff failed: " + msg})
+		return nil
+	}
+	if !started {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+	}
+	return nil
+}
+
+// unescapeZFSDiffPath decodes the \oooo (four octal digits) escapes zfs diff
+// uses for spaces, backslashes and non-printable bytes.
+func unescapeZFSDiffPath(p string) string {
+	if !strings.Contains(p, "\\") {
+		return p
+	}
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		if p[i] == '\\' && i+5 <= len(p) {
+			if v, err := strconv.ParseUint(p[i+1:i+5], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 4
+				continue
+			}
+		}
+		b.WriteByte(p[i])
+	}
+	return b.String()
+}
+
+// listSnapshotDetails lists the snapshots of ds (and its descendants with
+// recursive) in creation order. Holds are only looked up for snapshots
+// whose userrefs is non-zero.
+func listSnapshotDetails(ctx context.Context, ds string, recursive bool) ([]ZSnapshotInfo, error) {
+	args := []string{"list", "-H", "-p", "-t", "snapshot", "-s", "creation", "-o", "name,creation,used,referenced,written,userrefs,clones"}
+	if recursive {
+		args = append(args, "-r", ds)
+	} else {
+		args = append(args, "-d", "1", ds)
+	}
+	out, err := runCmdCombined(ctx, 60*time.Second, "zfs", args...)
+	if err != nil {
+		return nil, fmt.Errorf("zfs list snapshots of %s failed: %w: %s", ds, err, strings.TrimSpace(out))
+	}
+	items := []ZSnapshotInfo{}
+	index := map[string]int{}
+	var held []string
+	for _, ln := range splitLines(out) {
+		f := strings.Split(ln, "\t")
+		if len(f) < 7 {
+			continue
+		}
+		info := ZSnapshotInfo{
+			Name:            f[0],
+			Dataset:         strings.SplitN(f[0], "@", 2)[0],
+			Creation:        parseInt64(f[1]),
+			UsedBytes:       parseInt64(f[2]),
+			ReferencedBytes: parseInt64(f[3]),
+			WrittenBytes:    parseInt64(f[4]),
+		}
+		if c := strings.TrimSpace(f[6]); c != "" && c != "-" {
+			info.Clones = strings.Split(c, ",")
+		}
+		if refs := strings.TrimSpace(f[5]); refs != "" && refs != "0" && refs != "-" {
+			held = append(held, info.Name)
+		}
+		index[info.Name] = len(items)
+		items = append(items, info)
+	}
+	holds, err := listSnapshotHolds(ctx, held)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range holds {
+		if i, ok := index[h.Snapshot]; ok {
+			items[i].Holds = append(items[i].Holds, h.Tag)
+		}
+	}
+	return items, nil
+}
+
+// listSnapshotHolds parses "zfs holds -H -p": snapshot, tag, unix time.
+func listSnapshotHolds(ctx context.Context, snaps []string) ([]ZSnapshotHold, error) {
+	if len(snaps) == 0 {
+		return nil, nil
+	}
+	out, err := runCmdCombined(ctx, 30*time.Second, "zfs", append([]string{"holds", "-H", "-p"}, snaps...)...)
+	if err != nil {
+		return nil, fmt.Errorf("zfs holds failed: %w: %s", err, strings.TrimSpace(out))
+	}
+	var items []ZSnapshotHold
+	for _, ln := range splitLines(out) {
+		f := strings.Split(ln, "\t")
+		if len(f) < 2 {
+			continue
+		}
+		h := ZSnapshotHold{Snapshot: f[0], Tag: f[1]}
+		if len(f) > 2 {
+			h.Created, _ = strconv.ParseInt(strings.TrimSpace(f[2]), 10, 64)
+		}
+		items = append(items, h)
+	}
+	return items, nil
+}
+
+// listDatasetHolds finds held snapshots under ds through their userrefs
+// count, so only those are passed to zfs holds.
+func listDatasetHolds(ctx context.Context, ds string) ([]ZSnapshotHold, error) {
+	out, err := runCmdCombined(ctx, 30*time.Second, "zfs", "list", "-H", "-p", "-t", "snapshot", "-o", "name,userrefs", "-r", ds)
+	if err != nil {
+		return nil, fmt.Errorf("zfs list %s failed: %w", ds, err)
+	}
+	var held []string
+	for _, ln := range splitLines(out) {
+		f := strings.Fields(ln)
+		if len(f) == 2 && f[1] != "0" && f[1] != "-" {
+			held = append(held, f[0])
+		}
+	}
+	return listSnapshotHolds(ctx, held)
+}
+
+// applySnapshotHold places or releases tag. It is idempotent: an existing
+// hold is not placed again and a missing one is not released.
+func applySnapshotHold(ctx context.Context, snap, tag string, recursive, release bool) (string, error) {
+	snaps := []string{snap}
+	if recursive {
+		// zfs hold -r fails if any child already has the tag and zfs
+		// release -r if any lacks it, so each snapshot is checked on its own.
+		ds, name, _ := strings.Cut(snap, "@")
+		out, err := runCmdCombined(ctx, 30*time.Second, "zfs", "list", "-H", "-o", "name", "-t", "snapshot", "-r", ds)
+		if err != nil {
+			if release && strings.Contains(out, "does not exist") {
+				return "", nil
+			}
+			return out, fmt.Errorf("zfs list %s failed: %w", ds, err)
+		}
+		snaps = slices.DeleteFunc(splitLines(out), func(s string) bool { return !strings.HasSuffix(s, "@"+name) })
+		if len(snaps) == 0 {
+			if release {
+				return "", nil
+			}
+			return "", fmt.Errorf("snapshot %s not found", snap)
+		}
+	}
+	holds, err := listSnapshotHolds(ctx, snaps)
+	if err != nil {
+		// A snapshot that is gone holds nothing to release.
+		if release && strings.Contains(err.Error(), "does not exist") {
+			return "", nil
+		}
+		return "", err
+	}
+	var todo []string
+	for _, s := range snaps {
+		has := slices.ContainsFunc(holds, func(h ZSnapshotHold) bool { return h.Snapshot == s && h.Tag == tag })
+		if has == release {
+			todo = append(todo, s)
+		}
+	}
+	if len(todo) == 0 {
+		return "", nil
+	}
+	args := []string{"hold"}
+	if release {
+		args[0] = "release"
+	}
+	args = append(args, tag)
+	return runCmdCombined(ctx, 60*time.Second, "zfs", append(args, todo...)...)
+}
+
 func getZPoolStatus(pool string) (PoolStatus, string, error) {
 	raw, err := runCmdCombined(context.Background(), 30*time.Second, "zpool", "status", pool)
 	if err != nil {
@@ -1231,513 +2901,2750 @@ func normalizeDatasetPreset(preset string, props map[string]string) string {
 	if acltype == "posix" {
 		return datasetPresetGeneric
 	}
-	return ""
+	return ""
+}
+
+func applyDatasetPreset(full string, mountpoint string, preset string, props map[string]string) (string, error) {
+	preset = normalizeDatasetPreset(preset, props)
+	if preset == "" {
+		return "", nil
+	}
+	acltype := strings.ToLower(getDatasetProperty(props, "acltype"))
+
+	switch preset {
+	case datasetPresetGeneric:
+		if acltype != "" && acltype != "posix" {
+			return "", nil
+		}
+		return ensureDatasetMounted(full, mountpoint, "0755", false)
+	case datasetPresetSMB, datasetPresetMultiprotocol:
+		if acltype != "" && acltype != "nfsv4" {
+			return "", nil
+		}
+		return ensureNfs4DefaultAcl(full, mountpoint)
+	default:
+		return "", nil
+	}
+}
+
+func ensureNfs4DefaultAcl(dataset string, mountpoint string) (string, error) {
+	if _, err := exec.LookPath("nfs4_setfacl"); err != nil {
+		return "", fmt.Errorf("nfs4_setfacl not available: %w", err)
+	}
+	_, err := ensureDatasetMounted(dataset, mountpoint, "", false)
+	if err != nil {
+		return "", err
+	}
+	mp := strings.TrimSpace(mountpoint)
+	if mp == "" {
+		var err error
+		mp, err = getDatasetMountpoint(dataset)
+		if err != nil {
+			return "", err
+		}
+	}
+	if mp == "" || mp == "none" || mp == "-" || mp == "legacy" {
+		return "", nil
+	}
+	if acltype, err := getDatasetPropertyValue(dataset, "acltype"); err == nil {
+		acltype = strings.ToLower(strings.TrimSpace(acltype))
+		if acltype != "" && acltype != "nfsv4" {
+			return fmt.Sprintf("acltype=%s; skipping nfs4 acl", acltype), nil
+		}
+	}
+
+	if _, err := exec.LookPath("nfs4_getfacl"); err == nil {
+		has, out, err := hasNfs4Acl(mp)
+		if err != nil {
+			return out, err
+		}
+		if has {
+			return out, nil
+		}
+	}
+
+	aclSpec := "A::OWNER@:rwaDxtTnNcCoy,A::GROUP@:rwaDxtTnNcCoy,A::EVERYONE@:rxtncy"
+	out, err := runCmdCombined(context.Background(), 30*time.Second, "nfs4_setfacl", "-s", aclSpec, mp)
+	if err != nil {
+		lo := strings.ToLower(out)
+		if strings.Contains(lo, "operation to set acl not supported") {
+			return out, nil
+		}
+		return out, err
+	}
+	return out, nil
+}
+
+func hasNfs4Acl(path string) (bool, string, error) {
+	out, err := runCmdCombined(context.Background(), 15*time.Second, "nfs4_getfacl", path)
+	if err != nil {
+		return false, out, err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "A:") || strings.HasPrefix(line, "A::") {
+			return true, out, nil
+		}
+	}
+	return false, out, nil
+}
+
+func ensureDatasetMounted(full string, mountpoint string, mode string, recursive bool) (string, error) {
+	full = strings.TrimSpace(full)
+	if full == "" {
+		return "", errors.New("dataset empty")
+	}
+
+	if mp := strings.TrimSpace(mountpoint); mp != "" {
+		_, _ = runCmdCombined(context.Background(), 30*time.Second, "zfs", "set", "mountpoint="+mp, full)
+	}
+
+	out, err := runCmdCombined(context.Background(), 30*time.Second, "zfs", "get", "-H", "-o", "value", "mounted", full)
+	if err != nil {
+		return out, fmt.Errorf("zfs get mounted failed: %w", err)
+	}
+	if strings.TrimSpace(out) == "yes" {
+		return ensureMountPerms(full, mountpoint, mode, recursive, out)
+	}
+
+	out, err = runCmdCombined(context.Background(), 60*time.Second, "zfs", "mount", full)
+	if err != nil {
+		lo := strings.ToLower(out)
+		if strings.Contains(lo, "already mounted") {
+			return ensureMountPerms(full, mountpoint, mode, recursive, out)
+		}
+		return out, err
+	}
+	return ensureMountPerms(full, mountpoint, mode, recursive, out)
+}
+
+func ensureMountPerms(dataset string, mountpoint string, mode string, recursive bool, out string) (string, error) {
+	mode = strings.TrimSpace(mode)
+	if mode == "" {
+		return out, nil
+	}
+	mp := strings.TrimSpace(mountpoint)
+	if mp == "" {
+		var err error
+		mp, err = getDatasetMountpoint(dataset)
+		if err != nil {
+			return out, err
+		}
+	}
+	if mp == "" || mp == "none" || mp == "-" || mp == "legacy" {
+		return out, fmt.Errorf("mountpoint not available for %s", dataset)
+	}
+	args := []string{}
+	if recursive {
+		args = append(args, "-R")
+	}
+	args = append(args, mode, mp)
+	out2, err := runCmdCombined(context.Background(), 60*time.Second, "chmod", args...)
+	if err != nil {
+		return out2, err
+	}
+	return out2, nil
+}
+
+// renameDataset moves source to target with `zfs rename -p`, creating any
+// missing parents so a dataset can be reparented. It is idempotent: if source
+// is gone and target already exists the rename is assumed to have happened.
+func renameDataset(source string, target string, mountpoint string) (ZDatasetRenameResponse, error) {
+	source = strings.TrimSpace(source)
+	target = strings.TrimSpace(target)
+	var resp ZDatasetRenameResponse
+	if source == target {
+		return resp, errors.New("source and target are the same dataset")
+	}
+	if strings.Count(source, "/") == 0 || strings.Count(target, "/") == 0 {
+		return resp, errors.New("pool root datasets cannot be renamed")
+	}
+	if strings.SplitN(source, "/", 2)[0] != strings.SplitN(target, "/", 2)[0] {
+		return resp, errors.New("datasets cannot be renamed across pools")
+	}
+	if strings.HasPrefix(target, source+"/") {
+		return resp, errors.New("target cannot be a descendant of source")
+	}
+
+	srcExists := datasetExists(source)
+	dstExists := datasetExists(target)
+	switch {
+	case !srcExists && dstExists:
+		resp.Output = fmt.Sprintf("%s already renamed to %s", source, target)
+	case !srcExists:
+		return resp, fmt.Errorf("dataset %s not found", source)
+	case dstExists:
+		return resp, fmt.Errorf("target dataset %s already exists", target)
+	default:
+		if mp, err := getDatasetMountpoint(source); err == nil {
+			resp.OldMountpoint = mp
+		}
+		out, err := runCmdCombined(context.Background(), 120*time.Second, "zfs", "rename", "-p", source, target)
+		resp.Output = out
+		if err != nil {
+			return resp, err
+		}
+	}
+
+	if mp := strings.TrimSpace(mountpoint); mp != "" {
+		out, err := runCmdCombined(context.Background(), 30*time.Second, "zfs", "set", "mountpoint="+mp, target)
+		if err != nil {
+			resp.Output = strings.TrimSpace(resp.Output + "\n" + out)
+			return resp, fmt.Errorf("zfs set mountpoint failed: %w", err)
+		}
+	}
+	if mp, err := getDatasetMountpoint(target); err == nil {
+		resp.NewMountpoint = mp
+	}
+	return resp, nil
+}
+
+func applyDatasetQuotas(req ZDatasetQuotaRequest) (string, error) {
+	ds := strings.TrimSpace(req.Dataset)
+	var b strings.Builder
+	apply := func(kind string, entries []ZDatasetQuotaEntry) error {
+		for _, e := range entries {
+			if e.ID < 0 {
+				return fmt.Errorf("invalid %s id %d", kind, e.ID)
+			}
+			quota := strings.TrimSpace(e.Quota)
+			if !isZFSSize(quota) {
+				return fmt.Errorf("invalid %s for id %d: %q", kind, e.ID, e.Quota)
+			}
+			prop := fmt.Sprintf("%s@%d=%s", kind, e.ID, quota)
+			out, err := runCmdCombined(context.Background(), 30*time.Second, "zfs", "set", prop, ds)
+			b.WriteString(out)
+			if err != nil {
+				return fmt.Errorf("zfs set %s failed: %w", prop, err)
+			}
+		}
+		return nil
+	}
+	if err := apply("userquota", req.UserQuotas); err != nil {
+		return b.String(), err
+	}
+	if err := apply("groupquota", req.GroupQuotas); err != nil {
+		return b.String(), err
+	}
+	return b.String(), nil
+}
+
+func getDatasetQuotaUsage(dataset string) ([]ZDatasetQuotaUsage, []ZDatasetQuotaUsage, error) {
+	users, err := listDatasetSpace("userspace", dataset)
+	if err != nil {
+		return nil, nil, err
+	}
+	groups, err := listDatasetSpace("groupspace", dataset)
+	if err != nil {
+		return nil, nil, err
+	}
+	return users, groups, nil
+}
+
+func listDatasetSpace(sub string, dataset string) ([]ZDatasetQuotaUsage, error) {
+	out, err := runCmdCombined(context.Background(), 30*time.Second, "zfs", sub, "-H", "-p", "-n", "-o", "name,used,quota", strings.TrimSpace(dataset))
+	if err != nil {
+		return nil, fmt.Errorf("zfs %s failed: %w", sub, err)
+	}
+	var items []ZDatasetQuotaUsage
+	for _, ln := range splitLines(out) {
+		fields := strings.Fields(ln)
+		if len(fields) < 3 {
+			continue
+		}
+		id, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		items = append(items, ZDatasetQuotaUsage{
+			ID:    id,
+			Used:  parseInt64(fields[1]),
+			Quota: parseInt64(fields[2]),
+		})
+	}
+	return items, nil
+}
+
+// isZFSSize accepts "none" or a zfs size such as 512M, 10G, 1.5T or a byte count.
+func isZFSSize(s string) bool {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return false
+	}
+	if strings.EqualFold(s, "none") {
+		return true
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(s), "B"), "I")
+	if n := len(s); n > 0 && strings.ContainsRune("KMGTPE", rune(s[n-1])) {
+		s = s[:n-1]
+	}
+	if s == "" || s[0] < '0' || s[0] > '9' {
+		return false
+	}
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// parseZFSSize converts a zfs size such as "10G" or "1.5T" to bytes
+// (binary units, as zfs uses).
+func parseZFSSize(s string) (int64, error) {
+	raw := s
+	s = strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+	mult := float64(1)
+	if n := len(s); n > 0 {
+		if i := strings.IndexByte("KMGTPE", s[n-1]); i >= 0 {
+			for j := 0; j <= i; j++ {
+				mult *= 1024
+			}
+			s = s[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", raw)
+	}
+	return int64(v * mult), nil
+}
+
+func zvolDevicePath(name string) string {
+	return "/dev/zvol/" + strings.TrimSpace(name)
+}
+
+func getZVolumeInfo(name string) (ZVolumeInfo, error) {
+	name = strings.TrimSpace(name)
+	out, err := runCmdCombined(context.Background(), 15*time.Second, "zfs", "get", "-H", "-p", "-o", "property,value", "type,volsize,used,referenced,volblocksize", name)
+	if err != nil {
+		return ZVolumeInfo{}, fmt.Errorf("zfs get %s: %s", name, strings.TrimSpace(out))
+	}
+	info := ZVolumeInfo{Name: name, DevicePath: zvolDevicePath(name)}
+	for _, line := range splitLines(out) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "type":
+			if fields[1] != "volume" {
+				return ZVolumeInfo{}, fmt.Errorf("%s is a %s, not a volume", name, fields[1])
+			}
+		case "volsize":
+			info.SizeBytes = parseInt64(fields[1])
+		case "used":
+			info.UsedBytes = parseInt64(fields[1])
+		case "referenced":
+			info.Referenced = parseInt64(fields[1])
+		case "volblocksize":
+			info.VolBlockSize = fields[1]
+		}
+	}
+	return info, nil
+}
+
+// ensureZVolume creates the zvol if missing, applies properties, and grows
+// it when the requested size is larger than the current volsize.
+func ensureZVolume(req ZVolumeEnsureRequest) (ZVolumeResponse, error) {
+	name := strings.TrimSpace(req.Name)
+	var resp ZVolumeResponse
+	props := map[string]string{}
+	for k, v := range req.Properties {
+		k = strings.TrimSpace(strings.ToLower(k))
+		if v = strings.TrimSpace(v); k != "" && v != "" {
+			props[k] = v
+		}
+	}
+	if c := strings.TrimSpace(req.Compression); c != "" {
+		props["compression"] = c
+	}
+	if !datasetExists(name) {
+		args := []string{"create", "-p", "-V", strings.TrimSpace(req.Size)}
+		if req.Sparse {
+			args = append(args, "-s")
+		}
+		if bs := strings.TrimSpace(req.VolBlockSize); bs != "" {
+			args = append(args, "-o", "volblocksize="+bs)
+		}
+		for k, v := range props {
+			args = append(args, "-o", k+"="+v)
+		}
+		args = append(args, name)
+		out, err := runCmdCombined(context.Background(), 60*time.Second, "zfs", args...)
+		resp.Output = out
+		if err != nil {
+			return resp, err
+		}
+		resp.Created = true
+	} else {
+		for k, v := range props {
+			if out, err := runCmdCombined(context.Background(), 30*time.Second, "zfs", "set", k+"="+v, name); err != nil {
+				return resp, fmt.Errorf("zfs set %s: %s", k, strings.TrimSpace(out))
+			}
+		}
+		resized, out, err := resizeZVolume(name, req.Size)
+		resp.Output = out
+		if err != nil {
+			return resp, err
+		}
+		resp.Resized = resized
+	}
+	info, err := getZVolumeInfo(name)
+	if err != nil {
+		return resp, err
+	}
+	resp.Volume = &info
+	if bs := strings.TrimSpace(req.VolBlockSize); bs != "" && !resp.Created {
+		if want, err := parseZFSSize(bs); err == nil && want != parseInt64(info.VolBlockSize) {
+			resp.Output = strings.TrimSpace(resp.Output + fmt.Sprintf("\nvolblocksize is %s; it cannot change after creation", info.VolBlockSize))
+		}
+	}
+	return resp, nil
+}
+
+// resizeZVolume grows a zvol online. The new size is rounded up to a multiple
+// of volblocksize. Shrinking is refused because it would truncate data.
+func resizeZVolume(name string, size string) (bool, string, error) {
+	want, err := parseZFSSize(size)
+	if err != nil {
+		return false, "", err
+	}
+	info, err := getZVolumeInfo(name)
+	if err != nil {
+		return false, "", err
+	}
+	if bs := parseInt64(info.VolBlockSize); bs > 0 && want%bs != 0 {
+		want += bs - want%bs
+	}
+	switch {
+	case want == info.SizeBytes:
+		return false, "", nil
+	case want < info.SizeBytes:
+		return false, "", fmt.Errorf("refusing to shrink %s from %d to %d bytes", name, info.SizeBytes, want)
+	}
+	out, err := runCmdCombined(context.Background(), 60*time.Second, "zfs", "set", fmt.Sprintf("volsize=%d", want), name)
+	if err != nil {
+		return false, out, err
+	}
+	return true, out, nil
+}
+
+func datasetExists(full string) bool {
+	_, err := runCmdCombined(context.Background(), 30*time.Second, "zfs", "list", "-H", "-o", "name", full)
+	return err == nil
+}
+
+// ensureDatasetOwner chowns the top of the mountpoint to uid:gid and applies
+// the optional mode. Contents are left alone so existing data is not touched.
+func ensureDatasetOwner(full string, mountpoint string, owner DatasetOwner) (string, error) {
+	if owner.UID < 0 || owner.GID < 0 {
+		return "", fmt.Errorf("invalid owner %d:%d", owner.UID, owner.GID)
+	}
+	out, err := ensureDatasetMounted(full, mountpoint, "", false)
+	if err != nil {
+		return out, err
+	}
+	mp := strings.TrimSpace(mountpoint)
+	if mp == "" {
+		mp, err = getDatasetMountpoint(full)
+		if err != nil {
+			return mp, err
+		}
+	}
+	if mp == "" || mp == "none" || mp == "-" || mp == "legacy" {
+		return out, fmt.Errorf("mountpoint not available for %s", full)
+	}
+	out, err = runCmdCombined(context.Background(), 30*time.Second, "chown", fmt.Sprintf("%d:%d", owner.UID, owner.GID), mp)
+	if err != nil {
+		return out, err
+	}
+	if mode := strings.TrimSpace(owner.Mode); mode != "" {
+		return runCmdCombined(context.Background(), 30*time.Second, "chmod", mode, mp)
+	}
+	return out, nil
+}
+
+const (
+	aclTypeNFSv4 = "nfsv4"
+	aclTypePOSIX = "posix"
+)
+
+var (
+	nfs4PermsRe  = regexp.MustCompile(`^[rwaDdxtTnNcCoy]+$`)
+	posixPermsRe = regexp.MustCompile(`^[rwx-]{1,3}$`)
+
+	posixPermAliases = map[string]string{
+		"full":     "rwx",
+		"modify":   "rwx",
+		"read":     "r-x",
+		"traverse": "--x",
+	}
+
+	nfs4PermAliases = map[string]string{
+		"full":     "rwaDdxtTnNcCoy",
+		"modify":   "rwaDdxtTnNcy",
+		"read":     "rxtncy",
+		"traverse": "xtncy",
+	}
+
+	nfs4FlagAliases = map[string]string{
+		"file_inherit": "f",
+		"dir_inherit":  "d",
+		"no_propagate": "n",
+		"inherit_only": "i",
+	}
+)
+
+type aclPathSpec struct {
+	Path string // relative, cleaned; "" for the root
+	ACEs []string
+}
+
+func normalizeACLType(t string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(t)) {
+	case "", "nfsv4", "nfs4":
+		return aclTypeNFSv4, nil
+	case "posix", "posixacl":
+		return aclTypePOSIX, nil
+	default:
+		return "", fmt.Errorf("unsupported acl type %q", t)
+	}
+}
+
+// nfs4ACE renders an ACE in nfs4_setfacl syntax (type:flags:principal:perms).
+func nfs4ACE(ace DatasetACE) (string, error) {
+	typ := "A"
+	switch strings.ToLower(strings.TrimSpace(ace.Type)) {
+	case "", "allow":
+	case "deny":
+		typ = "D"
+	default:
+		return "", fmt.Errorf("unsupported ace type %q", ace.Type)
+	}
+	if ace.Default {
+		return "", errors.New("default aces require posix acls; use inheritance flags")
+	}
+	who := strings.TrimSpace(ace.Principal)
+	switch strings.ToUpper(who) {
+	case "OWNER@", "GROUP@", "EVERYONE@":
+		who = strings.ToUpper(who)
+	case "MASK@":
+		return "", errors.New("mask@ requires posix acls")
+	case "":
+		return "", errors.New("ace principal required")
+	}
+	if strings.ContainsAny(who, ":, \t\n") {
+		return "", fmt.Errorf("invalid ace principal %q", who)
+	}
+	perms := strings.TrimSpace(ace.Perms)
+	if alias, ok := nfs4PermAliases[strings.ToLower(perms)]; ok {
+		perms = alias
+	}
+	if !nfs4PermsRe.MatchString(perms) {
+		return "", fmt.Errorf("invalid ace permissions %q", ace.Perms)
+	}
+	flags := ""
+	for _, f := range ace.Flags {
+		f = strings.ToLower(strings.TrimSpace(f))
+		letter, ok := nfs4FlagAliases[f]
+		if !ok {
+			return "", fmt.Errorf("unsupported ace flag %q", f)
+		}
+		if !strings.Contains(flags, letter) {
+			flags += letter
+		}
+	}
+	if ace.Group || who == "GROUP@" {
+		flags += "g"
+	}
+	return fmt.Sprintf("%s:%s:%s:%s", typ, flags, who, perms), nil
+}
+
+// posixACE renders an entry in setfacl syntax, e.g. default:group:1000:rwx.
+func posixACE(ace DatasetACE) (string, error) {
+	if t := strings.ToLower(strings.TrimSpace(ace.Type)); t != "" && t != "allow" {
+		return "", fmt.Errorf("%s aces require nfsv4 acls", t)
+	}
+	if len(ace.Flags) > 0 {
+		return "", errors.New("inheritance flags require nfsv4 acls; use default entries")
+	}
+	who := strings.TrimSpace(ace.Principal)
+	var tag string
+	switch strings.ToUpper(who) {
+	case "OWNER@":
+		tag = "user:"
+	case "GROUP@":
+		tag = "group:"
+	case "EVERYONE@":
+		tag = "other:"
+	case "MASK@":
+		tag = "mask:"
+	case "":
+		return "", errors.New("ace principal required")
+	default:
+		if strings.ContainsAny(who, ":, \t\n") {
+			return "", fmt.Errorf("invalid ace principal %q", who)
+		}
+		tag = "user:" + who
+		if ace.Group {
+			tag = "group:" + who
+		}
+	}
+	perms := strings.ToLower(strings.TrimSpace(ace.Perms))
+	if alias, ok := posixPermAliases[perms]; ok {
+		perms = alias
+	}
+	if !posixPermsRe.MatchString(perms) {
+		return "", fmt.Errorf("invalid ace permissions %q", ace.Perms)
+	}
+	canon := []byte("---")
+	for i, c := range "rwx" {
+		if strings.ContainsRune(perms, c) {
+			canon[i] = byte(c)
+		}
+	}
+	line := tag + ":" + string(canon)
+	if ace.Default {
+		line = "default:" + line
+	}
+	return line, nil
+}
+
+func aclSpecs(typ string, paths []DatasetACLPath) ([]aclPathSpec, error) {
+	render := nfs4ACE
+	if typ == aclTypePOSIX {
+		render = posixACE
+	}
+	var out []aclPathSpec
+	seen := map[string]struct{}{}
+	for _, p := range paths {
+		rel, err := cleanACLPath(p.Path)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[rel]; ok {
+			return nil, fmt.Errorf("duplicate acl path %q", p.Path)
+		}
+		seen[rel] = struct{}{}
+		if len(p.ACEs) == 0 {
+			return nil, fmt.Errorf("acl path %q has no aces", p.Path)
+		}
+		spec := aclPathSpec{Path: rel}
+		for _, ace := range p.ACEs {
+			line, err := render(ace)
+			if err != nil {
+				return nil, fmt.Errorf("acl path %q: %w", p.Path, err)
+			}
+			spec.ACEs = append(spec.ACEs, line)
+		}
+		out = append(out, spec)
+	}
+	return out, nil
+}
+
+// cleanACLPath keeps ACL paths inside the mountpoint.
+func cleanACLPath(p string) (string, error) {
+	p = strings.TrimSpace(p)
+	if p == "" || p == "/" || p == "." {
+		return "", nil
+	}
+	clean := filepath.Clean("/" + p)
+	if clean == "/" {
+		return "", nil
+	}
+	rel := strings.TrimPrefix(clean, "/")
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("acl path %q escapes the dataset", p)
+	}
+	return rel, nil
+}
+
+// aclMountpoint checks that the dataset acltype matches the requested ACL
+// type and returns the mounted path.
+func aclMountpoint(dataset string, mountpoint string, typ string) (string, string, error) {
+	tools := []string{"nfs4_setfacl", "nfs4_getfacl"}
+	if typ == aclTypePOSIX {
+		tools = []string{"setfacl", "getfacl"}
+	}
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			return "", "", fmt.Errorf("%s not available: %w", tool, err)
+		}
+	}
+	acltype, err := getDatasetPropertyValue(dataset, "acltype")
+	if err != nil {
+		return "", "", err
+	}
+	acltype = strings.ToLower(strings.TrimSpace(acltype))
+	if acltype == "posixacl" {
+		acltype = aclTypePOSIX
+	}
+	if acltype != typ {
+		return "", "", fmt.Errorf("acltype=%s on %s; %s aces not applicable", acltype, dataset, typ)
+	}
+	out, err := ensureDatasetMounted(dataset, mountpoint, "", false)
+	if err != nil {
+		return "", out, err
+	}
+	mp := strings.TrimSpace(mountpoint)
+	if mp == "" {
+		if mp, err = getDatasetMountpoint(dataset); err != nil {
+			return "", mp, err
+		}
+	}
+	if mp == "" || mp == "none" || mp == "-" || mp == "legacy" {
+		return "", out, fmt.Errorf("mountpoint not available for %s", dataset)
+	}
+	return mp, out, nil
+}
+
+// applyACLs rewrites the ACL of each path whose current ACL does not match
+// the desired ACEs, and returns the paths it changed. Missing sub-paths are
+// created.
+func applyACLs(typ string, mp string, specs []aclPathSpec) ([]string, string, error) {
+	var changed []string
+	var outs []string
+	for _, spec := range specs {
+		target := filepath.Join(mp, spec.Path)
+		if spec.Path != "" {
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return changed, strings.Join(outs, "\n"), fmt.Errorf("acl path %s: %w", target, err)
+			}
+		}
+		var current []string
+		var out string
+		var err error
+		if typ == aclTypePOSIX {
+			current, out, err = getPosixACL(target)
+		} else {
+			current, out, err = getNfs4ACL(target)
+		}
+		if err != nil {
+			return changed, out, err
+		}
+		if typ == aclTypePOSIX {
+			desired := completePosixACL(spec.ACEs, current)
+			if posixACLEqual(desired, current) {
+				continue
+			}
+			out, err = runCmdCombined(context.Background(), 30*time.Second, "setfacl", "--set", strings.Join(desired, ","), target)
+		} else {
+			if nfs4ACLEqual(current, spec.ACEs) {
+				continue
+			}
+			out, err = runCmdCombined(context.Background(), 30*time.Second, "nfs4_setfacl", "-s", strings.Join(spec.ACEs, ","), target)
+		}
+		if strings.TrimSpace(out) != "" {
+			outs = append(outs, out)
+		}
+		if err != nil {
+			return changed, strings.Join(outs, "\n"), err
+		}
+		changed = append(changed, "/"+spec.Path)
+	}
+	return changed, strings.Join(outs, "\n"), nil
+}
+
+func getNfs4ACL(path string) ([]string, string, error) {
+	out, err := runCmdCombined(context.Background(), 15*time.Second, "nfs4_getfacl", path)
+	if err != nil {
+		return nil, out, err
+	}
+	var aces []string
+	for _, line := range splitLines(out) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		aces = append(aces, line)
+	}
+	return aces, out, nil
+}
+
+// nfs4ACLEqual compares ACE lists in order, ignoring letter order within the
+// flag and permission fields and whether principals are names or ids.
+func nfs4ACLEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if normalizeNfs4ACE(a[i]) != normalizeNfs4ACE(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func normalizeNfs4ACE(ace string) string {
+	parts := strings.SplitN(strings.TrimSpace(ace), ":", 4)
+	if len(parts) != 4 {
+		return ace
+	}
+	sortLetters := func(s string) string {
+		b := []byte(s)
+		sort.Slice(b, func(i, j int) bool { return b[i] < b[j] })
+		return string(b)
+	}
+	who := normalizeNfs4Principal(parts[2], strings.Contains(parts[1], "g"))
+	return strings.Join([]string{parts[0], sortLetters(parts[1]), who, sortLetters(parts[3])}, ":")
+}
+
+// normalizeNfs4Principal maps a principal to its numeric id, so an ACE set
+// by id matches nfs4_getfacl printing the name (with or without an id
+// mapping domain) and the reverse. Principals that do not resolve are kept.
+func normalizeNfs4Principal(who string, group bool) string {
+	switch up := strings.ToUpper(who); up {
+	case "OWNER@", "GROUP@", "EVERYONE@":
+		return up
+	}
+	name, _, _ := strings.Cut(who, "@")
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return name
+	}
+	if group {
+		if g, err := user.LookupGroup(name); err == nil {
+			return g.Gid
+		}
+	} else if u, err := user.Lookup(name); err == nil {
+		return u.Uid
+	}
+	return who
+}
+
+func getPosixACL(path string) ([]string, string, error) {
+	out, err := runCmdCombined(context.Background(), 15*time.Second, "getfacl", "-c", "-n", "-p", path)
+	if err != nil {
+		return nil, out, err
+	}
+	var aces []string
+	for _, line := range splitLines(out) {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			aces = append(aces, line)
+		}
+	}
+	return aces, out, nil
+}
+
+// completePosixACL adds the owner/group/other entries setfacl --set requires,
+// copying them from the current ACL when the spec leaves them out. Default
+// base entries fall back to the access entries.
+func completePosixACL(desired []string, current []string) []string {
+	has := func(list []string, prefix string) bool {
+		for _, e := range list {
+			if strings.HasPrefix(e, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	find := func(list []string, prefix string) string {
+		for _, e := range list {
+			if strings.HasPrefix(e, prefix) {
+				return e
+			}
+		}
+		return ""
+	}
+	out := append([]string{}, desired...)
+	scopes := []string{""}
+	if has(desired, "default:") {
+		scopes = append(scopes, "default:")
+	}
+	for _, scope := range scopes {
+		for _, base := range []string{"user::", "group::", "other::"} {
+			if has(desired, scope+base) {
+				continue
+			}
+			if e := find(current, scope+base); e != "" {
+				out = append(out, e)
+			} else if e := find(out, base); e != "" {
+				out = append(out, scope+e)
+			} else if e := find(current, base); e != "" {
+				out = append(out, scope+e)
+			}
+		}
+	}
+	return out
+}
+
+// posixACLEqual compares entries as sets. Mask entries are ignored for a
+// scope the spec does not set, since setfacl recalculates them.
+func posixACLEqual(desired []string, current []string) bool {
+	set := func(list []string, skipMask map[string]bool) map[string]struct{} {
+		m := map[string]struct{}{}
+		for _, e := range list {
+			scope := ""
+			if strings.HasPrefix(e, "default:") {
+				scope = "default:"
+			}
+			if skipMask[scope] && strings.HasPrefix(strings.TrimPrefix(e, scope), "mask:") {
+				continue
+			}
+			m[e] = struct{}{}
+		}
+		return m
+	}
+	skipMask := map[string]bool{"": true, "default:": true}
+	for _, e := range desired {
+		if strings.HasPrefix(e, "mask:") {
+			skipMask[""] = false
+		}
+		if strings.HasPrefix(e, "default:mask:") {
+			skipMask["default:"] = false
+		}
+	}
+	a, b := set(desired, skipMask), set(current, skipMask)
+	if len(a) != len(b) {
+		return false
+	}
+	for e := range a {
+		if _, ok := b[e]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// resetACL applies an ACL to a whole tree. POSIX default entries only go on
+// directories.
+func resetACL(typ string, target string, aces []string) (string, error) {
+	if typ != aclTypePOSIX {
+		return runCmdCombined(context.Background(), 12*time.Hour, "nfs4_setfacl", "-R", "-s", strings.Join(aces, ","), target)
+	}
+	current, out, err := getPosixACL(target)
+	if err != nil {
+		return out, err
+	}
+	var access, defaults []string
+	for _, e := range completePosixACL(aces, current) {
+		if strings.HasPrefix(e, "default:") {
+			defaults = append(defaults, e)
+		} else {
+			access = append(access, e)
+		}
+	}
+	out, err = runCmdCombined(context.Background(), 12*time.Hour, "setfacl", "-R", "--set", strings.Join(access, ","), target)
+	if err != nil || len(defaults) == 0 {
+		return out, err
+	}
+	return runCmdCombined(context.Background(), 12*time.Hour, "find", target, "-type", "d", "-exec", "setfacl", "-m", strings.Join(defaults, ","), "{}", "+")
+}
+
+// startACLReset resets the ACL of every path recursively in the background.
+// A repeated call with the same token reports the job instead of restarting it.
+func startACLReset(dataset string, typ string, token string, mp string, specs []aclPathSpec) ZDatasetACLResetStatus {
+	aclResets.mu.Lock()
+	defer aclResets.mu.Unlock()
+	if aclResets.jobs == nil {
+		aclResets.jobs = map[string]*ZDatasetACLResetStatus{}
+	}
+	if job, ok := aclResets.jobs[dataset]; ok && job.Token == token {
+		return *job
+	}
+	if job, ok := aclResets.jobs[dataset]; ok && job.State == "Running" {
+		return ZDatasetACLResetStatus{Token: token, State: "Pending", Message: fmt.Sprintf("reset %s still running", job.Token)}
+	}
+	job := &ZDatasetACLResetStatus{Token: token, State: "Running", Started: time.Now().UTC().Format(time.RFC3339)}
+	aclResets.jobs[dataset] = job
+	go func() {
+		var msg string
+		state := "Complete"
+		for _, spec := range specs {
+			target := filepath.Join(mp, spec.Path)
+			out, err := resetACL(typ, target, spec.ACEs)
+			if err != nil {
+				state = "Failed"
+				msg = strings.TrimSpace(fmt.Sprintf("%s: %v %s", target, err, out))
+				break
+			}
+		}
+		aclResets.mu.Lock()
+		job.State = state
+		job.Message = msg
+		job.Finished = time.Now().UTC().Format(time.RFC3339)
+		aclResets.mu.Unlock()
+	}()
+	return *job
+}
+
+// snapshotMount returns the mountpoint of the snapshot's dataset and the
+// snapshot's directory under .zfs/snapshot.
+func snapshotMount(snap string) (string, string, error) {
+	at := strings.Index(snap, "@")
+	if at <= 0 || at == len(snap)-1 || strings.Contains(snap[at+1:], "/") {
+		return "", "", fmt.Errorf("snapshot (dataset@name) required")
+	}
+	mp, err := getDatasetMountpoint(snap[:at])
+	if err != nil {
+		return "", "", err
+	}
+	if !strings.HasPrefix(mp, "/") {
+		return "", "", fmt.Errorf("dataset %s is not mounted (mountpoint %s)", snap[:at], mp)
+	}
+	mp = filepath.Clean(mp)
+	return mp, filepath.Join(mp, ".zfs", "snapshot", snap[at+1:]), nil
+}
+
+// resolveSnapshotPath maps a dataset-relative path into the snapshot. The
+// parent directory is resolved through symlinks and must stay inside the
+// snapshot; the leaf itself is not followed.
+func resolveSnapshotPath(snap, rel string) (root string, full string, clean string, err error) {
+	_, root, err = snapshotMount(snap)
+	if err != nil {
+		return "", "", "", err
+	}
+	clean = filepath.Clean("/" + strings.TrimSpace(rel))
+	full = filepath.Join(root, clean)
+	if clean == "/" {
+		if _, err := os.Stat(root); err != nil {
+			return "", "", "", fmt.Errorf("snapshot %s not accessible: %w", snap, err)
+		}
+		return root, root, clean, nil
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", "", "", fmt.Errorf("snapshot %s not accessible: %w", snap, err)
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(full))
+	if err != nil {
+		return "", "", "", err
+	}
+	if parent != realRoot && !strings.HasPrefix(parent, realRoot+"/") {
+		return "", "", "", fmt.Errorf("path %s escapes the snapshot", clean)
+	}
+	return realRoot, filepath.Join(parent, filepath.Base(full)), clean, nil
+}
+
+func snapshotFileEntry(root, full string) (SnapshotFileEntry, error) {
+	fi, err := os.Lstat(full)
+	if err != nil {
+		return SnapshotFileEntry{}, err
+	}
+	rel := "/" + strings.TrimPrefix(strings.TrimPrefix(full, root), "/")
+	e := SnapshotFileEntry{
+		Name:    fi.Name(),
+		Path:    rel,
+		Size:    fi.Size(),
+		Mode:    fmt.Sprintf("%04o", fi.Mode().Perm()|fi.Mode()&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky)),
+		ModTime: fi.ModTime().UTC().Format(time.RFC3339),
+	}
+	switch {
+	case fi.Mode().IsRegular():
+		e.Type = "file"
+	case fi.IsDir():
+		e.Type = "directory"
+	case fi.Mode()&os.ModeSymlink != 0:
+		e.Type = "symlink"
+		e.LinkTarget, _ = os.Readlink(full)
+	default:
+		e.Type = "other"
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		e.UID, e.GID = st.Uid, st.Gid
+	}
+	return e, nil
+}
+
+// startFileRestore copies paths out of a snapshot in the background. A
+// repeated call with the same token reports the job instead of restarting
+// it, so the caller can poll.
+func startFileRestore(req SnapshotFileRestoreRequest) (SnapshotFileRestoreStatus, error) {
+	token := strings.TrimSpace(req.Token)
+	if token == "" || len(req.Paths) == 0 {
+		return SnapshotFileRestoreStatus{}, fmt.Errorf("token and paths required")
+	}
+	policy := strings.ToLower(strings.TrimSpace(req.ConflictPolicy))
+	if policy == "" {
+		policy = "rename"
+	}
+	if policy != "overwrite" && policy != "rename" && policy != "skip" {
+		return SnapshotFileRestoreStatus{}, fmt.Errorf("conflictPolicy must be overwrite, rename or skip")
+	}
+	var mp, destRoot string
+	prepare := func() (*SnapshotFileRestoreStatus, error) {
+		var err error
+		mp, _, err = snapshotMount(strings.TrimSpace(req.Snapshot))
+		if err != nil {
+			return nil, err
+		}
+		destRoot = mp
+		if t := strings.TrimSpace(req.TargetDirectory); t != "" {
+			destRoot = filepath.Join(mp, filepath.Clean("/"+t))
+			if destRoot == mp {
+				return nil, fmt.Errorf("invalid targetDirectory %q", t)
+			}
+		}
+		if destRoot == filepath.Join(mp, ".zfs") || strings.HasPrefix(destRoot, filepath.Join(mp, ".zfs")+"/") {
+			return nil, fmt.Errorf("cannot restore into .zfs")
+		}
+		return &SnapshotFileRestoreStatus{Token: token, State: "Running", Started: time.Now().UTC().Format(time.RFC3339)}, nil
+	}
+	return fileRestores.start(token, prepare, func(job *SnapshotFileRestoreStatus) func() {
+		results := make([]SnapshotFileRestoreResult, 0, len(req.Paths))
+		failed := 0
+		for _, p := range req.Paths {
+			res := restoreSnapshotPath(strings.TrimSpace(req.Snapshot), p, mp, destRoot, policy)
+			if res.Action == "failed" {
+				failed++
+			}
+			results = append(results, res)
+		}
+		return func() {
+			job.Results = results
+			job.State = "Complete"
+			if failed > 0 {
+				job.State = "Failed"
+				job.Message = fmt.Sprintf("%d of %d path(s) failed", failed, len(results))
+			}
+			job.Finished = time.Now().UTC().Format(time.RFC3339)
+		}
+	})
+}
+
+// restoreSnapshotPath copies one path with cp -a, which keeps ownership,
+// modes, timestamps, POSIX ACLs and xattrs. Existing directories are merged
+// under overwrite; other existing destinations are replaced.
+func restoreSnapshotPath(snap, rel, mp, destRoot, policy string) SnapshotFileRestoreResult {
+	res := SnapshotFileRestoreResult{Path: rel, Action: "failed"}
+	_, src, clean, err := resolveSnapshotPath(snap, rel)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	if clean == "/" {
+		res.Error = "restoring the whole dataset is not supported; use a clone restore"
+		return res
+	}
+	res.Path = clean
+	srcInfo, err := os.Lstat(src)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	dest := filepath.Join(destRoot, clean)
+	parent, err := mkdirWithin(filepath.Dir(dest), mp)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	dest = filepath.Join(parent, filepath.Base(dest))
+
+	action := "restored"
+	args := []string{"-a", "-T"}
+	if destInfo, err := os.Lstat(dest); err == nil {
+		switch policy {
+		case "skip":
+			res.Destination = dest
+			res.Action = "skipped"
+			return res
+		case "rename":
+			dest = restoreRenameTarget(dest)
+			action = "renamed"
+		case "overwrite":
+			if !(destInfo.IsDir() && srcInfo.IsDir()) {
+				if err := os.RemoveAll(dest); err != nil {
+					res.Error = err.Error()
+					return res
+				}
+			}
+		}
+	}
+	out, err := runCmdCombined(context.Background(), 6*time.Hour, "cp", append(args, src, dest)...)
+	if err != nil {
+		res.Destination = dest
+		res.Error = strings.TrimSpace(fmt.Sprintf("%v %s", err, out))
+		return res
+	}
+	res.Destination = dest
+	res.Action = action
+	return res
+}
+
+// mkdirWithin creates dir and returns its resolved path. The live tree may
+// contain symlinks, so the deepest existing ancestor is resolved and checked
+// against root first; only the missing components below it are created.
+func mkdirWithin(dir, root string) (string, error) {
+	existing, missing := dir, ""
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		up := filepath.Dir(existing)
+		if up == existing {
+			break
+		}
+		missing = filepath.Join(filepath.Base(existing), missing)
+		existing = up
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil || (resolved != root && !strings.HasPrefix(resolved, root+"/")) {
+		return "", fmt.Errorf("destination %s escapes the dataset", dir)
+	}
+	resolved = filepath.Join(resolved, missing)
+	if err := os.MkdirAll(resolved, 0o755); err != nil {
+		return "", err
+	}
+	return resolved, nil
+}
+
+// restoreRenameTarget picks <dest>.restored-<UTC time>[-N] that does not exist.
+func restoreRenameTarget(dest string) string {
+	base := fmt.Sprintf("%s.restored-%s", dest, time.Now().UTC().Format("20060102T150405Z"))
+	cand := base
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(cand); os.IsNotExist(err) {
+			return cand
+		}
+		cand = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// startRollback rolls a dataset back to a snapshot in the background, after
+// copying a snapshot of its current state to a separate dataset. A repeated
+// call with the same token reports the job instead of restarting it.
+func startRollback(req ZSnapshotRollbackRequest) (ZSnapshotRollbackStatus, error) {
+	token := strings.TrimSpace(req.Token)
+	snap := strings.TrimSpace(req.Snapshot)
+	safetyName := strings.TrimSpace(req.SafetyName)
+	safetyDS := strings.Trim(strings.TrimSpace(req.SafetyDataset), "/")
+	if token == "" || safetyName == "" || safetyDS == "" {
+		return ZSnapshotRollbackStatus{}, fmt.Errorf("token, safetyName and safetyDataset required")
+	}
+	at := strings.Index(snap, "@")
+	if at <= 0 || strings.ContainsAny(safetyName, "@/") || strings.Contains(safetyDS, "@") {
+		return ZSnapshotRollbackStatus{}, fmt.Errorf("snapshot must be dataset@name and safetyName a bare snapshot name")
+	}
+	ds := snap[:at]
+	prepare := func() (*ZSnapshotRollbackStatus, error) {
+		if !datasetExists(snap) {
+			return nil, fmt.Errorf("snapshot %s not found", snap)
+		}
+		// Refuse up front rather than after the safety copy: without -r, zfs
+		// rollback only goes back to the newest snapshot.
+		out, err := runCmdCombined(context.Background(), 30*time.Second, "zfs", "list", "-H", "-o", "name", "-t", "snapshot", "-s", "createtxg", "-d", "1", ds)
+		if err != nil {
+			return nil, fmt.Errorf("zfs list failed: %v %s", err, strings.TrimSpace(out))
+		}
+		var newer []string
+		seen := false
+		for _, name := range splitLines(out) {
+			switch {
+			case name == snap:
+				seen = true
+			case seen && name != ds+"@"+safetyName && name != ds+"@"+safetyName+"-base":
+				newer = append(newer, name)
+			}
+		}
+		if len(newer) > 0 && !req.Force {
+			return nil, fmt.Errorf("%d snapshot(s) newer than %s would be destroyed (e.g. %s); set force", len(newer), snap, newer[0])
+		}
+		if !datasetExists(safetyDS+"@"+safetyName) && !datasetExists(safetyDS+"@"+safetyName+"-base") {
+			if err := checkSafetyCopySpace(ds, safetyDS); err != nil {
+				return nil, err
+			}
+		}
+		return &ZSnapshotRollbackStatus{Token: token, State: "Running", Started: time.Now().UTC().Format(time.RFC3339)}, nil
+	}
+	return rollbacks.start(token, prepare, func(job *ZSnapshotRollbackStatus) func() {
+		var safety string
+		var err error
+		if req.CopyOnly {
+			safety, err = copySafetyBase(ds, safetyName, safetyDS)
+		} else {
+			safety, err = rollbackDataset(ds, snap, safetyName, safetyDS, req.Force)
+		}
+		return func() {
+			job.SafetySnapshot = safety
+			job.State = "Complete"
+			if err != nil {
+				job.State = "Failed"
+				job.Message = err.Error()
+			}
+			job.Finished = time.Now().UTC().Format(time.RFC3339)
+		}
+	})
+}
+
+// checkSafetyCopySpace refuses a rollback whose safety copy cannot fit: the
+// copy is a full, independent dataset, so it needs about as much free space
+// as ds currently references.
+func checkSafetyCopySpace(ds, safetyDS string) error {
+	parent := safetyDS
+	if i := strings.LastIndex(parent, "/"); i > 0 {
+		parent = parent[:i]
+	}
+	out, err := runCmdCombined(context.Background(), 30*time.Second, "zfs", "get", "-Hp", "-o", "value", "referenced", ds)
+	if err != nil {
+		return fmt.Errorf("zfs get referenced %s failed: %v %s", ds, err, strings.TrimSpace(out))
+	}
+	need := parseInt64(strings.TrimSpace(out))
+	out, err = runCmdCombined(context.Background(), 30*time.Second, "zfs", "get", "-Hp", "-o", "value", "available", parent)
+	if err != nil {
+		return fmt.Errorf("zfs get available %s failed: %v %s", parent, err, strings.TrimSpace(out))
+	}
+	if avail := parseInt64(strings.TrimSpace(out)); avail < need {
+		return fmt.Errorf("safety copy of %s needs about %d bytes but %s has %d available", ds, need, parent, avail)
+	}
+	return nil
+}
+
+// copySafetyBase snapshots ds as <safetyName>-base and copies it in full to
+// a new safetyDS. It runs before the shares are paused, so the long full
+// copy does not keep them offline; rollbackDataset then only sends the
+// changes made since. It is skipped if already done.
+//
+// The copy is a full zfs send/receive rather than a clone, so the safety
+// dataset survives the rollback destroying newer snapshots. It therefore
+// uses as much space again as ds references and can take hours on a large
+// dataset; startRollback checks the space before starting.
+func copySafetyBase(ds, safetyName, safetyDS string) (string, error) {
+	ctx := context.Background()
+	local := ds + "@" + safetyName + "-base"
+	base := safetyDS + "@" + safetyName + "-base"
+	if datasetExists(base) || datasetExists(safetyDS+"@"+safetyName) {
+		return base, nil
+	}
+	if datasetExists(safetyDS) {
+		return "", fmt.Errorf("safety dataset %s exists without %s", safetyDS, base)
+	}
+	if !datasetExists(local) {
+		if out, err := runCmdCombined(ctx, 60*time.Second, "zfs", "snapshot", local); err != nil {
+			return "", fmt.Errorf("zfs snapshot %s failed: %v %s", local, err, strings.TrimSpace(out))
+		}
+	}
+	if err := sendReceiveLocal(ctx, "", local, safetyDS); err != nil {
+		return "", err
+	}
+	return base, nil
+}
+
+// rollbackDataset snapshots ds, copies that snapshot to safetyDS, drops the
+// local snapshots from ds and rolls ds back to snap. It returns the copied
+// snapshot once the copy exists. If copySafetyBase has run, only the changes
+// since its base are sent. Each step is skipped if already done, so a job
+// restarted after a node-agent restart picks up where it stopped.
+func rollbackDataset(ds, snap, safetyName, safetyDS string, force bool) (string, error) {
+	ctx := context.Background()
+	local := ds + "@" + safetyName
+	localBase := local + "-base"
+	safety := safetyDS + "@" + safetyName
+	if !datasetExists(safety) {
+		from := ""
+		if datasetExists(safetyDS + "@" + safetyName + "-base") {
+			if !datasetExists(localBase) {
+				return "", fmt.Errorf("%s is missing; cannot send the changes since the safety copy", localBase)
+			}
+			from = localBase
+		} else if datasetExists(safetyDS) {
+			return "", fmt.Errorf("safety dataset %s exists without %s", safetyDS, safety)
+		}
+		if !datasetExists(local) {
+			if out, err := runCmdCombined(ctx, 60*time.Second, "zfs", "snapshot", local); err != nil {
+				return "", fmt.Errorf("zfs snapshot %s failed: %v %s", local, err, strings.TrimSpace(out))
+			}
+		}
+		if err := sendReceiveLocal(ctx, from, local, safetyDS); err != nil {
+			return "", err
+		}
+	}
+	for _, name := range []string{local, localBase} {
+		if !datasetExists(name) {
+			continue
+		}
+		if out, err := runCmdCombined(ctx, 120*time.Second, "zfs", "destroy", name); err != nil {
+			return safety, fmt.Errorf("zfs destroy %s failed: %v %s", name, err, strings.TrimSpace(out))
+		}
+	}
+	args := []string{"rollback"}
+	if force {
+		args = append(args, "-r")
+	}
+	if out, err := runCmdCombined(ctx, 10*time.Minute, "zfs", append(args, snap)...); err != nil {
+		return safety, fmt.Errorf("zfs rollback %s failed: %v %s", snap, err, strings.TrimSpace(out))
+	}
+	return safety, nil
+}
+
+// sendReceiveLocal copies snap into a new, unmounted, read-only dataset or,
+// with base, sends the changes since base to the existing target.
+func sendReceiveLocal(ctx context.Context, base, snap, target string) error {
+	ctx, cancel := context.WithTimeout(ctx, 24*time.Hour)
+	defer cancel()
+	sendArgs := []string{"send", snap}
+	recvArgs := []string{"receive", "-u", "-o", "canmount=noauto", "-o", "readonly=on", target}
+	if base != "" {
+		sendArgs = []string{"send", "-i", base, snap}
+		recvArgs = []string{"receive", "-u", target}
+	}
+	send := exec.CommandContext(ctx, "zfs", sendArgs...)
+	recv := exec.CommandContext(ctx, "zfs", recvArgs...)
+	pipe, err := send.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	recv.Stdin = pipe
+	var sendErr, recvErr strings.Builder
+	send.Stderr = &sendErr
+	recv.Stderr = &recvErr
+	if err := recv.Start(); err != nil {
+		return err
+	}
+	if err := send.Start(); err != nil {
+		_ = recv.Process.Kill()
+		_ = recv.Wait()
+		return err
+	}
+	serr := send.Wait()
+	rerr := recv.Wait()
+	// A failed receive also breaks the send's pipe; report the cause.
+	if rerr != nil {
+		return fmt.Errorf("zfs receive %s failed: %v %s", target, rerr, strings.TrimSpace(recvErr.String()))
+	}
+	if serr != nil {
+		return fmt.Errorf("zfs send %s failed: %v %s", snap, serr, strings.TrimSpace(sendErr.String()))
+	}
+	return nil
+}
+
+// replicationTokenHeader carries the token of the prepared receive a
+// stream is for; the secret travels as a bearer token.
+const replicationTokenHeader = "X-NAS-Replication-Token"
+
+func prepareReplicationReceive(req ZReplicationReceiveRequest) error {
+	req.Token = strings.TrimSpace(req.Token)
+	req.Dataset = strings.Trim(strings.TrimSpace(req.Dataset), "/")
+	req.Base = strings.TrimSpace(req.Base)
+	if req.Token == "" || len(req.Secret) < 32 || req.Dataset == "" || strings.Contains(req.Dataset, "@") {
+		return errors.New("token, secret (32+ characters) and dataset required")
+	}
+	if strings.ContainsAny(req.Base, "@/") {
+		return errors.New("base must be a bare snapshot name")
+	}
+	replicationReceives.mu.Lock()
+	defer replicationReceives.mu.Unlock()
+	if replicationReceives.pending == nil {
+		replicationReceives.pending = map[string]pendingReceive{}
+	}
+	now := time.Now()
+	for t, p := range replicationReceives.pending {
+		if now.After(p.expires) {
+			delete(replicationReceives.pending, t)
+		}
+	}
+	replicationReceives.pending[req.Token] = pendingReceive{req: req, expires: now.Add(replicationReceiveTTL)}
+	return nil
+}
+
+// requireOperatorAuth guards the replication control endpoints with the
+// NODE_AGENT_AUTH_HEADER/NODE_AGENT_AUTH_VALUE pair the operator sends.
+// Without both set the endpoints are refused, since anyone reaching them
+// could stream a dataset to a node of their choosing.
+func requireOperatorAuth(next http.HandlerFunc) http.HandlerFunc {
+	header := strings.TrimSpace(os.Getenv("NODE_AGENT_AUTH_HEADER"))
+	value := os.Getenv("NODE_AGENT_AUTH_VALUE")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if header == "" || value == "" {
+			writeJSON(w, http.StatusForbidden, ZPoolOpResponse{OK: false, Error: "NODE_AGENT_AUTH_HEADER and NODE_AGENT_AUTH_VALUE must be set for replication"})
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(header)), []byte(value)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, ZPoolOpResponse{OK: false, Error: "unauthorized"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// claimReplicationReceive returns and spends the receive registered under
+// token if secret matches it.
+func claimReplicationReceive(token, secret string) (ZReplicationReceiveRequest, error) {
+	token = strings.TrimSpace(token)
+	replicationReceives.mu.Lock()
+	defer replicationReceives.mu.Unlock()
+	p, ok := replicationReceives.pending[token]
+	if !ok || time.Now().After(p.expires) {
+		return ZReplicationReceiveRequest{}, errors.New("no receive prepared for this token")
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(p.req.Secret)) != 1 {
+		return ZReplicationReceiveRequest{}, errors.New("invalid replication secret")
+	}
+	delete(replicationReceives.pending, token)
+	return p.req, nil
+}
+
+// receiveReplicationStream pipes stream into zfs receive. A full stream
+// creates a read-only replica; changes to it would break later increments.
+// Incremental receives do not use -F: with a -R stream it would destroy
+// every target snapshot the source no longer has, defeating the target's
+// own retention. The target is rolled back to the base explicitly instead.
+func receiveReplicationStream(ctx context.Context, req ZReplicationReceiveRequest, stream io.Reader) (string, error) {
+	args := []string{"receive", "-u"}
+	if req.Base != "" {
+		if err := rollbackReplicationTarget(ctx, req.Dataset, req.Base); err != nil {
+			return "", err
+		}
+	} else {
+		args = append(args, "-o", "readonly=on")
+	}
+	args = append(args, req.Dataset)
+	ctx, cancel := context.WithTimeout(ctx, 24*time.Hour)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "zfs", args...)
+	cmd.Stdin = stream
+	b, err := cmd.CombinedOutput()
+	out := strings.TrimSpace(string(b))
+	if err != nil {
+		return out, fmt.Errorf("zfs receive %s failed: %v %s", req.Dataset, err, out)
+	}
+	return out, nil
+}
+
+// rollbackReplicationTarget rolls ds and each descendant that has the base
+// snapshot back to it, so the incremental stream applies on top of it.
+// Snapshots newer than the base on the target are destroyed, as -F would.
+func rollbackReplicationTarget(ctx context.Context, ds, base string) error {
+	if !datasetExists(ds + "@" + base) {
+		return fmt.Errorf("base snapshot %s@%s not found on target", ds, base)
+	}
+	out, err := runCmdCombined(ctx, 60*time.Second, "zfs", "list", "-H", "-o", "name", "-t", "snapshot", "-r", ds)
+	if err != nil {
+		return fmt.Errorf("zfs list failed: %v %s", err, strings.TrimSpace(out))
+	}
+	for _, snap := range splitLines(out) {
+		if !strings.HasSuffix(snap, "@"+base) {
+			continue
+		}
+		if out, err := runCmdCombined(ctx, 10*time.Minute, "zfs", "rollback", "-r", snap); err != nil {
+			return fmt.Errorf("zfs rollback %s failed: %v %s", snap, err, strings.TrimSpace(out))
+		}
+	}
+	return nil
+}
+
+func startReplicationSend(req ZReplicationSendRequest) (ZReplicationStatus, error) {
+	token := strings.TrimSpace(req.Token)
+	snap := strings.TrimSpace(req.Snapshot)
+	base := strings.TrimSpace(req.Base)
+	if token == "" || req.Secret == "" || strings.TrimSpace(req.TargetURL) == "" {
+		return ZReplicationStatus{}, errors.New("token, secret and targetURL required")
+	}
+	at := strings.Index(snap, "@")
+	if at <= 0 || (base != "" && !strings.HasPrefix(base, snap[:at]+"@")) {
+		return ZReplicationStatus{}, errors.New("snapshot must be dataset@name and base a snapshot of the same dataset")
+	}
+	prepare := func() (*replicationSend, error) {
+		if !datasetExists(snap) {
+			return nil, fmt.Errorf("snapshot %s not found", snap)
+		}
+		if base != "" && !datasetExists(base) {
+			return nil, fmt.Errorf("base snapshot %s not found", base)
+		}
+		return &replicationSend{status: ZReplicationStatus{Token: token, State: "Running", Started: time.Now().UTC().Format(time.RFC3339)}}, nil
+	}
+	return replicationSends.start(token, prepare, func(job *replicationSend) func() {
+		err := sendReplicationStream(context.Background(), req, &job.bytes)
+		return func() {
+			job.status.State = "Complete"
+			if err != nil {
+				job.status.State = "Failed"
+				job.status.Message = err.Error()
+			}
+			job.status.Finished = time.Now().UTC().Format(time.RFC3339)
+		}
+	})
+}
+
+func replicationSendStatus(token string) (ZReplicationStatus, bool) {
+	return replicationSends.get(token)
+}
+
+// sendReplicationStream runs zfs send and posts its output to the target
+// node-agent, counting the bytes sent.
+func sendReplicationStream(ctx context.Context, req ZReplicationSendRequest, sent *atomic.Int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 24*time.Hour)
+	defer cancel()
+	args := []string{"send"}
+	if req.Raw {
+		args = append(args, "-w")
+	}
+	if req.Recursive {
+		args = append(args, "-R")
+	}
+	if base := strings.TrimSpace(req.Base); base != "" {
+		args = append(args, "-i", base)
+	}
+	args = append(args, strings.TrimSpace(req.Snapshot))
+	send := exec.CommandContext(ctx, "zfs", args...)
+	pipe, err := send.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var sendErr strings.Builder
+	send.Stderr = &sendErr
+	if err := send.Start(); err != nil {
+		return err
+	}
+	u := strings.TrimRight(strings.TrimSpace(req.TargetURL), "/") + "/v1/zfs/replication/receive"
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, &countingReader{r: pipe, n: sent})
+	if err != nil {
+		cancel()
+		_ = send.Wait()
+		return err
+	}
+	hreq.Header.Set("Content-Type", "application/octet-stream")
+	hreq.Header.Set(replicationTokenHeader, strings.TrimSpace(req.Token))
+	hreq.Header.Set("Authorization", "Bearer "+req.Secret)
+	resp, err := http.DefaultClient.Do(hreq)
+	if err != nil {
+		cancel()
+		_ = send.Wait()
+		return fmt.Errorf("stream to %s failed: %w", req.TargetURL, err)
+	}
+	defer resp.Body.Close()
+	var out ZPoolOpResponse
+	_ = json.NewDecoder(resp.Body).Decode(&out)
+	if resp.StatusCode >= 300 {
+		// The target stopped reading; do not leave zfs send blocked on the pipe.
+		cancel()
+		_ = send.Wait()
+		if out.Error == "" {
+			out.Error = resp.Status
+		}
+		return fmt.Errorf("target: %s", out.Error)
+	}
+	if err := send.Wait(); err != nil {
+		return fmt.Errorf("zfs send %s failed: %v %s", req.Snapshot, err, strings.TrimSpace(sendErr.String()))
+	}
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func getDatasetMountpoint(full string) (string, error) {
+	out, err := runCmdCombined(context.Background(), 30*time.Second, "zfs", "get", "-H", "-o", "value", "mountpoint", full)
+	if err != nil {
+		return out, fmt.Errorf("zfs get mountpoint failed: %w", err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func getDatasetPropertyValue(full string, prop string) (string, error) {
+	prop = strings.TrimSpace(prop)
+	if prop == "" {
+		return "", errors.New("property empty")
+	}
+	out, err := runCmdCombined(context.Background(), 30*time.Second, "zfs", "get", "-H", "-o", "value", prop, full)
+	if err != nil {
+		return out, fmt.Errorf("zfs get %s failed: %w", prop, err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// -----------------
+// Disk discovery
+// -----------------
+
+func refreshDiskCache() {
+	udevSettle()
+	disks := discoverDisks()
+	diskCache.mu.Lock()
+	diskCache.disks = disks
+	diskCache.updated = time.Now().UTC()
+	diskCache.mu.Unlock()
+}
+
+func getDiskCache() []Disk {
+	diskCache.mu.RLock()
+	defer diskCache.mu.RUnlock()
+	out := make([]Disk, len(diskCache.disks))
+	copy(out, diskCache.disks)
+	return out
+}
+
+func getDiskCacheStatus() DiskCacheStatus {
+	diskCache.mu.RLock()
+	defer diskCache.mu.RUnlock()
+	status := DiskCacheStatus{Count: len(diskCache.disks)}
+	if !diskCache.updated.IsZero() {
+		status.Updated = diskCache.updated.Format(time.RFC3339)
+	}
+	return status
+}
+
+func queueDiskRefresh() {
+	select {
+	case diskRefreshCh <- struct{}{}:
+	default:
+	}
+}
+
+func startDiskRefreshLoop(ctx context.Context) {
+	for {
+		select {
+		case <-diskRefreshCh:
+			time.Sleep(500 * time.Millisecond)
+			for len(diskRefreshCh) > 0 {
+				<-diskRefreshCh
+			}
+			refreshDiskCache()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func startUdevMonitor(ctx context.Context) {
+	if _, err := exec.LookPath("udevadm"); err != nil {
+		log.Printf("udevadm not found; disk refresh on udev events disabled: %v", err)
+		return
+	}
+	cmd := exec.CommandContext(ctx, "udevadm", "monitor", "--udev", "--subsystem-match=block")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		log.Printf("udev monitor stdout pipe failed: %v", err)
+		return
+	}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		log.Printf("udev monitor start failed: %v", err)
+		return
+	}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if isUdevDiskEvent(scanner.Text()) {
+			queueDiskRefresh()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("udev monitor error: %v", err)
+	}
+	_ = cmd.Wait()
+}
+
+func isUdevDiskEvent(line string) bool {
+	if !strings.HasPrefix(line, "UDEV") {
+		return false
+	}
+	return strings.Contains(line, " add ") || strings.Contains(line, " remove ") || strings.Contains(line, " change ")
+}
+
+func parseSmartTimeout(raw string) time.Duration {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 60 * time.Second
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		return 60 * time.Second
+	}
+	if n < 5 {
+		n = 5
+	}
+	if n > 300 {
+		n = 300
+	}
+	return time.Duration(n) * time.Second
+}
+
+func isFalse(raw string) bool {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "0", "false", "no":
+		return true
+	default:
+		return false
+	}
+}
+
+func resolveDiskPath(ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	if strings.HasPrefix(ref, "/dev/") {
+		if fileExists(ref) {
+			return ref
+		}
+		return ""
+	}
+	byID := "/dev/disk/by-id/" + ref
+	if fileExists(byID) {
+		return byID
+	}
+	byPath := "/dev/disk/by-path/" + ref
+	if fileExists(byPath) {
+		return byPath
+	}
+	dev := "/dev/" + ref
+	if fileExists(dev) {
+		return dev
+	}
+	return ""
+}
+
+func probeSmart(device string, timeout time.Duration, useJSON bool) SmartResponse {
+	out, parsed, err := runSmartctl(device, timeout, useJSON)
+	resp := SmartResponse{
+		OK:     out != "",
+		Device: device,
+		Output: out,
+		JSON:   parsed,
+	}
+	if err != nil && out == "" {
+		resp.OK = false
+		resp.Error = err.Error()
+	} else if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+func runSmartctl(device string, timeout time.Duration, useJSON bool) (string, map[string]any, error) {
+	if !useJSON {
+		out, err := runCmdCombined(context.Background(), timeout, "smartctl", "-a", device)
+		return out, nil, err
+	}
+	out, err := runCmdCombined(context.Background(), timeout, "smartctl", "-a", "-j", device)
+	parsed := map[string]any{}
+	trim := strings.TrimSpace(out)
+	if strings.HasPrefix(trim, "{") {
+		if err := json.Unmarshal([]byte(trim), &parsed); err == nil {
+			return out, parsed, err
+		}
+	}
+	// Fallback to text output if JSON is unavailable.
+	out2, err2 := runCmdCombined(context.Background(), timeout, "smartctl", "-a", device)
+	if out2 != "" {
+		out = out2
+		err = err2
+	}
+	return out, nil, err
+}
+
+func listNFSExports() ([]string, error) {
+	b, err := os.ReadFile(nfsExportsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	lines := strings.Split(string(b), "\n")
+	var out []string
+	for _, line := range lines {
+		trim := strings.TrimSpace(line)
+		if trim == "" || strings.HasPrefix(trim, "#") {
+			continue
+		}
+		out = append(out, trim)
+	}
+	return out, nil
+}
+
+func ensureNFSExport(path string, clients []string, options string) (string, error) {
+	if _, err := exec.LookPath("exportfs"); err != nil {
+		return "", fmt.Errorf("exportfs not found")
+	}
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return "", errors.New("path empty")
+	}
+	if len(clients) == 0 {
+		clients = []string{"*"}
+	}
+	options = strings.TrimSpace(options)
+	if options == "" {
+		options = "rw,sync,no_subtree_check"
+	}
+	entry := buildNFSExportLine(path, clients, options)
+	lines, changed := upsertNFSExportLine(path, entry)
+	if changed {
+		if err := writeNFSExports(lines); err != nil {
+			return "", err
+		}
+	}
+	out, err := runCmdCombined(context.Background(), 30*time.Second, "exportfs", "-ra")
+	if err != nil {
+		return out, fmt.Errorf("exportfs reload failed: %w", err)
+	}
+	return out, nil
+}
+
+func deleteNFSExport(path string) (string, error) {
+	if _, err := exec.LookPath("exportfs"); err != nil {
+		return "", fmt.Errorf("exportfs not found")
+	}
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return "", errors.New("path empty")
+	}
+	lines, changed := removeNFSExportLine(path)
+	if changed {
+		if err := writeNFSExports(lines); err != nil {
+			return "", err
+		}
+	}
+	out, err := runCmdCombined(context.Background(), 30*time.Second, "exportfs", "-ra")
+	if err != nil {
+		return out, fmt.Errorf("exportfs reload failed: %w", err)
+	}
+	return out, nil
+}
+
+func buildNFSExportLine(path string, clients []string, options string) string {
+	var b strings.Builder
+	b.WriteString(path)
+	for _, c := range clients {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		b.WriteString(" ")
+		b.WriteString(c)
+		b.WriteString("(")
+		b.WriteString(options)
+		b.WriteString(")")
+	}
+	return b.String()
+}
+
+func upsertNFSExportLine(path string, entry string) ([]string, bool) {
+	lines, err := readNFSExportsRaw()
+	if err != nil {
+		lines = []string{}
+	}
+	var out []string
+	changed := false
+	found := false
+	for _, line := range lines {
+		trim := strings.TrimSpace(line)
+		if trim == "" || strings.HasPrefix(trim, "#") {
+			out = append(out, line)
+			continue
+		}
+		fields := strings.Fields(trim)
+		if len(fields) > 0 && fields[0] == path {
+			out = append(out, entry)
+			found = true
+			if trim != entry {
+				changed = true
+			}
+			continue
+		}
+		out = append(out, line)
+	}
+	if !found {
+		out = append(out, entry)
+		changed = true
+	}
+	return out, changed
+}
+
+func removeNFSExportLine(path string) ([]string, bool) {
+	lines, err := readNFSExportsRaw()
+	if err != nil {
+		return []string{}, false
+	}
+	var out []string
+	changed := false
+	for _, line := range lines {
+		trim := strings.TrimSpace(line)
+		if trim == "" || strings.HasPrefix(trim, "#") {
+			out = append(out, line)
+			continue
+		}
+		fields := strings.Fields(trim)
+		if len(fields) > 0 && fields[0] == path {
+			changed = true
+			continue
+		}
+		out = append(out, line)
+	}
+	return out, changed
+}
+
+func readNFSExportsRaw() ([]string, error) {
+	b, err := os.ReadFile(nfsExportsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	return strings.Split(string(b), "\n"), nil
+}
+
+func writeNFSExports(lines []string) error {
+	if err := os.MkdirAll(filepath.Dir(nfsExportsPath), 0755); err != nil {
+		return err
+	}
+	content := strings.Join(lines, "\n")
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return os.WriteFile(nfsExportsPath, []byte(content), 0644)
 }
 
-func applyDatasetPreset(full string, mountpoint string, preset string, props map[string]string) (string, error) {
-	preset = normalizeDatasetPreset(preset, props)
-	if preset == "" {
-		return "", nil
-	}
-	acltype := strings.ToLower(getDatasetProperty(props, "acltype"))
+// LIO is driven through targetcli for changes and read back through configfs,
+// which is cheaper and has stable paths. CHAP secrets are written to configfs
+// directly so they never appear on a command line.
+const (
+	lioConfigfsPath   = "/sys/kernel/config/target"
+	iscsiDefaultPort  = "3260"
+	iscsiTargetCLITTL = 30 * time.Second
+)
 
-	switch preset {
-	case datasetPresetGeneric:
-		if acltype != "" && acltype != "posix" {
-			return "", nil
-		}
-		return ensureDatasetMounted(full, mountpoint, "0755", false)
-	case datasetPresetSMB, datasetPresetMultiprotocol:
-		if acltype != "" && acltype != "nfsv4" {
-			return "", nil
-		}
-		return ensureNfs4DefaultAcl(full, mountpoint)
-	default:
-		return "", nil
-	}
+var (
+	iscsiNameRe      = regexp.MustCompile(`^(iqn\.[0-9]{4}-[0-9]{2}\.[a-z0-9][a-z0-9.-]*(:[A-Za-z0-9.:_-]+)?|eui\.[0-9A-Fa-f]{16}|naa\.[0-9A-Fa-f]{16,32})$`)
+	iscsiBackstoreRe = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+	iscsiAddressRe   = regexp.MustCompile(`Address\s+(\S+)`)
+)
+
+func targetcli(args ...string) (string, error) {
+	return runCmdCombined(context.Background(), iscsiTargetCLITTL, "targetcli", args...)
 }
 
-func ensureNfs4DefaultAcl(dataset string, mountpoint string) (string, error) {
-	if _, err := exec.LookPath("nfs4_setfacl"); err != nil {
-		return "", fmt.Errorf("nfs4_setfacl not available: %w", err)
+func iscsiTPGPath(iqn string) string {
+	return filepath.Join(lioConfigfsPath, "iscsi", iqn, "tpgt_1")
+}
+
+func iscsiTPGCLI(iqn string) string {
+	return "/iscsi/" + iqn + "/tpg1"
+}
+
+func validateISCSITarget(req *ISCSITargetRequest) error {
+	req.IQN = strings.TrimSpace(req.IQN)
+	req.Backstore = strings.TrimSpace(req.Backstore)
+	req.Device = strings.TrimSpace(req.Device)
+	if !iscsiNameRe.MatchString(req.IQN) {
+		return fmt.Errorf("invalid iqn %q", req.IQN)
 	}
-	_, err := ensureDatasetMounted(dataset, mountpoint, "", false)
-	if err != nil {
-		return "", err
+	if !iscsiBackstoreRe.MatchString(req.Backstore) {
+		return fmt.Errorf("invalid backstore name %q", req.Backstore)
 	}
-	mp := strings.TrimSpace(mountpoint)
-	if mp == "" {
-		var err error
-		mp, err = getDatasetMountpoint(dataset)
-		if err != nil {
-			return "", err
-		}
+	if !strings.HasPrefix(req.Device, "/dev/") {
+		return fmt.Errorf("device must be a /dev path")
 	}
-	if mp == "" || mp == "none" || mp == "-" || mp == "legacy" {
-		return "", nil
+	if req.LUN < 0 || req.LUN > 255 {
+		return fmt.Errorf("lun must be between 0 and 255")
 	}
-	if acltype, err := getDatasetPropertyValue(dataset, "acltype"); err == nil {
-		acltype = strings.ToLower(strings.TrimSpace(acltype))
-		if acltype != "" && acltype != "nfsv4" {
-			return fmt.Sprintf("acltype=%s; skipping nfs4 acl", acltype), nil
+	// Without ACLs LIO would need demo mode, which lets any initiator in.
+	if len(req.Initiators) == 0 {
+		return fmt.Errorf("at least one initiator required")
+	}
+	for i, name := range req.Initiators {
+		name = strings.TrimSpace(name)
+		if !iscsiNameRe.MatchString(name) {
+			return fmt.Errorf("invalid initiator name %q", name)
 		}
+		req.Initiators[i] = name
 	}
-
-	if _, err := exec.LookPath("nfs4_getfacl"); err == nil {
-		has, out, err := hasNfs4Acl(mp)
-		if err != nil {
-			return out, err
+	if c := req.CHAP; c != nil {
+		if strings.TrimSpace(c.UserID) == "" || c.Password == "" {
+			return fmt.Errorf("chap userid and password required")
 		}
-		if has {
-			return out, nil
+		if (strings.TrimSpace(c.MutualUserID) == "") != (c.MutualPassword == "") {
+			return fmt.Errorf("mutual chap needs both userid and password")
+		}
+		for _, v := range []string{c.UserID, c.Password, c.MutualUserID, c.MutualPassword} {
+			if strings.ContainsAny(v, " \t\r\n") {
+				return fmt.Errorf("chap credentials must not contain whitespace")
+			}
 		}
 	}
-
-	aclSpec := "A::OWNER@:rwaDxtTnNcCoy,A::GROUP@:rwaDxtTnNcCoy,A::EVERYONE@:rxtncy"
-	out, err := runCmdCombined(context.Background(), 30*time.Second, "nfs4_setfacl", "-s", aclSpec, mp)
-	if err != nil {
-		lo := strings.ToLower(out)
-		if strings.Contains(lo, "operation to set acl not supported") {
-			return out, nil
+	for _, p := range req.Portals {
+		if _, err := normalizePortal(p, iscsiDefaultPort); err != nil {
+			return err
 		}
-		return out, err
 	}
-	return out, nil
+	return nil
 }
 
-func hasNfs4Acl(path string) (bool, string, error) {
-	out, err := runCmdCombined(context.Background(), 15*time.Second, "nfs4_getfacl", path)
+// normalizePortal turns "ip", "ip:port" or "[v6]:port" into "ip:port"
+// form, which is also the LIO np directory name ("0.0.0.0:3260", "[::]:3260").
+// An empty portal binds all IPv4 addresses.
+func normalizePortal(p string, defaultPort string) (string, error) {
+	p = strings.TrimSpace(p)
+	if p == "" {
+		return net.JoinHostPort("0.0.0.0", defaultPort), nil
+	}
+	host, port, err := net.SplitHostPort(p)
 	if err != nil {
-		return false, out, err
+		host, port = strings.Trim(p, "[]"), defaultPort
 	}
-	for _, line := range strings.Split(out, "\n") {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "A:") || strings.HasPrefix(line, "A::") {
-			return true, out, nil
-		}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("invalid portal address %q", p)
 	}
-	return false, out, nil
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return "", fmt.Errorf("invalid portal port %q", p)
+	}
+	return net.JoinHostPort(host, port), nil
 }
 
-func ensureDatasetMounted(full string, mountpoint string, mode string, recursive bool) (string, error) {
-	full = strings.TrimSpace(full)
-	if full == "" {
-		return "", errors.New("dataset empty")
+func listDirNames(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
 	}
-
-	if mp := strings.TrimSpace(mountpoint); mp != "" {
-		_, _ = runCmdCombined(context.Background(), 30*time.Second, "zfs", "set", "mountpoint="+mp, full)
+	var out []string
+	for _, e := range entries {
+		if e.IsDir() {
+			out = append(out, e.Name())
+		}
 	}
+	sort.Strings(out)
+	return out
+}
 
-	out, err := runCmdCombined(context.Background(), 30*time.Second, "zfs", "get", "-H", "-o", "value", "mounted", full)
+func readConfigfs(path string) string {
+	b, err := os.ReadFile(path)
 	if err != nil {
-		return out, fmt.Errorf("zfs get mounted failed: %w", err)
+		return ""
 	}
-	if strings.TrimSpace(out) == "yes" {
-		return ensureMountPerms(full, mountpoint, mode, recursive, out)
+	return strings.TrimSpace(string(b))
+}
+
+func writeConfigfs(path string, value string) error {
+	return os.WriteFile(path, []byte(value), 0o600)
+}
+
+// iscsiBackstorePath finds a block backstore by name under any iblock HBA.
+func iscsiBackstorePath(name string) string {
+	matches, _ := filepath.Glob(filepath.Join(lioConfigfsPath, "core", "iblock_*", name))
+	if len(matches) == 0 {
+		return ""
 	}
+	return matches[0]
+}
 
-	out, err = runCmdCombined(context.Background(), 60*time.Second, "zfs", "mount", full)
+func iscsiPortals(iqn string) []string {
+	return listDirNames(filepath.Join(iscsiTPGPath(iqn), "np"))
+}
+
+// iscsiLUNBackstore returns the backstore name a TPG LUN links to.
+func iscsiLUNBackstore(lunDir string) string {
+	entries, err := os.ReadDir(lunDir)
 	if err != nil {
-		lo := strings.ToLower(out)
-		if strings.Contains(lo, "already mounted") {
-			return ensureMountPerms(full, mountpoint, mode, recursive, out)
+		return ""
+	}
+	for _, e := range entries {
+		if e.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+		if dest, err := os.Readlink(filepath.Join(lunDir, e.Name())); err == nil {
+			return filepath.Base(dest)
 		}
-		return out, err
 	}
-	return ensureMountPerms(full, mountpoint, mode, recursive, out)
+	return ""
 }
 
-func ensureMountPerms(dataset string, mountpoint string, mode string, recursive bool, out string) (string, error) {
-	mode = strings.TrimSpace(mode)
-	if mode == "" {
-		return out, nil
+func ensureISCSITarget(req ISCSITargetRequest) ([]string, string, error) {
+	if _, err := exec.LookPath("targetcli"); err != nil {
+		return nil, "", fmt.Errorf("targetcli not found")
 	}
-	mp := strings.TrimSpace(mountpoint)
-	if mp == "" {
-		var err error
-		mp, err = getDatasetMountpoint(dataset)
+	if !fileExists(req.Device) {
+		return nil, "", fmt.Errorf("device %s not found", req.Device)
+	}
+	var changed []string
+	var outputs []string
+	run := func(what string, args ...string) error {
+		out, err := targetcli(args...)
+		if strings.TrimSpace(out) != "" {
+			outputs = append(outputs, strings.TrimSpace(out))
+		}
 		if err != nil {
-			return out, err
+			return fmt.Errorf("%s: %w", what, err)
 		}
+		changed = append(changed, what)
+		return nil
 	}
-	if mp == "" || mp == "none" || mp == "-" || mp == "legacy" {
-		return out, fmt.Errorf("mountpoint not available for %s", dataset)
-	}
-	args := []string{}
-	if recursive {
-		args = append(args, "-R")
+	output := func() string { return strings.Join(outputs, "\n") }
+
+	if bs := iscsiBackstorePath(req.Backstore); bs != "" {
+		if dev := readConfigfs(filepath.Join(bs, "udev_path")); dev != "" && dev != req.Device {
+			return changed, output(), fmt.Errorf("backstore %s is bound to %s", req.Backstore, dev)
+		}
+	} else if err := run("backstore "+req.Backstore, "/backstores/block", "create", "name="+req.Backstore, "dev="+req.Device); err != nil {
+		return changed, output(), err
 	}
-	args = append(args, mode, mp)
-	out2, err := runCmdCombined(context.Background(), 60*time.Second, "chmod", args...)
-	if err != nil {
-		return out2, err
+
+	tpg := iscsiTPGPath(req.IQN)
+	cli := iscsiTPGCLI(req.IQN)
+	if !fileExists(tpg) {
+		if err := run("target "+req.IQN, "/iscsi", "create", req.IQN); err != nil {
+			return changed, output(), err
+		}
 	}
-	return out2, nil
-}
 
-func getDatasetMountpoint(full string) (string, error) {
-	out, err := runCmdCombined(context.Background(), 30*time.Second, "zfs", "get", "-H", "-o", "value", "mountpoint", full)
-	if err != nil {
-		return out, fmt.Errorf("zfs get mountpoint failed: %w", err)
+	lunDir := filepath.Join(tpg, "lun", fmt.Sprintf("lun_%d", req.LUN))
+	if fileExists(lunDir) {
+		if cur := iscsiLUNBackstore(lunDir); cur != "" && cur != req.Backstore {
+			return changed, output(), fmt.Errorf("lun %d is bound to backstore %s", req.LUN, cur)
+		}
+	} else if err := run(fmt.Sprintf("lun %d", req.LUN), cli+"/luns", "create", "/backstores/block/"+req.Backstore, fmt.Sprintf("lun=%d", req.LUN), "add_mapped_luns=false"); err != nil {
+		return changed, output(), err
+	}
+	// A changed LUN number leaves the backstore mapped under the old one too;
+	// deleting that TPG LUN also drops its mappings in the ACLs.
+	for _, name := range listDirNames(filepath.Join(tpg, "lun")) {
+		n, err := strconv.Atoi(strings.TrimPrefix(name, "lun_"))
+		if err != nil || n == req.LUN || iscsiLUNBackstore(filepath.Join(tpg, "lun", name)) != req.Backstore {
+			continue
+		}
+		if err := run(fmt.Sprintf("remove lun %d", n), cli+"/luns", "delete", strconv.Itoa(n)); err != nil {
+			return changed, output(), err
+		}
 	}
-	return strings.TrimSpace(out), nil
-}
 
-func getDatasetPropertyValue(full string, prop string) (string, error) {
-	prop = strings.TrimSpace(prop)
-	if prop == "" {
-		return "", errors.New("property empty")
+	want := map[string]bool{}
+	for _, p := range req.Portals {
+		np, _ := normalizePortal(p, iscsiDefaultPort)
+		want[np] = true
 	}
-	out, err := runCmdCombined(context.Background(), 30*time.Second, "zfs", "get", "-H", "-o", "value", prop, full)
-	if err != nil {
-		return out, fmt.Errorf("zfs get %s failed: %w", prop, err)
+	if len(want) == 0 {
+		np, _ := normalizePortal("", iscsiDefaultPort)
+		want[np] = true
 	}
-	return strings.TrimSpace(out), nil
-}
-
-// -----------------
-// Disk discovery
-// -----------------
-
-func refreshDiskCache() {
-	udevSettle()
-	disks := discoverDisks()
-	diskCache.mu.Lock()
-	diskCache.disks = disks
-	diskCache.updated = time.Now().UTC()
-	diskCache.mu.Unlock()
-}
-
-func getDiskCache() []Disk {
-	diskCache.mu.RLock()
-	defer diskCache.mu.RUnlock()
-	out := make([]Disk, len(diskCache.disks))
-	copy(out, diskCache.disks)
-	return out
-}
-
-func getDiskCacheStatus() DiskCacheStatus {
-	diskCache.mu.RLock()
-	defer diskCache.mu.RUnlock()
-	status := DiskCacheStatus{Count: len(diskCache.disks)}
-	if !diskCache.updated.IsZero() {
-		status.Updated = diskCache.updated.Format(time.RFC3339)
+	for _, np := range iscsiPortals(req.IQN) {
+		if want[np] {
+			delete(want, np)
+			continue
+		}
+		host, port, _ := net.SplitHostPort(np)
+		if err := run("remove portal "+np, cli+"/portals", "delete", host, port); err != nil {
+			return changed, output(), err
+		}
 	}
-	return status
-}
-
-func queueDiskRefresh() {
-	select {
-	case diskRefreshCh <- struct{}{}:
-	default:
+	for _, np := range sortedKeys(want) {
+		host, port, _ := net.SplitHostPort(np)
+		if err := run("portal "+np, cli+"/portals", "create", host, port); err != nil {
+			return changed, output(), err
+		}
 	}
-}
 
-func startDiskRefreshLoop(ctx context.Context) {
-	for {
-		select {
-		case <-diskRefreshCh:
-			time.Sleep(500 * time.Millisecond)
-			for len(diskRefreshCh) > 0 {
-				<-diskRefreshCh
+	wantACL := map[string]bool{}
+	for _, name := range req.Initiators {
+		wantACL[name] = true
+	}
+	aclRoot := filepath.Join(tpg, "acls")
+	for _, name := range listDirNames(aclRoot) {
+		if !wantACL[name] {
+			if err := run("remove acl "+name, cli+"/acls", "delete", name); err != nil {
+				return changed, output(), err
 			}
-			refreshDiskCache()
-		case <-ctx.Done():
-			return
 		}
 	}
-}
-
-func startUdevMonitor(ctx context.Context) {
-	if _, err := exec.LookPath("udevadm"); err != nil {
-		log.Printf("udevadm not found; disk refresh on udev events disabled: %v", err)
-		return
+	wp := "0"
+	if req.ReadOnly {
+		wp = "1"
 	}
-	cmd := exec.CommandContext(ctx, "udevadm", "monitor", "--udev", "--subsystem-match=block")
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Printf("udev monitor stdout pipe failed: %v", err)
-		return
+	for _, name := range sortedKeys(wantACL) {
+		aclDir := filepath.Join(aclRoot, name)
+		if !fileExists(aclDir) {
+			if err := run("acl "+name, cli+"/acls", "create", name, "add_mapped_luns=false"); err != nil {
+				return changed, output(), err
+			}
+		}
+		mapped := filepath.Join(aclDir, fmt.Sprintf("lun_%d", req.LUN))
+		if fileExists(mapped) && readConfigfs(filepath.Join(mapped, "write_protect")) != wp {
+			if err := run("remap lun for "+name, cli+"/acls/"+name, "delete", strconv.Itoa(req.LUN)); err != nil {
+				return changed, output(), err
+			}
+		}
+		if !fileExists(mapped) {
+			if err := run("map lun for "+name, cli+"/acls/"+name, "create", "mapped_lun="+strconv.Itoa(req.LUN), "tpg_lun_or_backstore="+strconv.Itoa(req.LUN), "write_protect="+strconv.FormatBool(req.ReadOnly)); err != nil {
+				return changed, output(), err
+			}
+		}
+		// Credentials are cleared again when CHAP is turned off.
+		creds := map[string]string{}
+		if c := req.CHAP; c != nil {
+			creds = map[string]string{
+				"userid":       c.UserID,
+				"password":     c.Password,
+				"userid_mut":   c.MutualUserID,
+				"password_mut": c.MutualPassword,
+			}
+		}
+		updated := false
+		for _, key := range []string{"userid", "password", "userid_mut", "password_mut"} {
+			file := filepath.Join(aclDir, "auth", key)
+			if readConfigfs(file) == creds[key] {
+				continue
+			}
+			if err := writeConfigfs(file, creds[key]); err != nil {
+				return changed, output(), fmt.Errorf("chap for %s: %w", name, err)
+			}
+			updated = true
+		}
+		if updated {
+			changed = append(changed, "chap for "+name)
+		}
 	}
-	cmd.Stderr = os.Stderr
-	if err := cmd.Start(); err != nil {
-		log.Printf("udev monitor start failed: %v", err)
-		return
+
+	attrs := map[string]string{"generate_node_acls": "0", "authentication": "0"}
+	if req.CHAP != nil {
+		attrs["authentication"] = "1"
 	}
-	scanner := bufio.NewScanner(stdout)
-	for scanner.Scan() {
-		if isUdevDiskEvent(scanner.Text()) {
-			queueDiskRefresh()
+	for _, key := range sortedKeys(attrs) {
+		if readConfigfs(filepath.Join(tpg, "attrib", key)) == attrs[key] {
+			continue
+		}
+		if err := run(key+"="+attrs[key], cli, "set", "attribute", key+"="+attrs[key]); err != nil {
+			return changed, output(), err
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		log.Printf("udev monitor error: %v", err)
+	if readConfigfs(filepath.Join(tpg, "enable")) != "1" {
+		if err := run("enable", cli, "enable"); err != nil {
+			return changed, output(), err
+		}
 	}
-	_ = cmd.Wait()
-}
 
-func isUdevDiskEvent(line string) bool {
-	if !strings.HasPrefix(line, "UDEV") {
-		return false
+	if len(changed) > 0 {
+		if _, err := targetcli("saveconfig"); err != nil {
+			return changed, output(), fmt.Errorf("saveconfig: %w", err)
+		}
 	}
-	return strings.Contains(line, " add ") || strings.Contains(line, " remove ") || strings.Contains(line, " change ")
+	return changed, output(), nil
 }
 
-func parseSmartTimeout(raw string) time.Duration {
-	raw = strings.TrimSpace(raw)
-	if raw == "" {
-		return 60 * time.Second
-	}
-	n, err := strconv.Atoi(raw)
-	if err != nil || n <= 0 {
-		return 60 * time.Second
+// deleteISCSITarget removes the target and then the backstore; either may
+// already be gone.
+func deleteISCSITarget(iqn string, backstore string) ([]string, string, error) {
+	if _, err := exec.LookPath("targetcli"); err != nil {
+		return nil, "", fmt.Errorf("targetcli not found")
+	}
+	var changed []string
+	var outputs []string
+	if iqn != "" && fileExists(filepath.Join(lioConfigfsPath, "iscsi", iqn)) {
+		out, err := targetcli("/iscsi", "delete", iqn)
+		outputs = append(outputs, strings.TrimSpace(out))
+		if err != nil {
+			return changed, strings.Join(outputs, "\n"), fmt.Errorf("delete target: %w", err)
+		}
+		changed = append(changed, "target "+iqn)
 	}
-	if n < 5 {
-		n = 5
+	if backstore != "" && iscsiBackstorePath(backstore) != "" {
+		out, err := targetcli("/backstores/block", "delete", backstore)
+		outputs = append(outputs, strings.TrimSpace(out))
+		if err != nil {
+			return changed, strings.Join(outputs, "\n"), fmt.Errorf("delete backstore: %w", err)
+		}
+		changed = append(changed, "backstore "+backstore)
 	}
-	if n > 300 {
-		n = 300
+	if len(changed) > 0 {
+		if _, err := targetcli("saveconfig"); err != nil {
+			return changed, strings.Join(outputs, "\n"), fmt.Errorf("saveconfig: %w", err)
+		}
 	}
-	return time.Duration(n) * time.Second
+	return changed, strings.Join(outputs, "\n"), nil
 }
 
-func isFalse(raw string) bool {
-	switch strings.ToLower(strings.TrimSpace(raw)) {
-	case "0", "false", "no":
-		return true
-	default:
-		return false
+// iscsiSessions reports logged-in initiators from each ACL's info file.
+func iscsiSessions(iqn string) ([]ISCSISession, error) {
+	aclRoot := filepath.Join(iscsiTPGPath(iqn), "acls")
+	var out []ISCSISession
+	for _, name := range listDirNames(aclRoot) {
+		info, err := os.ReadFile(filepath.Join(aclRoot, name, "info"))
+		if err != nil {
+			return nil, err
+		}
+		text := string(info)
+		if strings.Contains(text, "No active iSCSI Session") {
+			continue
+		}
+		sess := ISCSISession{Initiator: name}
+		for _, m := range iscsiAddressRe.FindAllStringSubmatch(text, -1) {
+			sess.Addresses = append(sess.Addresses, m[1])
+		}
+		out = append(out, sess)
 	}
+	return out, nil
 }
 
-func resolveDiskPath(ref string) string {
-	ref = strings.TrimSpace(ref)
-	if ref == "" {
-		return ""
-	}
-	if strings.HasPrefix(ref, "/dev/") {
-		if fileExists(ref) {
-			return ref
-		}
-		return ""
+// nvmet is configured by creating directories, writing attribute files and
+// linking items in configfs; connected controllers are read from debugfs.
+const (
+	nvmetConfigfsPath = "/sys/kernel/config/nvmet"
+	nvmetDebugfsPath  = "/sys/kernel/debug/nvmet"
+	nvmeofDefaultPort = "4420"
+)
+
+var nvmeNQNRe = regexp.MustCompile(`^nqn\.[0-9]{4}-[0-9]{2}\.[a-z0-9][a-z0-9.-]*:[A-Za-z0-9.:_-]{1,200}$`)
+
+func nvmetSubsysPath(nqn string) string {
+	return filepath.Join(nvmetConfigfsPath, "subsystems", nqn)
+}
+
+func ensureNVMetModules(tcp bool) error {
+	mods := []string{}
+	if !fileExists(nvmetConfigfsPath) {
+		mods = append(mods, "nvmet")
 	}
-	byID := "/dev/disk/by-id/" + ref
-	if fileExists(byID) {
-		return byID
+	if tcp && !fileExists("/sys/module/nvmet_tcp") {
+		mods = append(mods, "nvmet-tcp")
 	}
-	byPath := "/dev/disk/by-path/" + ref
-	if fileExists(byPath) {
-		return byPath
+	for _, m := range mods {
+		if out, err := runCmdCombined(context.Background(), 30*time.Second, "modprobe", m); err != nil {
+			return fmt.Errorf("modprobe %s: %s", m, strings.TrimSpace(out))
+		}
 	}
-	dev := "/dev/" + ref
-	if fileExists(dev) {
-		return dev
+	if !fileExists(nvmetConfigfsPath) {
+		return fmt.Errorf("%s not available; is configfs mounted?", nvmetConfigfsPath)
 	}
-	return ""
+	return nil
 }
 
-func probeSmart(device string, timeout time.Duration, useJSON bool) SmartResponse {
-	out, parsed, err := runSmartctl(device, timeout, useJSON)
-	resp := SmartResponse{
-		OK:     out != "",
-		Device: device,
-		Output: out,
-		JSON:   parsed,
+// listEntryNames lists directory entries including symlinks, which is how
+// nvmet represents allowed hosts and port bindings.
+func listEntryNames(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
 	}
-	if err != nil && out == "" {
-		resp.OK = false
-		resp.Error = err.Error()
-	} else if err != nil {
-		resp.Error = err.Error()
+	out := make([]string, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, e.Name())
 	}
-	return resp
+	sort.Strings(out)
+	return out
 }
 
-func runSmartctl(device string, timeout time.Duration, useJSON bool) (string, map[string]any, error) {
-	if !useJSON {
-		out, err := runCmdCombined(context.Background(), timeout, "smartctl", "-a", device)
-		return out, nil, err
+func linkExists(p string) bool {
+	_, err := os.Lstat(p)
+	return err == nil
+}
+
+func ensureNVMeoFSubsystem(nqn string) ([]string, error) {
+	if err := ensureNVMetModules(false); err != nil {
+		return nil, err
 	}
-	out, err := runCmdCombined(context.Background(), timeout, "smartctl", "-a", "-j", device)
-	parsed := map[string]any{}
-	trim := strings.TrimSpace(out)
-	if strings.HasPrefix(trim, "{") {
-		if err := json.Unmarshal([]byte(trim), &parsed); err == nil {
-			return out, parsed, err
+	var changed []string
+	sub := nvmetSubsysPath(nqn)
+	if !fileExists(sub) {
+		if err := os.Mkdir(sub, 0o755); err != nil {
+			return nil, fmt.Errorf("create subsystem: %w", err)
 		}
+		changed = append(changed, "subsystem "+nqn)
 	}
-	// Fallback to text output if JSON is unavailable.
-	out2, err2 := runCmdCombined(context.Background(), timeout, "smartctl", "-a", device)
-	if out2 != "" {
-		out = out2
-		err = err2
+	// Access is always limited to allowed_hosts.
+	if readConfigfs(filepath.Join(sub, "attr_allow_any_host")) != "0" {
+		if err := writeConfigfs(filepath.Join(sub, "attr_allow_any_host"), "0"); err != nil {
+			return changed, fmt.Errorf("restrict hosts: %w", err)
+		}
+		changed = append(changed, "attr_allow_any_host=0")
 	}
-	return out, nil, err
+	return changed, nil
 }
 
-func listNFSExports() ([]string, error) {
-	b, err := os.ReadFile(nfsExportsPath)
+// deleteNVMeoFSubsystem unbinds the subsystem from its ports, removes its
+// namespaces and host links, then the subsystem itself. Ports and hosts
+// left unused are removed as well.
+func deleteNVMeoFSubsystem(nqn string) ([]string, error) {
+	sub := nvmetSubsysPath(nqn)
+	if !fileExists(sub) {
+		return nil, nil
+	}
+	changed, err := applyNVMeoFPorts(nqn, nil)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return []string{}, nil
+		return changed, err
+	}
+	for _, name := range listEntryNames(filepath.Join(sub, "allowed_hosts")) {
+		if err := os.Remove(filepath.Join(sub, "allowed_hosts", name)); err != nil {
+			return changed, fmt.Errorf("remove host %s: %w", name, err)
 		}
-		return nil, err
 	}
-	lines := strings.Split(string(b), "\n")
-	var out []string
-	for _, line := range lines {
-		trim := strings.TrimSpace(line)
-		if trim == "" || strings.HasPrefix(trim, "#") {
-			continue
+	for _, name := range listDirNames(filepath.Join(sub, "namespaces")) {
+		nsid, _ := strconv.Atoi(name)
+		c, err := deleteNVMeoFNamespace(nqn, nsid)
+		changed = append(changed, c...)
+		if err != nil {
+			return changed, err
 		}
-		out = append(out, trim)
 	}
-	return out, nil
+	if err := os.Remove(sub); err != nil {
+		return changed, fmt.Errorf("remove subsystem: %w", err)
+	}
+	changed = append(changed, "subsystem "+nqn)
+	pruneNVMeoFHosts()
+	return changed, nil
 }
 
-func ensureNFSExport(path string, clients []string, options string) (string, error) {
-	if _, err := exec.LookPath("exportfs"); err != nil {
-		return "", fmt.Errorf("exportfs not found")
+func ensureNVMeoFNamespace(nqn string, nsid int, device string) ([]string, error) {
+	if nsid == 0 {
+		nsid = 1
 	}
-	path = strings.TrimSpace(path)
-	if path == "" {
-		return "", errors.New("path empty")
+	if nsid < 1 {
+		return nil, fmt.Errorf("nsid must be positive")
 	}
-	if len(clients) == 0 {
-		clients = []string{"*"}
+	device = strings.TrimSpace(device)
+	if !strings.HasPrefix(device, "/dev/") {
+		return nil, fmt.Errorf("device must be a /dev path")
 	}
-	options = strings.TrimSpace(options)
-	if options == "" {
-		options = "rw,sync,no_subtree_check"
+	if !fileExists(device) {
+		return nil, fmt.Errorf("device %s not found", device)
 	}
-	entry := buildNFSExportLine(path, clients, options)
-	lines, changed := upsertNFSExportLine(path, entry)
-	if changed {
-		if err := writeNFSExports(lines); err != nil {
-			return "", err
+	sub := nvmetSubsysPath(nqn)
+	if !fileExists(sub) {
+		return nil, fmt.Errorf("subsystem %s not found", nqn)
+	}
+	var changed []string
+	ns := filepath.Join(sub, "namespaces", strconv.Itoa(nsid))
+	if !fileExists(ns) {
+		if err := os.Mkdir(ns, 0o755); err != nil {
+			return nil, fmt.Errorf("create namespace %d: %w", nsid, err)
+		}
+		changed = append(changed, fmt.Sprintf("namespace %d", nsid))
+	}
+	enable := filepath.Join(ns, "enable")
+	if readConfigfs(filepath.Join(ns, "device_path")) != device {
+		// device_path can only change while the namespace is disabled.
+		if readConfigfs(enable) == "1" {
+			if err := writeConfigfs(enable, "0"); err != nil {
+				return changed, fmt.Errorf("disable namespace %d: %w", nsid, err)
+			}
 		}
+		if err := writeConfigfs(filepath.Join(ns, "device_path"), device); err != nil {
+			return changed, fmt.Errorf("set device for namespace %d: %w", nsid, err)
+		}
+		changed = append(changed, fmt.Sprintf("namespace %d device %s", nsid, device))
 	}
-	out, err := runCmdCombined(context.Background(), 30*time.Second, "exportfs", "-ra")
-	if err != nil {
-		return out, fmt.Errorf("exportfs reload failed: %w", err)
+	if readConfigfs(enable) != "1" {
+		if err := writeConfigfs(enable, "1"); err != nil {
+			return changed, fmt.Errorf("enable namespace %d: %w", nsid, err)
+		}
+		changed = append(changed, fmt.Sprintf("namespace %d enabled", nsid))
 	}
-	return out, nil
+	return changed, nil
 }
 
-func deleteNFSExport(path string) (string, error) {
-	if _, err := exec.LookPath("exportfs"); err != nil {
-		return "", fmt.Errorf("exportfs not found")
+func deleteNVMeoFNamespace(nqn string, nsid int) ([]string, error) {
+	if nsid == 0 {
+		nsid = 1
 	}
-	path = strings.TrimSpace(path)
-	if path == "" {
-		return "", errors.New("path empty")
+	ns := filepath.Join(nvmetSubsysPath(nqn), "namespaces", strconv.Itoa(nsid))
+	if !fileExists(ns) {
+		return nil, nil
 	}
-	lines, changed := removeNFSExportLine(path)
-	if changed {
-		if err := writeNFSExports(lines); err != nil {
-			return "", err
+	if readConfigfs(filepath.Join(ns, "enable")) == "1" {
+		if err := writeConfigfs(filepath.Join(ns, "enable"), "0"); err != nil {
+			return nil, fmt.Errorf("disable namespace %d: %w", nsid, err)
 		}
 	}
-	out, err := runCmdCombined(context.Background(), 30*time.Second, "exportfs", "-ra")
-	if err != nil {
-		return out, fmt.Errorf("exportfs reload failed: %w", err)
+	if err := os.Remove(ns); err != nil {
+		return nil, fmt.Errorf("remove namespace %d: %w", nsid, err)
 	}
-	return out, nil
+	return []string{fmt.Sprintf("namespace %d removed", nsid)}, nil
 }
 
-func buildNFSExportLine(path string, clients []string, options string) string {
-	var b strings.Builder
-	b.WriteString(path)
-	for _, c := range clients {
-		c = strings.TrimSpace(c)
-		if c == "" {
+// nvmetTCPPorts maps "addr:port" of every TCP port to its port id.
+func nvmetTCPPorts() map[string]string {
+	out := map[string]string{}
+	root := filepath.Join(nvmetConfigfsPath, "ports")
+	for _, id := range listDirNames(root) {
+		dir := filepath.Join(root, id)
+		if readConfigfs(filepath.Join(dir, "addr_trtype")) != "tcp" {
 			continue
 		}
-		b.WriteString(" ")
-		b.WriteString(c)
-		b.WriteString("(")
-		b.WriteString(options)
-		b.WriteString(")")
+		key := net.JoinHostPort(readConfigfs(filepath.Join(dir, "addr_traddr")), readConfigfs(filepath.Join(dir, "addr_trsvcid")))
+		out[key] = id
 	}
-	return b.String()
+	return out
 }
 
-func upsertNFSExportLine(path string, entry string) ([]string, bool) {
-	lines, err := readNFSExportsRaw()
-	if err != nil {
-		lines = []string{}
+// applyNVMeoFPorts binds the subsystem to exactly the given TCP portals,
+// creating ports as needed and removing ports that end up unused.
+func applyNVMeoFPorts(nqn string, portals []string) ([]string, error) {
+	sub := nvmetSubsysPath(nqn)
+	want := map[string]bool{}
+	for _, p := range portals {
+		key, err := normalizePortal(p, nvmeofDefaultPort)
+		if err != nil {
+			return nil, err
+		}
+		want[key] = true
 	}
-	var out []string
-	changed := false
-	found := false
-	for _, line := range lines {
-		trim := strings.TrimSpace(line)
-		if trim == "" || strings.HasPrefix(trim, "#") {
-			out = append(out, line)
+	if len(want) > 0 {
+		if err := ensureNVMetModules(true); err != nil {
+			return nil, err
+		}
+		if !fileExists(sub) {
+			return nil, fmt.Errorf("subsystem %s not found", nqn)
+		}
+	}
+	var changed []string
+	root := filepath.Join(nvmetConfigfsPath, "ports")
+	ports := nvmetTCPPorts()
+	for _, key := range sortedKeys(ports) {
+		id := ports[key]
+		link := filepath.Join(root, id, "subsystems", nqn)
+		if want[key] || !linkExists(link) {
 			continue
 		}
-		fields := strings.Fields(trim)
-		if len(fields) > 0 && fields[0] == path {
-			out = append(out, entry)
-			found = true
-			if trim != entry {
-				changed = true
+		if err := os.Remove(link); err != nil {
+			return changed, fmt.Errorf("unbind port %s: %w", key, err)
+		}
+		changed = append(changed, "unbind "+key)
+		if len(listEntryNames(filepath.Join(root, id, "subsystems"))) == 0 {
+			if err := os.Remove(filepath.Join(root, id)); err == nil {
+				changed = append(changed, "remove port "+key)
 			}
-			continue
 		}
-		out = append(out, line)
 	}
-	if !found {
-		out = append(out, entry)
-		changed = true
+	next := 1
+	for _, id := range listDirNames(root) {
+		if n, err := strconv.Atoi(id); err == nil && n >= next {
+			next = n + 1
+		}
 	}
-	return out, changed
+	for _, key := range sortedKeys(want) {
+		id, ok := ports[key]
+		if !ok {
+			host, port, _ := net.SplitHostPort(key)
+			family := "ipv4"
+			if strings.Contains(host, ":") {
+				family = "ipv6"
+			}
+			id = strconv.Itoa(next)
+			next++
+			dir := filepath.Join(root, id)
+			if err := os.Mkdir(dir, 0o755); err != nil {
+				return changed, fmt.Errorf("create port %s: %w", key, err)
+			}
+			attrs := [][2]string{{"addr_trtype", "tcp"}, {"addr_adrfam", family}, {"addr_traddr", host}, {"addr_trsvcid", port}}
+			for _, kv := range attrs {
+				if err := writeConfigfs(filepath.Join(dir, kv[0]), kv[1]); err != nil {
+					_ = os.Remove(dir)
+					return changed, fmt.Errorf("port %s %s: %w", key, kv[0], err)
+				}
+			}
+			changed = append(changed, "port "+key)
+		}
+		link := filepath.Join(root, id, "subsystems", nqn)
+		if !linkExists(link) {
+			if err := os.Symlink(sub, link); err != nil {
+				return changed, fmt.Errorf("bind port %s: %w", key, err)
+			}
+			changed = append(changed, "bind "+key)
+		}
+	}
+	return changed, nil
 }
 
-func removeNFSExportLine(path string) ([]string, bool) {
-	lines, err := readNFSExportsRaw()
-	if err != nil {
-		return []string{}, false
+// applyNVMeoFHosts sets the subsystem's allowed_hosts to exactly hosts.
+func applyNVMeoFHosts(nqn string, hosts []string) ([]string, error) {
+	sub := nvmetSubsysPath(nqn)
+	if !fileExists(sub) {
+		return nil, fmt.Errorf("subsystem %s not found", nqn)
+	}
+	want := map[string]bool{}
+	for _, h := range hosts {
+		h = strings.TrimSpace(h)
+		if !nvmeNQNRe.MatchString(h) {
+			return nil, fmt.Errorf("invalid host nqn %q", h)
+		}
+		want[h] = true
 	}
-	var out []string
-	changed := false
-	for _, line := range lines {
-		trim := strings.TrimSpace(line)
-		if trim == "" || strings.HasPrefix(trim, "#") {
-			out = append(out, line)
+	var changed []string
+	allowed := filepath.Join(sub, "allowed_hosts")
+	for _, name := range listEntryNames(allowed) {
+		if want[name] {
 			continue
 		}
-		fields := strings.Fields(trim)
-		if len(fields) > 0 && fields[0] == path {
-			changed = true
-			continue
+		if err := os.Remove(filepath.Join(allowed, name)); err != nil {
+			return changed, fmt.Errorf("remove host %s: %w", name, err)
 		}
-		out = append(out, line)
+		changed = append(changed, "remove host "+name)
 	}
-	return out, changed
+	for _, name := range sortedKeys(want) {
+		hostDir := filepath.Join(nvmetConfigfsPath, "hosts", name)
+		if !fileExists(hostDir) {
+			if err := os.Mkdir(hostDir, 0o755); err != nil {
+				return changed, fmt.Errorf("create host %s: %w", name, err)
+			}
+		}
+		if !linkExists(filepath.Join(allowed, name)) {
+			if err := os.Symlink(hostDir, filepath.Join(allowed, name)); err != nil {
+				return changed, fmt.Errorf("allow host %s: %w", name, err)
+			}
+			changed = append(changed, "allow host "+name)
+		}
+	}
+	pruneNVMeoFHosts()
+	return changed, nil
 }
 
-func readNFSExportsRaw() ([]string, error) {
-	b, err := os.ReadFile(nfsExportsPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return []string{}, nil
+// pruneNVMeoFHosts removes host entries no subsystem refers to any more.
+func pruneNVMeoFHosts() {
+	used := map[string]bool{}
+	for _, sub := range listDirNames(filepath.Join(nvmetConfigfsPath, "subsystems")) {
+		for _, h := range listEntryNames(filepath.Join(nvmetConfigfsPath, "subsystems", sub, "allowed_hosts")) {
+			used[h] = true
+		}
+	}
+	for _, h := range listDirNames(filepath.Join(nvmetConfigfsPath, "hosts")) {
+		if !used[h] {
+			_ = os.Remove(filepath.Join(nvmetConfigfsPath, "hosts", h))
 		}
-		return nil, err
 	}
-	return strings.Split(string(b), "\n"), nil
 }
 
-func writeNFSExports(lines []string) error {
-	if err := os.MkdirAll(filepath.Dir(nfsExportsPath), 0755); err != nil {
-		return err
+func nvmeofStatus(nqn string) NVMeoFResponse {
+	sub := nvmetSubsysPath(nqn)
+	resp := NVMeoFResponse{OK: true, NQN: nqn}
+	for _, name := range listDirNames(filepath.Join(sub, "namespaces")) {
+		nsid, _ := strconv.Atoi(name)
+		ns := filepath.Join(sub, "namespaces", name)
+		resp.Namespaces = append(resp.Namespaces, NVMeoFNamespace{
+			NSID:    nsid,
+			Device:  readConfigfs(filepath.Join(ns, "device_path")),
+			Enabled: readConfigfs(filepath.Join(ns, "enable")) == "1",
+		})
 	}
-	content := strings.Join(lines, "\n")
-	if !strings.HasSuffix(content, "\n") {
-		content += "\n"
+	ports := nvmetTCPPorts()
+	for _, key := range sortedKeys(ports) {
+		if linkExists(filepath.Join(nvmetConfigfsPath, "ports", ports[key], "subsystems", nqn)) {
+			resp.Portals = append(resp.Portals, key)
+		}
 	}
-	return os.WriteFile(nfsExportsPath, []byte(content), 0644)
+	resp.Hosts = listEntryNames(filepath.Join(sub, "allowed_hosts"))
+	// Controllers show up under debugfs on kernels that support it.
+	if fileExists(nvmetDebugfsPath) {
+		resp.ControllersKnown = true
+		dir := filepath.Join(nvmetDebugfsPath, nqn)
+		for _, name := range listDirNames(dir) {
+			if !strings.HasPrefix(name, "ctrl") {
+				continue
+			}
+			ctrl := filepath.Join(dir, name)
+			resp.Controllers = append(resp.Controllers, NVMeoFController{
+				ID:      strings.TrimPrefix(name, "ctrl"),
+				HostNQN: readConfigfs(filepath.Join(ctrl, "hostnqn")),
+				Address: readConfigfs(filepath.Join(ctrl, "host_traddr")),
+				State:   readConfigfs(filepath.Join(ctrl, "state")),
+			})
+		}
+	}
+	return resp
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
 }
 
 func applyNFSSSSDConfig(conf string, caBundle string) (string, error) {
@@ -10,10 +10,13 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	nasv1 "mnemosyne/api/v1alpha1"
+	"mnemosyne/internal/strftime"
 
 	corev1 "k8s.io/api/core/v1"
 	apiErrors "k8s.io/apimachinery/pkg/api/errors"
@@ -72,6 +75,90 @@ type nodeAgentZPoolStatus struct {
 	Usage *nasv1.ZPoolUsage `json:"usage,omitempty"`
 }
 
+type nodeAgentQuotaUsage struct {
+	ID    int64 `json:"id"`
+	Used  int64 `json:"used"`
+	Quota int64 `json:"quota,omitempty"`
+}
+
+type nodeAgentQuotaResponse struct {
+	OK     bool                  `json:"ok"`
+	Users  []nodeAgentQuotaUsage `json:"users,omitempty"`
+	Groups []nodeAgentQuotaUsage `json:"groups,omitempty"`
+	Error  string                `json:"error,omitempty"`
+}
+
+type quotaUsage struct {
+	Name       string `json:"name,omitempty"`
+	ID         int64  `json:"id"`
+	UsedBytes  int64  `json:"usedBytes"`
+	QuotaBytes int64  `json:"quotaBytes,omitempty"`
+}
+
+type datasetQuotasResponse struct {
+	Dataset string       `json:"dataset"`
+	Users   []quotaUsage `json:"users"`
+	Groups  []quotaUsage `json:"groups"`
+}
+
+type nodeAgentSnapshot struct {
+	Name            string   `json:"name"`
+	Dataset         string   `json:"dataset"`
+	Creation        int64    `json:"creation"`
+	UsedBytes       int64    `json:"usedBytes"`
+	ReferencedBytes int64    `json:"referencedBytes"`
+	WrittenBytes    int64    `json:"writtenBytes"`
+	Holds           []string `json:"holds,omitempty"`
+	Clones          []string `json:"clones,omitempty"`
+}
+
+type nodeAgentSnapshotsResponse struct {
+	OK    bool                `json:"ok"`
+	Items []nodeAgentSnapshot `json:"items,omitempty"`
+	Error string              `json:"error,omitempty"`
+}
+
+type datasetSnapshot struct {
+	Name            string   `json:"name"`
+	Dataset         string   `json:"dataset"`
+	Snapshot        string   `json:"snapshot"`
+	Creation        string   `json:"creation"`
+	UsedBytes       int64    `json:"usedBytes"`
+	ReferencedBytes int64    `json:"referencedBytes"`
+	WrittenBytes    int64    `json:"writtenBytes"`
+	Holds           []string `json:"holds,omitempty"`
+	Clones          []string `json:"clones,omitempty"`
+	// Schedule is the ZSnapshotSchedule whose naming produced the snapshot.
+	Schedule string `json:"schedule,omitempty"`
+}
+
+type datasetSnapshotsResponse struct {
+	Dataset        string            `json:"dataset"`
+	Count          int               `json:"count"`
+	TotalUsedBytes int64             `json:"totalUsedBytes"`
+	Snapshots      []datasetSnapshot `json:"snapshots"`
+}
+
+type snapshotDiffEntry struct {
+	Change       string `json:"change,omitempty"`
+	FileType     string `json:"fileType,omitempty"`
+	Path         string `json:"path,omitempty"`
+	RelativePath string `json:"relativePath,omitempty"`
+	NewPath      string `json:"newPath,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+type snapshotDiffResponse struct {
+	Dataset string              `json:"dataset"`
+	From    string              `json:"from"`
+	To      string              `json:"to"`
+	Offset  int                 `json:"offset"`
+	Limit   int                 `json:"limit"`
+	Entries []snapshotDiffEntry `json:"entries"`
+	// NextOffset is set when more entries match; pass it as offset.
+	NextOffset int `json:"nextOffset,omitempty"`
+}
+
 type diskInventoryResponse struct {
 	Disks   []nodeAgentDisk `json:"disks"`
 	Updated string          `json:"updated,omitempty"`
@@ -116,8 +203,18 @@ func NewServer(c client.Client, namespace, webRoot string, logger *log.Logger) *
 	mux.HandleFunc("/v1/zpools/", s.handleZPool)
 	mux.HandleFunc("/v1/zdatasets", s.handleZDatasets)
 	mux.HandleFunc("/v1/zdatasets/", s.handleZDataset)
+	mux.HandleFunc("/v1/zvolumes", s.handleZVolumes)
+	mux.HandleFunc("/v1/zvolumes/", s.handleZVolume)
 	mux.HandleFunc("/v1/zsnapshots", s.handleZSnapshots)
 	mux.HandleFunc("/v1/zsnapshots/", s.handleZSnapshot)
+	mux.HandleFunc("/v1/zdatasetsnapshots", s.handleZDatasetSnapshots)
+	mux.HandleFunc("/v1/zdatasetsnapshots/", s.handleZDatasetSnapshot)
+	mux.HandleFunc("/v1/zsnapshotfilerestores", s.handleZSnapshotFileRestores)
+	mux.HandleFunc("/v1/zsnapshotfilerestores/", s.handleZSnapshotFileRestore)
+	mux.HandleFunc("/v1/zsnapshotholds", s.handleZSnapshotHolds)
+	mux.HandleFunc("/v1/zsnapshotholds/", s.handleZSnapshotHold)
+	mux.HandleFunc("/v1/zreplications", s.handleZReplications)
+	mux.HandleFunc("/v1/zreplications/", s.handleZReplication)
 	mux.HandleFunc("/v1/nasshares", s.handleNASShares)
 	mux.HandleFunc("/v1/nasshares/", s.handleNASShare)
 	mux.HandleFunc("/v1/nasdirectories", s.handleNASDirectories)
@@ -291,6 +388,22 @@ func (s *Server) handleZDatasets(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleZDataset(w http.ResponseWriter, r *http.Request) {
+	if name, ok := strings.CutSuffix(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/zdatasets/"), "/"), "/quotas"); ok {
+		s.handleZDatasetQuotas(w, r, name)
+		return
+	}
+	if parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/zdatasets/"), "/"), "/"); len(parts) == 4 && parts[1] == "snapshots" {
+		s.handleZDatasetSnapshotFiles(w, r, parts[0], parts[2], parts[3])
+		return
+	}
+	if name, ok := strings.CutSuffix(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/zdatasets/"), "/"), "/diff"); ok {
+		s.handleZDatasetDiff(w, r, name)
+		return
+	}
+	if name, ok := strings.CutSuffix(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/zdatasets/"), "/"), "/snapshots"); ok {
+		s.handleZDatasetSnapshotListing(w, r, name)
+		return
+	}
 	s.handleGetOrDelete(w, r, "/v1/zdatasets/", func(ctx context.Context, name string) (any, error) {
 		var obj nasv1.ZDataset
 		if err := s.client.Get(ctx, namespacedName(s.namespace, name), &obj); err != nil {
@@ -303,6 +416,459 @@ func (s *Server) handleZDataset(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleZDatasetQuotas reports live user/group space usage for a dataset,
+// labelling IDs with the NASUser/NASGroup names from the dataset status.
+func (s *Server) handleZDatasetQuotas(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "name required")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var obj nasv1.ZDataset
+	if err := s.client.Get(ctx, namespacedName(s.namespace, name), &obj); err != nil {
+		if apiErrors.IsNotFound(err) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if s.nodeAgentURL == "" {
+		writeError(w, http.StatusServiceUnavailable, "node-agent url not configured")
+		return
+	}
+	var usage nodeAgentQuotaResponse
+	if err := s.fetchNodeAgentJSON(ctx, "/v1/zfs/dataset/quotas?dataset="+url.QueryEscape(obj.Spec.DatasetName), &usage); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, datasetQuotasResponse{
+		Dataset: obj.Spec.DatasetName,
+		Users:   labelQuotaUsage(usage.Users, obj.Status.UserQuotas),
+		Groups:  labelQuotaUsage(usage.Groups, obj.Status.GroupQuotas),
+	})
+}
+
+// handleZDatasetSnapshotListing lists the ZFS snapshots of a dataset with space
+// accounting and the schedule that owns each one.
+//
+// Query parameters: recursive=true includes child datasets; sort is one of
+// creation (default), name, used, referenced or written; order is asc or
+// desc (default desc for sizes, asc otherwise); schedule, prefix and
+// held=true|false filter; limit caps the result after sorting.
+func (s *Server) handleZDatasetSnapshotListing(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "name required")
+		return
+	}
+	q := r.URL.Query()
+	sortBy := strings.TrimSpace(q.Get("sort"))
+	if sortBy == "" {
+		sortBy = "creation"
+	}
+	less, ok := snapshotSorts[sortBy]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "sort must be one of creation, name, used, referenced, written")
+		return
+	}
+	desc := sortBy == "used" || sortBy == "referenced" || sortBy == "written"
+	switch strings.ToLower(strings.TrimSpace(q.Get("order"))) {
+	case "":
+	case "asc":
+		desc = false
+	case "desc":
+		desc = true
+	default:
+		writeError(w, http.StatusBadRequest, "order must be asc or desc")
+		return
+	}
+	limit := 0
+	if v := strings.TrimSpace(q.Get("limit")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a non-negative integer")
+			return
+		}
+		limit = n
+	}
+	var held *bool
+	if v := strings.TrimSpace(q.Get("held")); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "held must be true or false")
+			return
+		}
+		held = &b
+	}
+	recursive, _ := strconv.ParseBool(q.Get("recursive"))
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	var obj nasv1.ZDataset
+	if err := s.client.Get(ctx, namespacedName(s.namespace, name), &obj); err != nil {
+		if apiErrors.IsNotFound(err) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if s.nodeAgentURL == "" {
+		writeError(w, http.StatusServiceUnavailable, "node-agent url not configured")
+		return
+	}
+	var schedules nasv1.ZSnapshotScheduleList
+	if err := s.client.List(ctx, &schedules, client.InNamespace(s.namespace)); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	path := "/v1/zfs/snapshot/details?dataset=" + url.QueryEscape(obj.Spec.DatasetName)
+	if recursive {
+		path += "&recursive=true"
+	}
+	var snaps nodeAgentSnapshotsResponse
+	if err := s.fetchNodeAgentJSON(ctx, path, &snaps); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	scheduleFilter := strings.TrimSpace(q.Get("schedule"))
+	prefix := strings.TrimSpace(q.Get("prefix"))
+	resp := datasetSnapshotsResponse{Dataset: obj.Spec.DatasetName, Snapshots: []datasetSnapshot{}}
+	for _, sn := range snaps.Items {
+		short := sn.Name[strings.Index(sn.Name, "@")+1:]
+		item := datasetSnapshot{
+			Name:            sn.Name,
+			Dataset:         sn.Dataset,
+			Snapshot:        short,
+			Creation:        time.Unix(sn.Creation, 0).UTC().Format(time.RFC3339),
+			UsedBytes:       sn.UsedBytes,
+			ReferencedBytes: sn.ReferencedBytes,
+			WrittenBytes:    sn.WrittenBytes,
+			Holds:           sn.Holds,
+			Clones:          sn.Clones,
+			Schedule:        snapshotSchedule(schedules.Items, sn.Dataset, short),
+		}
+		if scheduleFilter != "" && item.Schedule != scheduleFilter {
+			continue
+		}
+		if prefix != "" && !strings.HasPrefix(short, prefix) {
+			continue
+		}
+		if held != nil && *held != (len(item.Holds) > 0) {
+			continue
+		}
+		resp.TotalUsedBytes += item.UsedBytes
+		resp.Snapshots = append(resp.Snapshots, item)
+	}
+	sort.SliceStable(resp.Snapshots, func(i, j int) bool {
+		if desc {
+			return less(resp.Snapshots[j], resp.Snapshots[i])
+		}
+		return less(resp.Snapshots[i], resp.Snapshots[j])
+	})
+	if limit > 0 && len(resp.Snapshots) > limit {
+		resp.Snapshots = resp.Snapshots[:limit]
+	}
+	resp.Count = len(resp.Snapshots)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleZDatasetDiff proxies the node-agent's zfs diff stream. from is a
+// snapshot of the dataset (full or the part after "@"); to is another
+// snapshot or empty for the live dataset. prefix keeps entries whose path,
+// absolute or relative to the mountpoint, starts with it; offset and limit
+// page through the matches.
+func (s *Server) handleZDatasetDiff(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	offset, limit := 0, defaultDiffLimit
+	for key, dst := range map[string]*int{"offset": &offset, "limit": &limit} {
+		if v := strings.TrimSpace(q.Get(key)); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, key+" must be a non-negative integer")
+				return
+			}
+			*dst = n
+		}
+	}
+	if limit == 0 || limit > maxDiffLimit {
+		limit = maxDiffLimit
+	}
+	if name == "" || strings.TrimSpace(q.Get("from")) == "" {
+		writeError(w, http.StatusBadRequest, "name and from required")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	defer cancel()
+	var obj nasv1.ZDataset
+	if err := s.client.Get(ctx, namespacedName(s.namespace, name), &obj); err != nil {
+		if apiErrors.IsNotFound(err) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if s.nodeAgentURL == "" {
+		writeError(w, http.StatusServiceUnavailable, "node-agent url not configured")
+		return
+	}
+	ds := strings.Trim(strings.TrimSpace(obj.Spec.DatasetName), "/")
+	from, err := ownSnapshot(ds, q.Get("from"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	to := ""
+	if v := strings.TrimSpace(q.Get("to")); v != "" {
+		if to, err = ownSnapshot(ds, v); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	prefix := strings.TrimSpace(q.Get("prefix"))
+
+	path := "/v1/zfs/snapshot/diff?from=" + url.QueryEscape(from)
+	if to != "" {
+		path += "&to=" + url.QueryEscape(to)
+	}
+	resp := snapshotDiffResponse{Dataset: ds, From: from, To: to, Offset: offset, Limit: limit, Entries: []snapshotDiffEntry{}}
+	matched := 0
+	var streamErr string
+	err = s.streamNodeAgentNDJSON(ctx, path, func(raw json.RawMessage) bool {
+		var e snapshotDiffEntry
+		if json.Unmarshal(raw, &e) != nil {
+			return true
+		}
+		if e.Error != "" {
+			streamErr = e.Error
+			return false
+		}
+		if prefix != "" && !strings.HasPrefix(e.Path, prefix) && !strings.HasPrefix(e.RelativePath, strings.TrimPrefix(prefix, "./")) {
+			return true
+		}
+		matched++
+		if matched <= offset {
+			return true
+		}
+		if len(resp.Entries) == limit {
+			// One more match exists; stop reading so zfs diff is killed.
+			resp.NextOffset = offset + limit
+			return false
+		}
+		resp.Entries = append(resp.Entries, e)
+		return true
+	})
+	if err != nil {
+		writeError(w, nodeAgentErrorStatus(err), err.Error())
+		return
+	}
+	if streamErr != "" {
+		writeError(w, http.StatusBadGateway, streamErr)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleZDatasetSnapshotFiles browses a snapshot through the node-agent:
+// /v1/zdatasets/{name}/snapshots/{snapshot}/{files|stat|download}?path=...
+// where path is relative to the dataset root.
+func (s *Server) handleZDatasetSnapshotFiles(w http.ResponseWriter, r *http.Request, name, snap, action string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	endpoint, ok := map[string]string{
+		"files":    "/v1/zfs/snapshot/files/list",
+		"stat":     "/v1/zfs/snapshot/files/stat",
+		"download": "/v1/zfs/snapshot/files/download",
+	}[action]
+	if !ok || name == "" || snap == "" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	var obj nasv1.ZDataset
+	err := s.client.Get(ctx, namespacedName(s.namespace, name), &obj)
+	cancel()
+	if err != nil {
+		if apiErrors.IsNotFound(err) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if s.nodeAgentURL == "" {
+		writeError(w, http.StatusServiceUnavailable, "node-agent url not configured")
+		return
+	}
+	ds := strings.Trim(strings.TrimSpace(obj.Spec.DatasetName), "/")
+	full, err := ownSnapshot(ds, snap)
+	if err == nil && full == ds {
+		err = errors.New("snapshot required")
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := url.Values{}
+	q.Set("snapshot", full)
+	q.Set("path", r.URL.Query().Get("path"))
+	path := endpoint + "?" + q.Encode()
+
+	if action == "download" {
+		if err := s.proxyNodeAgentDownload(r.Context(), w, path); err != nil {
+			writeError(w, http.StatusBadGateway, err.Error())
+		}
+		return
+	}
+	ctx, cancel = context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+	var out json.RawMessage
+	if err := s.fetchNodeAgentJSON(ctx, path, &out); err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+const (
+	defaultDiffLimit = 500
+	maxDiffLimit     = 5000
+)
+
+// snapshotOf qualifies a bare snapshot name with the dataset.
+func snapshotOf(ds, snap string) string {
+	snap = strings.TrimSpace(snap)
+	if strings.Contains(snap, "@") || snap == ds {
+		return snap
+	}
+	return ds + "@" + snap
+}
+
+// ownSnapshot qualifies snap like snapshotOf and rejects snapshots of any
+// dataset other than ds, so a ZDataset cannot be used to read another
+// dataset's data.
+func ownSnapshot(ds, snap string) (string, error) {
+	full := snapshotOf(ds, snap)
+	if full == ds {
+		return full, nil
+	}
+	dataset, name, _ := strings.Cut(full, "@")
+	if dataset != ds || name == "" || strings.ContainsAny(name, "@/") {
+		return "", errors.New("snapshot " + strconv.Quote(snap) + " does not belong to dataset " + ds)
+	}
+	return full, nil
+}
+
+var snapshotSorts = map[string]func(a, b datasetSnapshot) bool{
+	"creation":   func(a, b datasetSnapshot) bool { return a.Creation < b.Creation },
+	"name":       func(a, b datasetSnapshot) bool { return a.Name < b.Name },
+	"used":       func(a, b datasetSnapshot) bool { return a.UsedBytes < b.UsedBytes },
+	"referenced": func(a, b datasetSnapshot) bool { return a.ReferencedBytes < b.ReferencedBytes },
+	"written":    func(a, b datasetSnapshot) bool { return a.WrittenBytes < b.WrittenBytes },
+}
+
+// snapshotSchedule returns the schedule whose dataset, prefix and format
+// match the snapshot. A schedule on the dataset itself wins over a
+// recursive one on a parent.
+func snapshotSchedule(schedules []nasv1.ZSnapshotSchedule, dataset, snap string) string {
+	owner, ownerDepth := "", -1
+	for _, sc := range schedules {
+		ds := strings.Trim(strings.TrimSpace(sc.Spec.DatasetName), "/")
+		if dataset != ds && !(sc.Spec.Recursive && strings.HasPrefix(dataset, ds+"/")) {
+			continue
+		}
+		prefix := strings.TrimSpace(sc.Spec.NamePrefix)
+		if prefix == "" {
+			prefix = nasv1.DefaultSnapshotNamePrefix
+		}
+		format := strings.TrimSpace(sc.Spec.Format)
+		if format == "" {
+			format = nasv1.DefaultSnapshotFormat
+		}
+		suffix, ok := strings.CutPrefix(snap, prefix+"-")
+		if !ok {
+			continue
+		}
+		if _, err := strftime.Parse(format, suffix, time.UTC); err != nil {
+			continue
+		}
+		if depth := strings.Count(ds, "/"); depth > ownerDepth {
+			owner, ownerDepth = sc.Name, depth
+		}
+	}
+	return owner
+}
+
+func labelQuotaUsage(usage []nodeAgentQuotaUsage, known []nasv1.ZDatasetQuotaStatus) []quotaUsage {
+	names := make(map[int64]string, len(known))
+	for _, q := range known {
+		names[q.ID] = q.Name
+	}
+	out := make([]quotaUsage, 0, len(usage))
+	for _, u := range usage {
+		out = append(out, quotaUsage{
+			Name:       names[u.ID],
+			ID:         u.ID,
+			UsedBytes:  u.Used,
+			QuotaBytes: u.Quota,
+		})
+	}
+	return out
+}
+
+func (s *Server) handleZVolumes(w http.ResponseWriter, r *http.Request) {
+	handleListOrCreate(s, w, r, func(ctx context.Context, ns string) (any, error) {
+		var list nasv1.ZVolumeList
+		if err := s.client.List(ctx, &list, client.InNamespace(ns)); err != nil {
+			return nil, err
+		}
+		return list.Items, nil
+	}, func(ctx context.Context, req createRequest[nasv1.ZVolumeSpec]) (any, error) {
+		obj := nasv1.ZVolume{
+			TypeMeta: metav1.TypeMeta{APIVersion: "nas.io/v1alpha1", Kind: "ZVolume"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      req.Name,
+				Namespace: nsOrDefault(req.Namespace, s.namespace),
+			},
+			Spec: req.Spec,
+		}
+		return obj, upsertResource(ctx, s.client, &obj)
+	})
+}
+
+func (s *Server) handleZVolume(w http.ResponseWriter, r *http.Request) {
+	s.handleGetOrDelete(w, r, "/v1/zvolumes/", func(ctx context.Context, name string) (any, error) {
+		var obj nasv1.ZVolume
+		if err := s.client.Get(ctx, namespacedName(s.namespace, name), &obj); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	}, func(ctx context.Context, name string) error {
+		obj := &nasv1.ZVolume{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: s.namespace}}
+		return s.client.Delete(ctx, obj)
+	})
+}
+
 func (s *Server) handleZSnapshots(w http.ResponseWriter, r *http.Request) {
 	handleListOrCreate(s, w, r, func(ctx context.Context, ns string) (any, error) {
 		var list nasv1.ZSnapshotList
@@ -336,6 +902,138 @@ func (s *Server) handleZSnapshot(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (s *Server) handleZDatasetSnapshots(w http.ResponseWriter, r *http.Request) {
+	handleListOrCreate(s, w, r, func(ctx context.Context, ns string) (any, error) {
+		var list nasv1.ZDatasetSnapshotList
+		if err := s.client.List(ctx, &list, client.InNamespace(ns)); err != nil {
+			return nil, err
+		}
+		return list.Items, nil
+	}, func(ctx context.Context, req createRequest[nasv1.ZDatasetSnapshotSpec]) (any, error) {
+		obj := nasv1.ZDatasetSnapshot{
+			TypeMeta: metav1.TypeMeta{APIVersion: "nas.io/v1alpha1", Kind: "ZDatasetSnapshot"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      req.Name,
+				Namespace: nsOrDefault(req.Namespace, s.namespace),
+			},
+			Spec: req.Spec,
+		}
+		return obj, upsertResource(ctx, s.client, &obj)
+	})
+}
+
+func (s *Server) handleZDatasetSnapshot(w http.ResponseWriter, r *http.Request) {
+	s.handleGetOrDelete(w, r, "/v1/zdatasetsnapshots/", func(ctx context.Context, name string) (any, error) {
+		var obj nasv1.ZDatasetSnapshot
+		if err := s.client.Get(ctx, namespacedName(s.namespace, name), &obj); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	}, func(ctx context.Context, name string) error {
+		obj := &nasv1.ZDatasetSnapshot{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: s.namespace}}
+		return s.client.Delete(ctx, obj)
+	})
+}
+
+func (s *Server) handleZSnapshotFileRestores(w http.ResponseWriter, r *http.Request) {
+	handleListOrCreate(s, w, r, func(ctx context.Context, ns string) (any, error) {
+		var list nasv1.ZSnapshotFileRestoreList
+		if err := s.client.List(ctx, &list, client.InNamespace(ns)); err != nil {
+			return nil, err
+		}
+		return list.Items, nil
+	}, func(ctx context.Context, req createRequest[nasv1.ZSnapshotFileRestoreSpec]) (any, error) {
+		obj := nasv1.ZSnapshotFileRestore{
+			TypeMeta: metav1.TypeMeta{APIVersion: "nas.io/v1alpha1", Kind: "ZSnapshotFileRestore"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      req.Name,
+				Namespace: nsOrDefault(req.Namespace, s.namespace),
+			},
+			Spec: req.Spec,
+		}
+		return obj, upsertResource(ctx, s.client, &obj)
+	})
+}
+
+func (s *Server) handleZSnapshotFileRestore(w http.ResponseWriter, r *http.Request) {
+	s.handleGetOrDelete(w, r, "/v1/zsnapshotfilerestores/", func(ctx context.Context, name string) (any, error) {
+		var obj nasv1.ZSnapshotFileRestore
+		if err := s.client.Get(ctx, namespacedName(s.namespace, name), &obj); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	}, func(ctx context.Context, name string) error {
+		obj := &nasv1.ZSnapshotFileRestore{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: s.namespace}}
+		return s.client.Delete(ctx, obj)
+	})
+}
+
+func (s *Server) handleZSnapshotHolds(w http.ResponseWriter, r *http.Request) {
+	handleListOrCreate(s, w, r, func(ctx context.Context, ns string) (any, error) {
+		var list nasv1.ZSnapshotHoldList
+		if err := s.client.List(ctx, &list, client.InNamespace(ns)); err != nil {
+			return nil, err
+		}
+		return list.Items, nil
+	}, func(ctx context.Context, req createRequest[nasv1.ZSnapshotHoldSpec]) (any, error) {
+		obj := nasv1.ZSnapshotHold{
+			TypeMeta: metav1.TypeMeta{APIVersion: "nas.io/v1alpha1", Kind: "ZSnapshotHold"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      req.Name,
+				Namespace: nsOrDefault(req.Namespace, s.namespace),
+			},
+			Spec: req.Spec,
+		}
+		return obj, upsertResource(ctx, s.client, &obj)
+	})
+}
+
+func (s *Server) handleZSnapshotHold(w http.ResponseWriter, r *http.Request) {
+	s.handleGetOrDelete(w, r, "/v1/zsnapshotholds/", func(ctx context.Context, name string) (any, error) {
+		var obj nasv1.ZSnapshotHold
+		if err := s.client.Get(ctx, namespacedName(s.namespace, name), &obj); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	}, func(ctx context.Context, name string) error {
+		obj := &nasv1.ZSnapshotHold{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: s.namespace}}
+		return s.client.Delete(ctx, obj)
+	})
+}
+
+func (s *Server) handleZReplications(w http.ResponseWriter, r *http.Request) {
+	handleListOrCreate(s, w, r, func(ctx context.Context, ns string) (any, error) {
+		var list nasv1.ZReplicationList
+		if err := s.client.List(ctx, &list, client.InNamespace(ns)); err != nil {
+			return nil, err
+		}
+		return list.Items, nil
+	}, func(ctx context.Context, req createRequest[nasv1.ZReplicationSpec]) (any, error) {
+		obj := nasv1.ZReplication{
+			TypeMeta: metav1.TypeMeta{APIVersion: "nas.io/v1alpha1", Kind: "ZReplication"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      req.Name,
+				Namespace: nsOrDefault(req.Namespace, s.namespace),
+			},
+			Spec: req.Spec,
+		}
+		return obj, upsertResource(ctx, s.client, &obj)
+	})
+}
+
+func (s *Server) handleZReplication(w http.ResponseWriter, r *http.Request) {
+	s.handleGetOrDelete(w, r, "/v1/zreplications/", func(ctx context.Context, name string) (any, error) {
+		var obj nasv1.ZReplication
+		if err := s.client.Get(ctx, namespacedName(s.namespace, name), &obj); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	}, func(ctx context.Context, name string) error {
+		obj := &nasv1.ZReplication{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: s.namespace}}
+		return s.client.Delete(ctx, obj)
+	})
+}
+
 func (s *Server) handleNASShares(w http.ResponseWriter, r *http.Request) {
 	handleListOrCreate(s, w, r, func(ctx context.Context, ns string) (any, error) {
 		var list nasv1.NASShareList
@@ -629,16 +1327,98 @@ func (s *Server) fetchNodeAgentJSON(ctx context.Context, path string, out any) e
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		body, _ := io.ReadAll(resp.Body)
-		msg := strings.TrimSpace(string(body))
-		if msg == "" {
-			msg = resp.Status
-		}
-		return errors.New(msg)
+		return newNodeAgentError(resp)
 	}
 	return json.NewDecoder(resp.Body).Decode(out)
 }
 
+// nodeAgentError is a non-2xx node-agent response.
+type nodeAgentError struct {
+	status int
+	msg    string
+}
+
+func (e *nodeAgentError) Error() string { return e.msg }
+
+func newNodeAgentError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	msg := strings.TrimSpace(string(body))
+	if msg == "" {
+		msg = resp.Status
+	}
+	return &nodeAgentError{status: resp.StatusCode, msg: msg}
+}
+
+// nodeAgentErrorStatus passes a node-agent 4xx through, since it means the
+// request was at fault; anything else means the node-agent is unavailable.
+func nodeAgentErrorStatus(err error) int {
+	var na *nodeAgentError
+	if errors.As(err, &na) && na.status >= 400 && na.status < 500 {
+		return na.status
+	}
+	return http.StatusServiceUnavailable
+}
+
+// streamNodeAgentNDJSON calls fn for each line of a newline-delimited JSON
+// response until fn returns false or the stream ends. Streams outlive the
+// client's short timeout, so only ctx bounds them.
+func (s *Server) streamNodeAgentNDJSON(ctx context.Context, path string, fn func(json.RawMessage) bool) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.nodeAgentURL+path, nil)
+	if err != nil {
+		return err
+	}
+	hc := *s.httpClient
+	hc.Timeout = 0
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newNodeAgentError(resp)
+	}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if !fn(raw) {
+			return nil
+		}
+	}
+}
+
+// proxyNodeAgentDownload streams a node-agent file download to w. The
+// returned error is only non-nil if nothing was written yet.
+func (s *Server) proxyNodeAgentDownload(ctx context.Context, w http.ResponseWriter, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.nodeAgentURL+path, nil)
+	if err != nil {
+		return err
+	}
+	hc := *s.httpClient
+	hc.Timeout = 0
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newNodeAgentError(resp)
+	}
+	for _, h := range []string{"Content-Type", "Content-Disposition", "Content-Length", "Last-Modified"} {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, resp.Body)
+	return nil
+}
+
 func sanitizeFilePath(root, p string) string {
 	clean := filepath.Clean("/" + p)
 	return filepath.Join(root, strings.TrimPrefix(clean, "/"))
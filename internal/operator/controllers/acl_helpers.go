@@ -0,0 +1,283 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	nasv1 "mnemosyne/api/v1alpha1"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type aclEntry struct {
+	Type      string   `json:"type,omitempty"`
+	Principal string   `json:"principal"`
+	Group     bool     `json:"group,omitempty"`
+	Perms     string   `json:"perms"`
+	Flags     []string `json:"flags,omitempty"`
+	Default   bool     `json:"default,omitempty"`
+}
+
+type aclPath struct {
+	Path string     `json:"path,omitempty"`
+	ACEs []aclEntry `json:"aces"`
+}
+
+type aclResetStatus struct {
+	Token   string `json:"token"`
+	State   string `json:"state"`
+	Message string `json:"message,omitempty"`
+}
+
+type aclResponse struct {
+	OK      bool            `json:"ok"`
+	Changed []string        `json:"changed,omitempty"`
+	Reset   *aclResetStatus `json:"reset,omitempty"`
+}
+
+// resolveACLEntry maps NASUser/NASGroup names to numeric ids; directory
+// principals and special principals are passed through.
+func resolveACLEntry(ctx context.Context, c client.Client, ns string, aclType string, e nasv1.ZDatasetACLEntry) (aclEntry, error) {
+	out := aclEntry{
+		Type:    strings.TrimSpace(e.Type),
+		Perms:   strings.TrimSpace(e.Permissions),
+		Flags:   e.Flags,
+		Default: e.Default,
+	}
+	if err := validateACLEntry(aclType, e); err != nil {
+		return out, err
+	}
+	set := 0
+	if name := strings.TrimSpace(e.User); name != "" {
+		set++
+		var u nasv1.NASUser
+		if err := c.Get(ctx, client.ObjectKey{Namespace: ns, Name: name}, &u); err != nil {
+			return out, fmt.Errorf("nasuser %s not found: %w", name, err)
+		}
+		if u.Spec.UID <= 0 {
+			return out, fmt.Errorf("nasuser %s has no uid", name)
+		}
+		out.Principal = strconv.FormatInt(u.Spec.UID, 10)
+	}
+	if name := strings.TrimSpace(e.Group); name != "" {
+		set++
+		var g nasv1.NASGroup
+		if err := c.Get(ctx, client.ObjectKey{Namespace: ns, Name: name}, &g); err != nil {
+			return out, fmt.Errorf("nasgroup %s not found: %w", name, err)
+		}
+		if g.Spec.GID <= 0 {
+			return out, fmt.Errorf("nasgroup %s has no gid", name)
+		}
+		out.Principal = strconv.FormatInt(g.Spec.GID, 10)
+		out.Group = true
+	}
+	if p := strings.TrimSpace(e.Principal); p != "" {
+		set++
+		out.Principal = p
+		out.Group = e.PrincipalIsGroup
+	}
+	if sp := strings.ToLower(strings.TrimSpace(e.Special)); sp != "" {
+		set++
+		switch sp {
+		case "owner@", "group@", "everyone@", "mask@":
+			out.Principal = strings.ToUpper(sp)
+		default:
+			return out, fmt.Errorf("unsupported special principal %q", e.Special)
+		}
+	}
+	if set != 1 {
+		return out, fmt.Errorf("acl entry needs exactly one of user, group, principal or special")
+	}
+	if out.Perms == "" {
+		return out, fmt.Errorf("acl entry for %s has no permissions", out.Principal)
+	}
+	return out, nil
+}
+
+// validateACLEntry keeps NFSv4-only features off POSIX datasets and the reverse.
+func validateACLEntry(aclType string, e nasv1.ZDatasetACLEntry) error {
+	special := strings.ToLower(strings.TrimSpace(e.Special))
+	switch aclType {
+	case "posix":
+		if t := strings.ToLower(strings.TrimSpace(e.Type)); t != "" && t != "allow" {
+			return fmt.Errorf("%s entries require acl type nfsv4", t)
+		}
+		if len(e.Flags) > 0 {
+			return fmt.Errorf("inheritance flags require acl type nfsv4; use default entries")
+		}
+	default:
+		if e.Default {
+			return fmt.Errorf("default entries require acl type posix; use inheritance flags")
+		}
+		if special == "mask@" {
+			return fmt.Errorf("mask@ requires acl type posix")
+		}
+	}
+	return nil
+}
+
+// datasetACLType picks nfsv4 or posix from spec.acl.type, the acltype
+// property, or the resolved preset, and rejects mismatches.
+func datasetACLType(obj *nasv1.ZDataset) (string, error) {
+	fromProps := ""
+	switch strings.ToLower(datasetProperty(obj.Spec.Properties, "acltype")) {
+	case "nfsv4":
+		fromProps = "nfsv4"
+	case "posix", "posixacl":
+		fromProps = "posix"
+	}
+	explicit := ""
+	if obj.Spec.ACL != nil {
+		switch t := strings.ToLower(strings.TrimSpace(obj.Spec.ACL.Type)); t {
+		case "":
+		case "nfsv4", "nfs4":
+			explicit = "nfsv4"
+		case "posix", "posixacl":
+			explicit = "posix"
+		default:
+			return "", fmt.Errorf("unsupported acl type %q", obj.Spec.ACL.Type)
+		}
+	}
+	if explicit != "" && fromProps != "" && explicit != fromProps {
+		return "", fmt.Errorf("acl type %s does not match acltype=%s", explicit, fromProps)
+	}
+	if explicit != "" {
+		return explicit, nil
+	}
+	if fromProps != "" {
+		return fromProps, nil
+	}
+	if obj.Status.Preset != nil {
+		switch obj.Status.Preset.ACL {
+		case "generic":
+			return "posix", nil
+		case "smb", "multiprotocol":
+			return "nfsv4", nil
+		}
+	}
+	return "", fmt.Errorf("acl.type required when acltype is not set")
+}
+
+func resolveACLPaths(ctx context.Context, c client.Client, ns string, aclType string, acl *nasv1.ZDatasetACL) ([]aclPath, error) {
+	var out []aclPath
+	resolve := func(path string, entries []nasv1.ZDatasetACLEntry) error {
+		p := aclPath{Path: path}
+		for _, e := range entries {
+			ace, err := resolveACLEntry(ctx, c, ns, aclType, e)
+			if err != nil {
+				return err
+			}
+			p.ACEs = append(p.ACEs, ace)
+		}
+		out = append(out, p)
+		return nil
+	}
+	if len(acl.Entries) > 0 {
+		if err := resolve("", acl.Entries); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range acl.Paths {
+		if err := resolve(strings.TrimSpace(p.Path), p.Entries); err != nil {
+			return nil, fmt.Errorf("path %s: %w", p.Path, err)
+		}
+	}
+	return out, nil
+}
+
+// applyACL reconciles spec.acl on the node. It reports whether a recursive
+// reset is still running so the caller can poll sooner.
+func (r *ZDatasetReconciler) applyACL(ctx context.Context, na *NodeAgentClient, obj *nasv1.ZDataset) (bool, error) {
+	acl := obj.Spec.ACL
+	if acl == nil {
+		obj.Status.ACL = nil
+		return false, nil
+	}
+	aclType, err := datasetACLType(obj)
+	if err != nil {
+		return false, err
+	}
+	paths, err := resolveACLPaths(ctx, r.Client, obj.Namespace, aclType, acl)
+	if err != nil {
+		return false, err
+	}
+	if len(paths) == 0 {
+		obj.Status.ACL = nil
+		return false, nil
+	}
+	status := obj.Status.ACL
+	if status == nil {
+		status = &nasv1.ZDatasetACLStatus{}
+	}
+	body := map[string]any{
+		"dataset": obj.Spec.DatasetName,
+		"type":    aclType,
+		"paths":   paths,
+	}
+	if mp := datasetProperty(obj.Spec.Properties, "mountpoint"); mp != "" {
+		body["mountpoint"] = mp
+	}
+
+	token := strings.TrimSpace(acl.ResetToken)
+	if token != "" && (token != status.ResetToken || status.ResetState == "Running" || status.ResetState == "Pending") {
+		resetBody := map[string]any{"resetToken": token}
+		for k, v := range body {
+			resetBody[k] = v
+		}
+		var out aclResponse
+		if err := na.do(ctx, "POST", "/v1/zfs/dataset/acl", resetBody, &out, nil); err != nil {
+			return false, err
+		}
+		status.ResetToken = token
+		if out.Reset != nil {
+			status.ResetState = out.Reset.State
+			status.ResetMessage = out.Reset.Message
+		}
+		obj.Status.ACL = status
+		if status.ResetState == "Running" || status.ResetState == "Pending" {
+			return true, nil
+		}
+		if status.ResetState == "Failed" {
+			return false, fmt.Errorf("acl reset failed: %s", status.ResetMessage)
+		}
+	}
+
+	var out aclResponse
+	if err := na.do(ctx, "POST", "/v1/zfs/dataset/acl", body, &out, nil); err != nil {
+		return false, err
+	}
+	status.Changed = out.Changed
+	status.LastApplied = time.Now().UTC().Format(time.RFC3339)
+	obj.Status.ACL = status
+	return false, nil
+}
+
+func aclReferences(acl *nasv1.ZDatasetACL, name string, group bool) bool {
+	if acl == nil {
+		return false
+	}
+	match := func(entries []nasv1.ZDatasetACLEntry) bool {
+		for _, e := range entries {
+			ref := e.User
+			if group {
+				ref = e.Group
+			}
+			if strings.TrimSpace(ref) == name {
+				return true
+			}
+		}
+		return false
+	}
+	if match(acl.Entries) {
+		return true
+	}
+	for _, p := range acl.Paths {
+		if match(p.Entries) {
+			return true
+		}
+	}
+	return false
+}
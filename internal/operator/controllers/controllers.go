@@ -9,6 +9,11 @@ type Config struct {
 	AuthHeader       string
 	AuthValue        string
 	Namespace        string
+	// NodeAgentURLs addresses the node-agent of a given node, for
+	// replication between nodes. Nodes not listed are reached on their
+	// InternalIP at NodeAgentPort (the DaemonSet's hostPort).
+	NodeAgentURLs map[string]string
+	NodeAgentPort int
 }
 
 func SetupAll(mgr ctrl.Manager, cfg Config) error {
@@ -18,7 +23,7 @@ func SetupAll(mgr ctrl.Manager, cfg Config) error {
 	if err := (&ZDatasetReconciler{Client: mgr.GetClient(), Cfg: cfg}).SetupWithManager(mgr); err != nil {
 		return err
 	}
-	if err := (&ZSnapshotReconciler{Client: mgr.GetClient(), Cfg: cfg}).SetupWithManager(mgr); err != nil {
+	if err := (&ZSnapshotReconciler{Client: mgr.GetClient(), Cfg: cfg, RESTConfig: mgr.GetConfig()}).SetupWithManager(mgr); err != nil {
 		return err
 	}
 	if err := (&NASDirectoryReconciler{Client: mgr.GetClient(), Cfg: cfg}).SetupWithManager(mgr); err != nil {
@@ -27,10 +32,25 @@ func SetupAll(mgr ctrl.Manager, cfg Config) error {
 	if err := (&NASShareReconciler{Client: mgr.GetClient(), Cfg: cfg}).SetupWithManager(mgr); err != nil {
 		return err
 	}
-	if err := (&ZSnapshotScheduleReconciler{Client: mgr.GetClient(), Cfg: cfg}).SetupWithManager(mgr); err != nil {
+	if err := (&ZSnapshotScheduleReconciler{Client: mgr.GetClient(), Cfg: cfg, RESTConfig: mgr.GetConfig()}).SetupWithManager(mgr); err != nil {
 		return err
 	}
-	if err := (&ZSnapshotRestoreReconciler{Client: mgr.GetClient(), Cfg: cfg}).SetupWithManager(mgr); err != nil {
+	if err := (&ZSnapshotRestoreReconciler{Client: mgr.GetClient(), Cfg: cfg, APIReader: mgr.GetAPIReader()}).SetupWithManager(mgr); err != nil {
+		return err
+	}
+	if err := (&ZDatasetSnapshotReconciler{Client: mgr.GetClient(), Cfg: cfg}).SetupWithManager(mgr); err != nil {
+		return err
+	}
+	if err := (&ZSnapshotFileRestoreReconciler{Client: mgr.GetClient(), Cfg: cfg}).SetupWithManager(mgr); err != nil {
+		return err
+	}
+	if err := (&ZSnapshotHoldReconciler{Client: mgr.GetClient(), Cfg: cfg}).SetupWithManager(mgr); err != nil {
+		return err
+	}
+	if err := (&ZVolumeReconciler{Client: mgr.GetClient(), Cfg: cfg}).SetupWithManager(mgr); err != nil {
+		return err
+	}
+	if err := (&ZReplicationReconciler{Client: mgr.GetClient(), Cfg: cfg}).SetupWithManager(mgr); err != nil {
 		return err
 	}
 	return nil
@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	nasv1 "mnemosyne/api/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// datasetPresetsConfigMap holds site-defined presets in the operator
+// namespace. Each key is a preset name; each value is JSON such as
+//
+//	{"acl": "smb", "properties": {"recordsize": "1M", "compression": "zstd"}}
+//
+// Entries with the same name as a built-in preset replace it.
+const datasetPresetsConfigMap = "nas-dataset-presets"
+
+type datasetPreset struct {
+	ACL        string            `json:"acl,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+var builtinDatasetPresets = map[string]datasetPreset{
+	"generic":       {ACL: "generic"},
+	"smb":           {ACL: "smb"},
+	"multiprotocol": {ACL: "multiprotocol"},
+	"timemachine": {
+		ACL: "smb",
+		Properties: map[string]string{
+			"recordsize":  "1M",
+			"compression": "lz4",
+			"atime":       "off",
+			"xattr":       "sa",
+			"dnodesize":   "auto",
+		},
+	},
+	"media": {
+		Properties: map[string]string{
+			"recordsize":  "1M",
+			"compression": "lz4",
+			"atime":       "off",
+			"xattr":       "sa",
+			"dnodesize":   "auto",
+		},
+	},
+	"database": {
+		Properties: map[string]string{
+			"recordsize":  "16K",
+			"compression": "lz4",
+			"atime":       "off",
+			"logbias":     "throughput",
+			"sync":        "standard",
+			"xattr":       "sa",
+		},
+	},
+	"vm": {
+		Properties: map[string]string{
+			"recordsize":  "64K",
+			"compression": "lz4",
+			"atime":       "off",
+			"logbias":     "latency",
+			"sync":        "always",
+			"xattr":       "sa",
+		},
+	},
+	"backup-target": {
+		Properties: map[string]string{
+			"recordsize":  "1M",
+			"compression": "zstd",
+			"atime":       "off",
+			"logbias":     "throughput",
+			"sync":        "standard",
+			"xattr":       "sa",
+			"dnodesize":   "auto",
+		},
+	},
+}
+
+// Aliases accepted by the node-agent before presets were configurable.
+var datasetPresetAliases = map[string]string{
+	"multi":          "multiprotocol",
+	"multi-protocol": "multiprotocol",
+	"multiproto":     "multiprotocol",
+	"samba":          "smb",
+	"time-machine":   "timemachine",
+	"backup":         "backup-target",
+}
+
+// lookupDatasetPreset resolves a preset by name, preferring the ConfigMap.
+func lookupDatasetPreset(ctx context.Context, c client.Client, ns, name string) (datasetPreset, string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := datasetPresetAliases[name]; ok {
+		name = alias
+	}
+	var cm corev1.ConfigMap
+	err := c.Get(ctx, client.ObjectKey{Namespace: ns, Name: datasetPresetsConfigMap}, &cm)
+	if err != nil && !errors.IsNotFound(err) {
+		return datasetPreset{}, "", err
+	}
+	if err == nil {
+		for k, raw := range cm.Data {
+			if strings.ToLower(strings.TrimSpace(k)) != name {
+				continue
+			}
+			var p datasetPreset
+			if err := json.Unmarshal([]byte(raw), &p); err != nil {
+				return datasetPreset{}, "", fmt.Errorf("preset %s in configmap %s: %w", name, datasetPresetsConfigMap, err)
+			}
+			switch acl := strings.ToLower(strings.TrimSpace(p.ACL)); acl {
+			case "", "generic", "smb", "multiprotocol":
+				p.ACL = acl
+			default:
+				return datasetPreset{}, "", fmt.Errorf("preset %s: unsupported acl %q", name, p.ACL)
+			}
+			return p, "configmap", nil
+		}
+	}
+	if p, ok := builtinDatasetPresets[name]; ok {
+		return p, "builtin", nil
+	}
+	return datasetPreset{}, "", fmt.Errorf("unknown preset %q", name)
+}
+
+// mergePresetProperties layers spec properties over the preset defaults and
+// reports which preset values were applied and which were overridden.
+func mergePresetProperties(name, source string, p datasetPreset, props map[string]string) (map[string]string, *nasv1.ZDatasetPresetStatus) {
+	merged := map[string]string{}
+	status := &nasv1.ZDatasetPresetStatus{Name: name, ACL: p.ACL, Source: source}
+	for k, raw := range p.Properties {
+		key := strings.ToLower(strings.TrimSpace(k))
+		v := strings.TrimSpace(raw)
+		if key == "" || v == "" {
+			continue
+		}
+		if explicit := datasetProperty(props, key); explicit != "" && explicit != v {
+			if status.Overridden == nil {
+				status.Overridden = map[string]string{}
+			}
+			status.Overridden[key] = v
+			continue
+		}
+		merged[key] = v
+		if status.Applied == nil {
+			status.Applied = map[string]string{}
+		}
+		status.Applied[key] = v
+	}
+	for k, v := range props {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		delete(merged, strings.ToLower(strings.TrimSpace(k)))
+		merged[k] = v
+	}
+	return merged, status
+}
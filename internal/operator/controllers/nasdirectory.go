@@ -140,8 +140,17 @@ func (r *NASDirectoryReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		_ = upsert(ctx, r.Client, &sssdSecret)
 	}
 
+	if dirType == "local" {
+		if err := r.reconcileHomes(ctx, &obj); err != nil {
+			return r.setDirectoryError(ctx, &obj, fmt.Sprintf("homes: %v", err))
+		}
+	}
+
 	connectivityOK, connectivityMsg := checkDirectoryConnectivity(ctx, dirType, obj.Spec.Servers)
 	r.setDirectoryReady(&obj, hash, connectivityOK, connectivityMsg)
+	if len(obj.Status.SkippedHomes) > 0 {
+		obj.Status.Message = "homes skipped: " + strings.Join(obj.Status.SkippedHomes, "; ")
+	}
 	_ = r.Status().Update(ctx, &obj)
 
 	return ctrl.Result{RequeueAfter: 10 * time.Minute}, nil
@@ -152,6 +161,27 @@ func (r *NASDirectoryReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		For(&nasv1.NASDirectory{}).
 		Owns(&corev1.ConfigMap{}).
 		Owns(&corev1.Secret{}).
+		Owns(&nasv1.NASShare{}).
+		Watches(&nasv1.ZDataset{}, handler.EnqueueRequestsFromMapFunc(directoryForHomeObject)).
+		Watches(&nasv1.ZSnapshotSchedule{}, handler.EnqueueRequestsFromMapFunc(directoryForHomeObject)).
+		Watches(&nasv1.NASUser{}, handler.EnqueueRequestsFromMapFunc(
+			func(ctx context.Context, obj client.Object) []reconcile.Request {
+				u, ok := obj.(*nasv1.NASUser)
+				if !ok {
+					return nil
+				}
+				return directoryForPrincipal(u, u.Spec.DirectoryRef)
+			}),
+		).
+		Watches(&nasv1.NASGroup{}, handler.EnqueueRequestsFromMapFunc(
+			func(ctx context.Context, obj client.Object) []reconcile.Request {
+				g, ok := obj.(*nasv1.NASGroup)
+				if !ok {
+					return nil
+				}
+				return directoryForPrincipal(g, g.Spec.DirectoryRef)
+			}),
+		).
 		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(
 			func(ctx context.Context, obj client.Object) []reconcile.Request {
 				sec, ok := obj.(*corev1.Secret)
@@ -0,0 +1,331 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	nasv1 "mnemosyne/api/v1alpha1"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// homeDirectoryLabel marks objects generated for a directory's home datasets.
+const homeDirectoryLabel = "nas.io/home-directory"
+
+var homeModeRe = regexp.MustCompile(`^[0-7]{3,4}$`)
+
+// reconcileHomes provisions a ZDataset (and optional ZSnapshotSchedule) per
+// NASUser of a local directory, plus the parent dataset and [homes] share.
+// Objects no longer wanted are deleted; the datasets on disk are kept. Users
+// without a uid or resolvable group are skipped and listed in status.
+func (r *NASDirectoryReconciler) reconcileHomes(ctx context.Context, dir *nasv1.NASDirectory) error {
+	var homes *nasv1.NASDirectoryHomes
+	if dir.Spec.Local != nil && dir.Spec.Local.Homes != nil && dir.Spec.Local.Homes.Enabled {
+		homes = dir.Spec.Local.Homes
+	}
+	keep := map[string]struct{}{}
+	var skipped []string
+	defer func() { dir.Status.SkippedHomes = skipped }()
+	if homes != nil {
+		if err := validateHomes(homes); err != nil {
+			return err
+		}
+		parent := strings.TrimSpace(homes.ParentDataset)
+		dirRef := *metav1.NewControllerRef(dir, nasv1.GroupVersion.WithKind("NASDirectory"))
+
+		parentDS := nasv1.ZDataset{
+			ObjectMeta: homeObjectMeta(dir, fmt.Sprintf("%s-homes", dir.Name), dirRef),
+			Spec: nasv1.ZDatasetSpec{
+				NodeName:    strings.TrimSpace(homes.NodeName),
+				DatasetName: parent,
+				Properties:  map[string]string{},
+			},
+		}
+		if mp := strings.TrimSpace(homes.MountPath); mp != "" {
+			parentDS.Spec.Properties["mountpoint"] = mp
+		}
+		if err := upsertZDataset(ctx, r.Client, &parentDS); err != nil {
+			return fmt.Errorf("parent dataset %s: %w", parent, err)
+		}
+		keep["zdataset/"+parentDS.Name] = struct{}{}
+
+		var users nasv1.NASUserList
+		if err := r.List(ctx, &users, client.InNamespace(dir.Namespace)); err != nil {
+			return err
+		}
+		var shareUsers []string
+		for i := range users.Items {
+			u := &users.Items[i]
+			if !u.DeletionTimestamp.IsZero() || !directoryMatches(dir.Name, u.Spec.DirectoryRef) {
+				continue
+			}
+			username := strings.TrimSpace(u.Spec.Username)
+			if username == "" {
+				continue
+			}
+			// One broken user must not cost everyone else their home. What
+			// the user already has is left in place rather than pruned.
+			skip := ""
+			gid, err := r.homeGID(ctx, u)
+			switch {
+			case u.Spec.UID <= 0:
+				skip = "no uid"
+			case err != nil:
+				skip = err.Error()
+			}
+			if skip != "" {
+				skipped = append(skipped, fmt.Sprintf("%s: %s", u.Name, skip))
+				keep["zdataset/"+homeObjectName(u)] = struct{}{}
+				keep["zsnapshotschedule/"+homeObjectName(u)] = struct{}{}
+				continue
+			}
+			userRef := *metav1.NewControllerRef(u, nasv1.GroupVersion.WithKind("NASUser"))
+			ds := nasv1.ZDataset{
+				ObjectMeta: homeObjectMeta(dir, homeObjectName(u), userRef),
+				Spec: nasv1.ZDatasetSpec{
+					NodeName:    strings.TrimSpace(homes.NodeName),
+					DatasetName: path.Join(parent, username),
+					Properties:  homeProperties(homes, u),
+					Owner: &nasv1.ZDatasetOwner{
+						UID:  u.Spec.UID,
+						GID:  gid,
+						Mode: homeMode(homes),
+					},
+				},
+			}
+			if err := upsertZDataset(ctx, r.Client, &ds); err != nil {
+				return fmt.Errorf("home dataset for %s: %w", u.Name, err)
+			}
+			keep["zdataset/"+ds.Name] = struct{}{}
+
+			if snap := homes.Snapshots; snap != nil && strings.TrimSpace(snap.Schedule) != "" {
+				sched := nasv1.ZSnapshotSchedule{
+					ObjectMeta: homeObjectMeta(dir, homeObjectName(u), userRef),
+					Spec: nasv1.ZSnapshotScheduleSpec{
+						NodeName:    strings.TrimSpace(homes.NodeName),
+						DatasetName: ds.Spec.DatasetName,
+						Schedule:    strings.TrimSpace(snap.Schedule),
+						NamePrefix:  strings.TrimSpace(snap.NamePrefix),
+						Format:      strings.TrimSpace(snap.Format),
+						Retention:   snap.Retention.DeepCopy(),
+					},
+				}
+				if err := upsertSnapshotSchedule(ctx, r.Client, &sched); err != nil {
+					return fmt.Errorf("home snapshot schedule for %s: %w", u.Name, err)
+				}
+				keep["zsnapshotschedule/"+sched.Name] = struct{}{}
+			}
+			shareUsers = append(shareUsers, u.Name)
+		}
+
+		if homes.SMBShare && len(shareUsers) > 0 {
+			share := nasv1.NASShare{
+				ObjectMeta: homeObjectMeta(dir, fmt.Sprintf("%s-homes", dir.Name), dirRef),
+				Spec: nasv1.NASShareSpec{
+					Protocol:     "smb",
+					DatasetName:  parent,
+					MountPath:    strings.TrimSpace(homes.MountPath),
+					ShareName:    "homes",
+					DirectoryRef: dir.Name,
+					Permissions: &nasv1.NASSharePermissions{
+						Allow: nasv1.NASSharePrincipalSelector{Users: shareUsers},
+					},
+					Options: map[string]any{"homes": true},
+				},
+			}
+			if err := upsertShare(ctx, r.Client, &share); err != nil {
+				return fmt.Errorf("homes share: %w", err)
+			}
+			keep["nasshare/"+share.Name] = struct{}{}
+		}
+	}
+	return r.pruneHomes(ctx, dir, keep)
+}
+
+func validateHomes(h *nasv1.NASDirectoryHomes) error {
+	if strings.TrimSpace(h.NodeName) == "" {
+		return fmt.Errorf("local.homes.nodeName required")
+	}
+	if strings.TrimSpace(h.ParentDataset) == "" {
+		return fmt.Errorf("local.homes.parentDataset required")
+	}
+	if h.SMBShare && strings.TrimSpace(h.MountPath) == "" {
+		return fmt.Errorf("local.homes.mountPath required for smbShare")
+	}
+	if mode := strings.TrimSpace(h.Mode); mode != "" && !homeModeRe.MatchString(mode) {
+		return fmt.Errorf("local.homes.mode must be octal (e.g. 0700)")
+	}
+	if h.Snapshots != nil && strings.TrimSpace(h.Snapshots.Schedule) == "" {
+		return fmt.Errorf("local.homes.snapshots.schedule required")
+	}
+	return nil
+}
+
+func homeObjectName(u *nasv1.NASUser) string {
+	return fmt.Sprintf("home-%s", u.Name)
+}
+
+func homeObjectMeta(dir *nasv1.NASDirectory, name string, owner metav1.OwnerReference) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:            name,
+		Namespace:       dir.Namespace,
+		Labels:          map[string]string{homeDirectoryLabel: dir.Name},
+		OwnerReferences: []metav1.OwnerReference{owner},
+	}
+}
+
+func homeMode(h *nasv1.NASDirectoryHomes) string {
+	if mode := strings.TrimSpace(h.Mode); mode != "" {
+		return mode
+	}
+	return "0700"
+}
+
+func homeProperties(h *nasv1.NASDirectoryHomes, u *nasv1.NASUser) map[string]string {
+	props := map[string]string{}
+	for k, v := range h.Properties {
+		props[k] = v
+	}
+	quota := strings.TrimSpace(u.Spec.HomeQuota)
+	if quota == "" {
+		quota = strings.TrimSpace(h.Quota)
+	}
+	if quota != "" {
+		props["quota"] = quota
+	}
+	return props
+}
+
+// homeGID prefers the GID of the user's primary NASGroup and falls back to
+// the UID (a per-user group, as adduser would create).
+func (r *NASDirectoryReconciler) homeGID(ctx context.Context, u *nasv1.NASUser) (int64, error) {
+	name := strings.TrimSpace(u.Spec.PrimaryGroup)
+	if name == "" {
+		return u.Spec.UID, nil
+	}
+	var g nasv1.NASGroup
+	if err := r.Get(ctx, client.ObjectKey{Namespace: u.Namespace, Name: name}, &g); err != nil {
+		return 0, fmt.Errorf("primary group %s of nasuser %s not found: %w", name, u.Name, err)
+	}
+	if g.Spec.GID <= 0 {
+		return 0, fmt.Errorf("nasgroup %s has no gid", name)
+	}
+	return g.Spec.GID, nil
+}
+
+func (r *NASDirectoryReconciler) pruneHomes(ctx context.Context, dir *nasv1.NASDirectory, keep map[string]struct{}) error {
+	sel := client.MatchingLabels{homeDirectoryLabel: dir.Name}
+	var datasets nasv1.ZDatasetList
+	if err := r.List(ctx, &datasets, client.InNamespace(dir.Namespace), sel); err != nil {
+		return err
+	}
+	for i := range datasets.Items {
+		if _, ok := keep["zdataset/"+datasets.Items[i].Name]; !ok {
+			if err := client.IgnoreNotFound(r.Delete(ctx, &datasets.Items[i])); err != nil {
+				return err
+			}
+		}
+	}
+	var schedules nasv1.ZSnapshotScheduleList
+	if err := r.List(ctx, &schedules, client.InNamespace(dir.Namespace), sel); err != nil {
+		return err
+	}
+	for i := range schedules.Items {
+		if _, ok := keep["zsnapshotschedule/"+schedules.Items[i].Name]; !ok {
+			if err := client.IgnoreNotFound(r.Delete(ctx, &schedules.Items[i])); err != nil {
+				return err
+			}
+		}
+	}
+	var shares nasv1.NASShareList
+	if err := r.List(ctx, &shares, client.InNamespace(dir.Namespace), sel); err != nil {
+		return err
+	}
+	for i := range shares.Items {
+		if _, ok := keep["nasshare/"+shares.Items[i].Name]; !ok {
+			if err := client.IgnoreNotFound(r.Delete(ctx, &shares.Items[i])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// directoryForPrincipal maps a NASUser or NASGroup event to its directory.
+func directoryForPrincipal(obj client.Object, directoryRef string) []reconcile.Request {
+	name := strings.TrimSpace(directoryRef)
+	if name == "" {
+		name = "local"
+	}
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{Name: name, Namespace: obj.GetNamespace()},
+	}}
+}
+
+// directoryForHomeObject maps a generated home object back to its directory
+// by homeDirectoryLabel. Per-user objects are controlled by their NASUser,
+// so Owns() on the directory would not see them.
+func directoryForHomeObject(_ context.Context, obj client.Object) []reconcile.Request {
+	name := obj.GetLabels()[homeDirectoryLabel]
+	if name == "" {
+		return nil
+	}
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{Name: name, Namespace: obj.GetNamespace()},
+	}}
+}
+
+// The upsert helpers below keep status and server-managed metadata intact
+// while replacing the spec, labels and owner of generated objects.
+
+func upsertZDataset(ctx context.Context, c client.Client, want *nasv1.ZDataset) error {
+	var cur nasv1.ZDataset
+	err := c.Get(ctx, client.ObjectKeyFromObject(want), &cur)
+	if errors.IsNotFound(err) {
+		return c.Create(ctx, want)
+	}
+	if err != nil {
+		return err
+	}
+	cur.Labels = want.Labels
+	cur.OwnerReferences = want.OwnerReferences
+	cur.Spec = want.Spec
+	return c.Update(ctx, &cur)
+}
+
+func upsertSnapshotSchedule(ctx context.Context, c client.Client, want *nasv1.ZSnapshotSchedule) error {
+	var cur nasv1.ZSnapshotSchedule
+	err := c.Get(ctx, client.ObjectKeyFromObject(want), &cur)
+	if errors.IsNotFound(err) {
+		return c.Create(ctx, want)
+	}
+	if err != nil {
+		return err
+	}
+	cur.Labels = want.Labels
+	cur.OwnerReferences = want.OwnerReferences
+	cur.Spec = want.Spec
+	return c.Update(ctx, &cur)
+}
+
+func upsertShare(ctx context.Context, c client.Client, want *nasv1.NASShare) error {
+	var cur nasv1.NASShare
+	err := c.Get(ctx, client.ObjectKeyFromObject(want), &cur)
+	if errors.IsNotFound(err) {
+		return c.Create(ctx, want)
+	}
+	if err != nil {
+		return err
+	}
+	cur.Labels = want.Labels
+	cur.OwnerReferences = want.OwnerReferences
+	cur.Spec = want.Spec
+	return c.Update(ctx, &cur)
+}
@@ -18,10 +18,16 @@ import (
 	"k8s.io/apimachinery/pkg/util/intstr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 )
 
 const nasshareFinalizer = "nas.io/nasshare-finalizer"
 
+// sharePausedByAnnotation names the ZSnapshotRestore (namespace/name) that has
+// taken the share offline. SMB deployments are scaled to zero and NFS exports
+// removed until the annotation is cleared.
+const sharePausedByAnnotation = "nas.io/paused-by"
+
 type NASShareReconciler struct {
 	client.Client
 	Cfg Config
@@ -43,13 +49,20 @@ func (r *NASShareReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 
 	if !obj.DeletionTimestamp.IsZero() {
 		if slices.Contains(obj.Finalizers, nasshareFinalizer) {
-			if proto == "nfs" {
-				if err := r.deleteNFSExport(ctx, &obj); err != nil {
-					obj.Status.Phase = "Error"
-					obj.Status.Message = err.Error()
-					_ = r.Status().Update(ctx, &obj)
-					return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
-				}
+			var err error
+			switch proto {
+			case "nfs":
+				err = r.deleteNFSExport(ctx, &obj)
+			case "iscsi":
+				err = r.deleteISCSITarget(ctx, &obj)
+			case "nvmeof":
+				err = r.deleteNVMeoFSubsystem(ctx, &obj)
+			}
+			if err != nil {
+				obj.Status.Phase = "Error"
+				obj.Status.Message = err.Error()
+				_ = r.Status().Update(ctx, &obj)
+				return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
 			}
 			obj.Finalizers = slices.DeleteFunc(obj.Finalizers, func(n string) bool {
 				return n == nasshareFinalizer
@@ -59,18 +72,33 @@ func (r *NASShareReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		return ctrl.Result{}, nil
 	}
 
-	if proto == "nfs" && !slices.Contains(obj.Finalizers, nasshareFinalizer) {
+	if (proto == "nfs" || proto == "iscsi" || proto == "nvmeof") && !slices.Contains(obj.Finalizers, nasshareFinalizer) {
 		obj.Finalizers = append(obj.Finalizers, nasshareFinalizer)
 		if err := r.Update(ctx, &obj); err != nil {
 			return ctrl.Result{}, err
 		}
 	}
 
+	if (proto == "smb" || proto == "nfs") && strings.TrimSpace(obj.Spec.MountPath) == "" {
+		obj.Status.Phase = "Error"
+		obj.Status.Message = fmt.Sprintf("mountPath required for %s shares", proto)
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	if by := strings.TrimSpace(obj.Annotations[sharePausedByAnnotation]); by != "" {
+		return r.pause(ctx, &obj, proto, by)
+	}
+
 	switch proto {
 	case "smb":
 		return r.reconcileSMB(ctx, &obj)
 	case "nfs":
 		return r.reconcileNFS(ctx, &obj)
+	case "iscsi":
+		return r.reconcileISCSI(ctx, &obj)
+	case "nvmeof":
+		return r.reconcileNVMeoF(ctx, &obj)
 	default:
 		obj.Status.Phase = "Error"
 		obj.Status.Message = fmt.Sprintf("unsupported protocol: %s", obj.Spec.Protocol)
@@ -174,6 +202,14 @@ func (r *NASShareReconciler) reconcileSMB(ctx context.Context, obj *nasv1.NASSha
 	if _, ok := opts.GlobalOptions["include"]; !ok {
 		opts.GlobalOptions["include"] = "/etc/smb/directory/smb.conf"
 	}
+	if se := shareSnapshotExposure(obj); se != nil {
+		if err := checkShareSchedules(ctx, r.Client, obj, se); err != nil {
+			obj.Status.Phase = "Error"
+			obj.Status.Message = err.Error()
+			_ = r.Status().Update(ctx, obj)
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+	}
 
 	var allowSel, roSel nasv1.NASSharePrincipalSelector
 	if spec.Permissions != nil {
@@ -291,6 +327,13 @@ func (r *NASShareReconciler) reconcileSMB(ctx context.Context, obj *nasv1.NASSha
 		{Name: "directory", MountPath: "/etc/smb/directory", ReadOnly: true},
 		{Name: "data", MountPath: mountPath, ReadOnly: readOnly},
 	}
+	if dataVolume.HostPath != nil {
+		// Datasets mounted below the path after the pod started, such as new
+		// home datasets, must show up in the container; otherwise writes
+		// land in the parent dataset and bypass the child's quota.
+		hostToContainer := corev1.MountPropagationHostToContainer
+		volumeMounts[2].MountPropagation = &hostToContainer
+	}
 	volumes := []corev1.Volume{
 		{
 			Name: "conf",
@@ -358,6 +401,10 @@ func (r *NASShareReconciler) reconcileSMB(ctx context.Context, obj *nasv1.NASSha
 			},
 		})
 	}
+	var sambaEnv []corev1.EnvVar
+	if se := opts.SnapshotExposure; se != nil && se.Enabled && se.TimeZone != "" {
+		sambaEnv = append(sambaEnv, corev1.EnvVar{Name: "TZ", Value: se.TimeZone})
+	}
 	dep := appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:            depName,
@@ -390,6 +437,7 @@ func (r *NASShareReconciler) reconcileSMB(ctx context.Context, obj *nasv1.NASSha
 							Args: []string{
 								"sh /etc/smb/users.sh && if command -v samba.sh >/dev/null 2>&1; then exec samba.sh -I /etc/smb/smb.conf; else exec /usr/sbin/smbd -F -s /etc/smb/smb.conf; fi",
 							},
+							Env:          sambaEnv,
 							VolumeMounts: volumeMounts,
 						},
 					},
@@ -432,6 +480,52 @@ func (r *NASShareReconciler) reconcileSMB(ctx context.Context, obj *nasv1.NASSha
 	return ctrl.Result{RequeueAfter: 10 * time.Minute}, nil
 }
 
+// pause stops serving the share. Phase turns Paused once clients are gone:
+// the SMB pods have exited or the NFS export is removed.
+func (r *NASShareReconciler) pause(ctx context.Context, obj *nasv1.NASShare, proto, by string) (ctrl.Result, error) {
+	ns := obj.GetNamespace()
+	if ns == "" {
+		ns = r.Cfg.Namespace
+	}
+	switch proto {
+	case "smb":
+		var dep appsv1.Deployment
+		err := r.Get(ctx, client.ObjectKey{Namespace: ns, Name: fmt.Sprintf("smbshare-%s", obj.GetName())}, &dep)
+		if err != nil && !errors.IsNotFound(err) {
+			obj.Status.Phase = "Error"
+			obj.Status.Message = err.Error()
+			_ = r.Status().Update(ctx, obj)
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+		if err == nil {
+			if dep.Spec.Replicas == nil || *dep.Spec.Replicas != 0 {
+				zero := int32(0)
+				dep.Spec.Replicas = &zero
+				if err := r.Update(ctx, &dep); err != nil {
+					return ctrl.Result{}, err
+				}
+			}
+			if dep.Status.Replicas > 0 {
+				obj.Status.Phase = "Pausing"
+				obj.Status.Message = fmt.Sprintf("stopping for %s", by)
+				_ = r.Status().Update(ctx, obj)
+				return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+			}
+		}
+	case "nfs":
+		if err := r.deleteNFSExport(ctx, obj); err != nil {
+			obj.Status.Phase = "Error"
+			obj.Status.Message = err.Error()
+			_ = r.Status().Update(ctx, obj)
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+	}
+	obj.Status.Phase = "Paused"
+	obj.Status.Message = fmt.Sprintf("paused by %s", by)
+	_ = r.Status().Update(ctx, obj)
+	return ctrl.Result{}, nil
+}
+
 func (r *NASShareReconciler) reconcileNFS(ctx context.Context, obj *nasv1.NASShare) (ctrl.Result, error) {
 	ns := obj.GetNamespace()
 	if ns == "" {
@@ -483,24 +577,8 @@ func (r *NASShareReconciler) reconcileNFS(ctx context.Context, obj *nasv1.NASSha
 		}
 	}
 
-	clients := []string{}
-	options := ""
-	if spec.NFS != nil {
-		clients = append(clients, spec.NFS.Clients...)
-		options = spec.NFS.Options
-	}
-	options = normalizeNFSOptions(options, spec.ReadOnly)
-	if len(clients) == 0 {
-		clients = []string{"*"}
-	}
-
-	body := map[string]any{
-		"path":    spec.MountPath,
-		"clients": clients,
-		"options": options,
-	}
 	var out map[string]any
-	if err := na.do(ctx, "POST", "/v1/nfs/export/ensure", body, &out, nil); err != nil {
+	if err := na.do(ctx, "POST", "/v1/nfs/export/ensure", nfsExportBody(spec), &out, nil); err != nil {
 		obj.Status.Phase = "Error"
 		obj.Status.Message = err.Error()
 		_ = r.Status().Update(ctx, obj)
@@ -546,6 +624,24 @@ func (r *NASShareReconciler) applyNFSDirectoryConfig(ctx context.Context, ns str
 	return na.do(ctx, "POST", "/v1/nfs/sssd/apply", body, nil, nil)
 }
 
+func nfsExportBody(spec nasv1.NASShareSpec) map[string]any {
+	clients := []string{}
+	options := ""
+	if spec.NFS != nil {
+		clients = append(clients, spec.NFS.Clients...)
+		options = spec.NFS.Options
+	}
+	options = normalizeNFSOptions(options, spec.ReadOnly)
+	if len(clients) == 0 {
+		clients = []string{"*"}
+	}
+	return map[string]any{
+		"path":    spec.MountPath,
+		"clients": clients,
+		"options": options,
+	}
+}
+
 func normalizeNFSOptions(raw string, readOnly bool) string {
 	raw = strings.TrimSpace(raw)
 	if raw == "" {
@@ -606,5 +702,7 @@ func (r *NASShareReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Owns(&corev1.ConfigMap{}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Service{}).
+		Watches(&nasv1.ZVolume{}, handler.EnqueueRequestsFromMapFunc(r.sharesForZVolume)).
+		Watches(&nasv1.ZSnapshotSchedule{}, handler.EnqueueRequestsFromMapFunc(r.sharesForSchedule)).
 		Complete(r)
 }
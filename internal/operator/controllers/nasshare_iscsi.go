@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	nasv1 "mnemosyne/api/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// defaultISCSIIQNPrefix is used when spec.iscsi.iqn is empty.
+const defaultISCSIIQNPrefix = "iqn.2024-01.io.nas"
+
+type iscsiSession struct {
+	Initiator string   `json:"initiator"`
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+type iscsiTargetResponse struct {
+	OK       bool           `json:"ok"`
+	IQN      string         `json:"iqn,omitempty"`
+	Portals  []string       `json:"portals,omitempty"`
+	Changed  []string       `json:"changed,omitempty"`
+	Sessions []iscsiSession `json:"sessions,omitempty"`
+}
+
+func (r *NASShareReconciler) reconcileISCSI(ctx context.Context, obj *nasv1.NASShare) (ctrl.Result, error) {
+	spec := obj.Spec
+	if spec.ISCSI == nil {
+		obj.Status.Phase = "Error"
+		obj.Status.Message = "iscsi block required for iSCSI shares"
+		_ = r.Status().Update(ctx, obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	zvol, err := r.shareZVol(ctx, obj, spec.ISCSI.ZVolumeRef)
+	if err != nil {
+		obj.Status.Phase = "Error"
+		obj.Status.Message = err.Error()
+		_ = r.Status().Update(ctx, obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	iqn := iscsiIQN(obj)
+	backstore := iscsiBackstoreName(zvol)
+
+	body := map[string]any{
+		"iqn":        iqn,
+		"backstore":  backstore,
+		"device":     "/dev/zvol/" + zvol,
+		"lun":        spec.ISCSI.LUN,
+		"readOnly":   spec.ReadOnly,
+		"portals":    spec.ISCSI.Portals,
+		"initiators": spec.ISCSI.InitiatorACLs,
+	}
+	if ref := spec.ISCSI.CHAPSecretRef; ref != nil && strings.TrimSpace(ref.Name) != "" {
+		chap, err := r.iscsiCHAP(ctx, obj.Namespace, strings.TrimSpace(ref.Name))
+		if err != nil {
+			obj.Status.Phase = "Error"
+			obj.Status.Message = err.Error()
+			_ = r.Status().Update(ctx, obj)
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+		body["chap"] = chap
+	}
+
+	na := NewNodeAgentClient(r.Cfg)
+	// A changed IQN or zvol leaves the previous target behind; remove it
+	// before exporting the new one.
+	if prev := obj.Status.ISCSI; prev != nil && (prev.IQN != iqn || prev.Backstore != backstore) {
+		old := map[string]any{"iqn": prev.IQN}
+		if prev.Backstore != backstore {
+			old["backstore"] = prev.Backstore
+		}
+		if err := na.do(ctx, "POST", "/v1/iscsi/target/delete", old, nil, nil); err != nil {
+			obj.Status.Phase = "Error"
+			obj.Status.Message = fmt.Sprintf("remove previous target %s: %v", prev.IQN, err)
+			_ = r.Status().Update(ctx, obj)
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+	}
+
+	var out iscsiTargetResponse
+	if err := na.do(ctx, "POST", "/v1/iscsi/target/ensure", body, &out, nil); err != nil {
+		obj.Status.Phase = "Error"
+		obj.Status.Message = err.Error()
+		_ = r.Status().Update(ctx, obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	status := &nasv1.NASShareISCSIStatus{IQN: iqn, Backstore: backstore, Portals: out.Portals}
+	for _, s := range out.Sessions {
+		status.Sessions = append(status.Sessions, nasv1.NASShareSession{Initiator: s.Initiator, Addresses: s.Addresses})
+	}
+	obj.Status.ISCSI = status
+	obj.Status.Phase = "Ready"
+	obj.Status.Message = fmt.Sprintf("%d initiator(s) connected", len(status.Sessions))
+	obj.Status.Endpoint = iqn
+	_ = r.Status().Update(ctx, obj)
+	// Sessions come and go; poll more often than file shares.
+	return ctrl.Result{RequeueAfter: 2 * time.Minute}, nil
+}
+
+func (r *NASShareReconciler) deleteISCSITarget(ctx context.Context, obj *nasv1.NASShare) error {
+	body := map[string]any{}
+	if prev := obj.Status.ISCSI; prev != nil {
+		body["iqn"] = prev.IQN
+		body["backstore"] = prev.Backstore
+	} else if obj.Spec.ISCSI != nil {
+		body["iqn"] = iscsiIQN(obj)
+		if zvol, err := r.shareZVol(ctx, obj, obj.Spec.ISCSI.ZVolumeRef); err == nil {
+			body["backstore"] = iscsiBackstoreName(zvol)
+		}
+	} else {
+		return nil
+	}
+	na := NewNodeAgentClient(r.Cfg)
+	return na.do(ctx, "POST", "/v1/iscsi/target/delete", body, nil, nil)
+}
+
+// shareZVol resolves the zvol of a block share from its zvolumeRef or, when
+// that is empty, spec.datasetName.
+func (r *NASShareReconciler) shareZVol(ctx context.Context, obj *nasv1.NASShare, zvolumeRef string) (string, error) {
+	if name := strings.TrimSpace(zvolumeRef); name != "" {
+		var zv nasv1.ZVolume
+		if err := r.Get(ctx, client.ObjectKey{Namespace: obj.Namespace, Name: name}, &zv); err != nil {
+			return "", fmt.Errorf("zvolume %s not found: %w", name, err)
+		}
+		if zv.Status.Phase != "Ready" {
+			return "", fmt.Errorf("zvolume %s not ready", name)
+		}
+		return zvolumeName(zv.Spec), nil
+	}
+	if name := strings.Trim(strings.TrimSpace(obj.Spec.DatasetName), "/"); name != "" {
+		return name, nil
+	}
+	return "", fmt.Errorf("zvolumeRef or datasetName required for %s shares", obj.Spec.Protocol)
+}
+
+func (r *NASShareReconciler) iscsiCHAP(ctx context.Context, ns string, name string) (map[string]string, error) {
+	var sec corev1.Secret
+	if err := r.Get(ctx, client.ObjectKey{Namespace: ns, Name: name}, &sec); err != nil {
+		return nil, fmt.Errorf("chap secret %s not found: %w", name, err)
+	}
+	user := strings.TrimSpace(string(sec.Data["username"]))
+	pass := string(sec.Data["password"])
+	if user == "" || pass == "" {
+		return nil, fmt.Errorf("chap secret %s needs username and password", name)
+	}
+	chap := map[string]string{"userid": user, "password": pass}
+	if mu := strings.TrimSpace(string(sec.Data["mutualUsername"])); mu != "" {
+		chap["mutualUserid"] = mu
+		chap["mutualPassword"] = string(sec.Data["mutualPassword"])
+	}
+	return chap, nil
+}
+
+func iscsiIQN(obj *nasv1.NASShare) string {
+	if iqn := strings.TrimSpace(obj.Spec.ISCSI.IQN); iqn != "" {
+		return iqn
+	}
+	name := strings.TrimSpace(obj.Spec.ShareName)
+	if name == "" {
+		name = obj.Name
+	}
+	return fmt.Sprintf("%s:%s", defaultISCSIIQNPrefix, strings.ToLower(name))
+}
+
+// iscsiBackstoreName derives a stable LIO backstore name from the zvol.
+func iscsiBackstoreName(zvol string) string {
+	return strings.ReplaceAll(zvol, "/", "-")
+}
+
+// sharesForZVolume requeues block shares when their ZVolume changes.
+func (r *NASShareReconciler) sharesForZVolume(ctx context.Context, obj client.Object) []reconcile.Request {
+	var shares nasv1.NASShareList
+	if err := r.List(ctx, &shares, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	var reqs []reconcile.Request
+	for _, s := range shares.Items {
+		ref := ""
+		switch {
+		case s.Spec.ISCSI != nil:
+			ref = s.Spec.ISCSI.ZVolumeRef
+		case s.Spec.NVMeoF != nil:
+			ref = s.Spec.NVMeoF.ZVolumeRef
+		}
+		if strings.TrimSpace(ref) == obj.GetName() {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&s)})
+		}
+	}
+	return reqs
+}
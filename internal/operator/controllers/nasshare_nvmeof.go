@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	nasv1 "mnemosyne/api/v1alpha1"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// defaultNVMeoFNQNPrefix is used when spec.nvmeof.nqn is empty.
+const defaultNVMeoFNQNPrefix = "nqn.2024-01.io.nas"
+
+type nvmeofController struct {
+	HostNQN string `json:"hostnqn"`
+	Address string `json:"address,omitempty"`
+	State   string `json:"state,omitempty"`
+}
+
+type nvmeofResponse struct {
+	OK               bool               `json:"ok"`
+	Portals          []string           `json:"portals,omitempty"`
+	Hosts            []string           `json:"hosts,omitempty"`
+	ControllersKnown bool               `json:"controllersKnown"`
+	Controllers      []nvmeofController `json:"controllers,omitempty"`
+}
+
+func (r *NASShareReconciler) reconcileNVMeoF(ctx context.Context, obj *nasv1.NASShare) (ctrl.Result, error) {
+	spec := obj.Spec
+	if spec.NVMeoF == nil {
+		obj.Status.Phase = "Error"
+		obj.Status.Message = "nvmeof block required for NVMe-oF shares"
+		_ = r.Status().Update(ctx, obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	if len(spec.NVMeoF.Hosts) == 0 {
+		obj.Status.Phase = "Error"
+		obj.Status.Message = "nvmeof.hosts requires at least one host NQN"
+		_ = r.Status().Update(ctx, obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	zvol, err := r.shareZVol(ctx, obj, spec.NVMeoF.ZVolumeRef)
+	if err != nil {
+		obj.Status.Phase = "Error"
+		obj.Status.Message = err.Error()
+		_ = r.Status().Update(ctx, obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	nqn := nvmeofNQN(obj)
+	nsid := spec.NVMeoF.NSID
+	if nsid == 0 {
+		nsid = 1
+	}
+	portals := spec.NVMeoF.Portals
+	if len(portals) == 0 {
+		portals = []string{""}
+	}
+
+	na := NewNodeAgentClient(r.Cfg)
+	// Tear down what a previous spec exported before applying the new one.
+	if prev := obj.Status.NVMeoF; prev != nil {
+		var err error
+		switch {
+		case prev.NQN != nqn:
+			err = na.do(ctx, "POST", "/v1/nvmeof/subsystem/delete", map[string]any{"nqn": prev.NQN}, nil, nil)
+		case prev.NSID != nsid:
+			err = na.do(ctx, "POST", "/v1/nvmeof/namespace/delete", map[string]any{"nqn": nqn, "nsid": prev.NSID}, nil, nil)
+		}
+		if err != nil {
+			obj.Status.Phase = "Error"
+			obj.Status.Message = fmt.Sprintf("remove previous export: %v", err)
+			_ = r.Status().Update(ctx, obj)
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+	}
+
+	steps := []struct {
+		path string
+		body map[string]any
+	}{
+		{"/v1/nvmeof/subsystem/ensure", map[string]any{"nqn": nqn}},
+		{"/v1/nvmeof/namespace/ensure", map[string]any{"nqn": nqn, "nsid": nsid, "device": "/dev/zvol/" + zvol}},
+		{"/v1/nvmeof/host/apply", map[string]any{"nqn": nqn, "hosts": spec.NVMeoF.Hosts}},
+		{"/v1/nvmeof/port/apply", map[string]any{"nqn": nqn, "portals": portals}},
+	}
+	var out nvmeofResponse
+	for _, step := range steps {
+		if err := na.do(ctx, "POST", step.path, step.body, &out, nil); err != nil {
+			obj.Status.Phase = "Error"
+			obj.Status.Message = err.Error()
+			_ = r.Status().Update(ctx, obj)
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+	}
+
+	status := &nasv1.NASShareNVMeoFStatus{
+		NQN:           nqn,
+		NSID:          nsid,
+		Portals:       out.Portals,
+		SessionsKnown: out.ControllersKnown,
+	}
+	for _, c := range out.Controllers {
+		s := nasv1.NASShareSession{Initiator: c.HostNQN, State: c.State}
+		if c.Address != "" {
+			s.Addresses = []string{c.Address}
+		}
+		status.Sessions = append(status.Sessions, s)
+	}
+	obj.Status.NVMeoF = status
+	obj.Status.Phase = "Ready"
+	if status.SessionsKnown {
+		obj.Status.Message = fmt.Sprintf("%d controller(s) connected", len(status.Sessions))
+	} else {
+		obj.Status.Message = "exported; connection state unavailable (nvmet debugfs not mounted)"
+	}
+	obj.Status.Endpoint = nqn
+	_ = r.Status().Update(ctx, obj)
+	return ctrl.Result{RequeueAfter: 2 * time.Minute}, nil
+}
+
+func (r *NASShareReconciler) deleteNVMeoFSubsystem(ctx context.Context, obj *nasv1.NASShare) error {
+	nqn := ""
+	switch {
+	case obj.Status.NVMeoF != nil:
+		nqn = obj.Status.NVMeoF.NQN
+	case obj.Spec.NVMeoF != nil:
+		nqn = nvmeofNQN(obj)
+	default:
+		return nil
+	}
+	na := NewNodeAgentClient(r.Cfg)
+	return na.do(ctx, "POST", "/v1/nvmeof/subsystem/delete", map[string]any{"nqn": nqn}, nil, nil)
+}
+
+func nvmeofNQN(obj *nasv1.NASShare) string {
+	if nqn := strings.TrimSpace(obj.Spec.NVMeoF.NQN); nqn != "" {
+		return nqn
+	}
+	name := strings.TrimSpace(obj.Spec.ShareName)
+	if name == "" {
+		name = obj.Name
+	}
+	return fmt.Sprintf("%s:%s", defaultNVMeoFNQNPrefix, strings.ToLower(name))
+}
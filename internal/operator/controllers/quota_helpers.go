@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	nasv1 "mnemosyne/api/v1alpha1"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type quotaEntry struct {
+	ID    int64  `json:"id"`
+	Quota string `json:"quota"`
+}
+
+type quotaUsage struct {
+	ID    int64 `json:"id"`
+	Used  int64 `json:"used"`
+	Quota int64 `json:"quota,omitempty"`
+}
+
+type quotaResponse struct {
+	OK     bool         `json:"ok"`
+	Users  []quotaUsage `json:"users,omitempty"`
+	Groups []quotaUsage `json:"groups,omitempty"`
+}
+
+// resolveUserQuotas maps NASUser names to their UIDs. Users that do not
+// exist or have no uid are skipped and returned as missing.
+func resolveUserQuotas(ctx context.Context, c client.Client, ns string, quotas []nasv1.ZDatasetQuota) ([]nasv1.ZDatasetQuotaStatus, []string, error) {
+	var out []nasv1.ZDatasetQuotaStatus
+	var missing []string
+	seen := map[string]struct{}{}
+	for _, q := range quotas {
+		name := strings.TrimSpace(q.Name)
+		if name == "" {
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			return nil, nil, fmt.Errorf("duplicate user quota for %s", name)
+		}
+		seen[name] = struct{}{}
+		var u nasv1.NASUser
+		if err := c.Get(ctx, client.ObjectKey{Namespace: ns, Name: name}, &u); err != nil {
+			if !errors.IsNotFound(err) {
+				return nil, nil, fmt.Errorf("get nasuser %s: %w", name, err)
+			}
+			missing = append(missing, "nasuser/"+name)
+			continue
+		}
+		if u.Spec.UID <= 0 {
+			missing = append(missing, "nasuser/"+name)
+			continue
+		}
+		out = append(out, nasv1.ZDatasetQuotaStatus{Name: name, ID: u.Spec.UID, Quota: strings.TrimSpace(q.Quota)})
+	}
+	return out, missing, nil
+}
+
+// resolveGroupQuotas maps NASGroup names to their GIDs, like
+// resolveUserQuotas.
+func resolveGroupQuotas(ctx context.Context, c client.Client, ns string, quotas []nasv1.ZDatasetQuota) ([]nasv1.ZDatasetQuotaStatus, []string, error) {
+	var out []nasv1.ZDatasetQuotaStatus
+	var missing []string
+	seen := map[string]struct{}{}
+	for _, q := range quotas {
+		name := strings.TrimSpace(q.Name)
+		if name == "" {
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			return nil, nil, fmt.Errorf("duplicate group quota for %s", name)
+		}
+		seen[name] = struct{}{}
+		var g nasv1.NASGroup
+		if err := c.Get(ctx, client.ObjectKey{Namespace: ns, Name: name}, &g); err != nil {
+			if !errors.IsNotFound(err) {
+				return nil, nil, fmt.Errorf("get nasgroup %s: %w", name, err)
+			}
+			missing = append(missing, "nasgroup/"+name)
+			continue
+		}
+		if g.Spec.GID <= 0 {
+			missing = append(missing, "nasgroup/"+name)
+			continue
+		}
+		out = append(out, nasv1.ZDatasetQuotaStatus{Name: name, ID: g.Spec.GID, Quota: strings.TrimSpace(q.Quota)})
+	}
+	return out, missing, nil
+}
+
+// quotaEntries returns the entries to send to the node-agent. IDs that were
+// applied previously but are no longer desired are cleared with "none".
+func quotaEntries(desired, previous []nasv1.ZDatasetQuotaStatus) []quotaEntry {
+	var out []quotaEntry
+	keep := map[int64]struct{}{}
+	for _, q := range desired {
+		keep[q.ID] = struct{}{}
+		out = append(out, quotaEntry{ID: q.ID, Quota: q.Quota})
+	}
+	for _, q := range previous {
+		if _, ok := keep[q.ID]; ok || q.ID <= 0 {
+			continue
+		}
+		keep[q.ID] = struct{}{}
+		out = append(out, quotaEntry{ID: q.ID, Quota: "none"})
+	}
+	return out
+}
+
+func fillQuotaUsage(items []nasv1.ZDatasetQuotaStatus, usage []quotaUsage) {
+	byID := make(map[int64]quotaUsage, len(usage))
+	for _, u := range usage {
+		byID[u.ID] = u
+	}
+	for i := range items {
+		u, ok := byID[items[i].ID]
+		if !ok {
+			items[i].UsedBytes = 0
+			items[i].QuotaBytes = 0
+			continue
+		}
+		items[i].UsedBytes = u.Used
+		items[i].QuotaBytes = u.Quota
+	}
+}
+
+// applyQuotas sets the resolvable quotas and clears the ones applied before
+// that are no longer wanted, including those of principals that have since
+// been deleted.
+func (r *ZDatasetReconciler) applyQuotas(ctx context.Context, na *NodeAgentClient, obj *nasv1.ZDataset) error {
+	users, missingUsers, err := resolveUserQuotas(ctx, r.Client, obj.Namespace, obj.Spec.UserQuotas)
+	if err != nil {
+		return err
+	}
+	groups, missingGroups, err := resolveGroupQuotas(ctx, r.Client, obj.Namespace, obj.Spec.GroupQuotas)
+	if err != nil {
+		return err
+	}
+	obj.Status.MissingQuotaPrincipals = append(missingUsers, missingGroups...)
+	userEntries := quotaEntries(users, obj.Status.UserQuotas)
+	groupEntries := quotaEntries(groups, obj.Status.GroupQuotas)
+	if len(userEntries)+len(groupEntries) == 0 {
+		return nil
+	}
+
+	body := map[string]any{
+		"dataset":     obj.Spec.DatasetName,
+		"userQuotas":  userEntries,
+		"groupQuotas": groupEntries,
+	}
+	var out quotaResponse
+	if err := na.do(ctx, "POST", "/v1/zfs/dataset/quotas", body, &out, nil); err != nil {
+		return err
+	}
+	fillQuotaUsage(users, out.Users)
+	fillQuotaUsage(groups, out.Groups)
+	obj.Status.UserQuotas = users
+	obj.Status.GroupQuotas = groups
+	return nil
+}
+
+func quotaReferences(quotas []nasv1.ZDatasetQuota, name string) bool {
+	for _, q := range quotas {
+		if strings.TrimSpace(q.Name) == name {
+			return true
+		}
+	}
+	return false
+}
@@ -16,6 +16,7 @@ import (
 
 type smbUser struct {
 	Username           string
+	UID                int64
 	PasswordSecretName string
 }
 
@@ -46,6 +47,7 @@ func resolveLocalUsers(ctx context.Context, c client.Client, ns string, director
 		}
 		users = append(users, smbUser{
 			Username:           username,
+			UID:                u.Spec.UID,
 			PasswordSecretName: secName,
 		})
 		smbNames = append(smbNames, username)
@@ -133,8 +135,13 @@ func buildUserScript(ctx context.Context, c client.Client, ns string, users []sm
 			pw = string(sec.StringData["password"])
 		}
 		enc := base64.StdEncoding.EncodeToString([]byte(pw))
+		// Pin the UID when known so files match ownership on the dataset.
+		add := fmt.Sprintf("adduser -D %s", u.Username)
+		if u.UID > 0 {
+			add = fmt.Sprintf("adduser -D -u %d %s", u.UID, u.Username)
+		}
 		lines = append(lines,
-			fmt.Sprintf("id -u %s >/dev/null 2>&1 || adduser -D %s", u.Username, u.Username),
+			fmt.Sprintf("id -u %s >/dev/null 2>&1 || %s", u.Username, add),
 			fmt.Sprintf("pw=$(echo %s | base64 -d)", enc),
 			fmt.Sprintf("printf '%%s\\n%%s\\n' \"$pw\" \"$pw\" | smbpasswd -a -s %s", u.Username),
 		)
@@ -188,11 +195,15 @@ func parseOptions(m map[string]any) smbconf.Options {
 	if v, ok := m["inheritPerms"].(bool); ok {
 		o.InheritPerms = &v
 	}
+	if v, ok := m["homes"].(bool); ok {
+		o.Homes = v
+	}
 
 	if se, ok := m["snapshotExposure"].(map[string]any); ok {
 		enabled, _ := se["enabled"].(bool)
 		mode, _ := se["mode"].(string)
 		format, _ := se["format"].(string)
+		tz, _ := se["timeZone"].(string)
 		var lt *bool
 		if b, ok := se["localTime"].(bool); ok {
 			lt = &b
@@ -202,6 +213,7 @@ func parseOptions(m map[string]any) smbconf.Options {
 			Mode:      mode,
 			Format:    format,
 			LocalTime: lt,
+			TimeZone:  strings.TrimSpace(tz),
 		}
 	}
 	if tm, ok := m["timeMachine"].(map[string]any); ok {
@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	nasv1 "mnemosyne/api/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	hookPolicySkip    = "Skip"
+	hookPolicyProceed = "Proceed"
+
+	defaultHookTimeout = 30 * time.Second
+	hookOutputLimit    = 1024
+)
+
+// hookOutcome is what happened around one snapshot.
+type hookOutcome struct {
+	Results []nasv1.SnapshotHookResult
+	PreErr  error
+	PostErr error
+	// Skipped is set when a failed pre hook prevented the snapshot.
+	Skipped bool
+}
+
+func validateSnapshotHooks(h *nasv1.SnapshotHooks) error {
+	if h == nil {
+		return nil
+	}
+	if p := strings.TrimSpace(h.OnPreHookFailure); p != "" && p != hookPolicySkip && p != hookPolicyProceed {
+		return fmt.Errorf("hooks.onPreHookFailure must be Skip or Proceed, not %q", p)
+	}
+	for i, hook := range append(append([]nasv1.SnapshotHook{}, h.Pre...), h.Post...) {
+		if len(hook.PodSelector) == 0 || len(hook.Command) == 0 {
+			return fmt.Errorf("hook %s needs podSelector and command", hookName(hook, i))
+		}
+	}
+	return nil
+}
+
+func hookTimeout(h *nasv1.SnapshotHooks) time.Duration {
+	if h != nil && h.TimeoutSeconds > 0 {
+		return time.Duration(h.TimeoutSeconds) * time.Second
+	}
+	return defaultHookTimeout
+}
+
+// runWithSnapshotHooks runs the pre hooks, take unless a pre hook failed under
+// the Skip policy, and then the post hooks, which run even if take fails so
+// a frozen application is always released. The error is take's.
+func runWithSnapshotHooks(ctx context.Context, c client.Client, cfg *rest.Config, ns string, hooks *nasv1.SnapshotHooks, take func() error) (hookOutcome, error) {
+	var out hookOutcome
+	if hooks == nil || len(hooks.Pre)+len(hooks.Post) == 0 {
+		return out, take()
+	}
+	timeout := hookTimeout(hooks)
+	out.Results, out.PreErr = runHookStage(ctx, c, cfg, ns, "pre", hooks.Pre, timeout)
+	var err error
+	if out.PreErr != nil && strings.TrimSpace(hooks.OnPreHookFailure) != hookPolicyProceed {
+		out.Skipped = true
+	} else {
+		err = take()
+	}
+	post, postErr := runHookStage(ctx, c, cfg, ns, "post", hooks.Post, timeout)
+	out.Results = append(out.Results, post...)
+	out.PostErr = postErr
+	return out, err
+}
+
+// runHookStage runs hooks in order in every matching pod. Pre hooks stop at
+// the first failure; post hooks all run and the first failure is returned.
+func runHookStage(ctx context.Context, c client.Client, cfg *rest.Config, ns, stage string, hooks []nasv1.SnapshotHook, timeout time.Duration) ([]nasv1.SnapshotHookResult, error) {
+	var results []nasv1.SnapshotHookResult
+	var first error
+	for i, hook := range hooks {
+		name := hookName(hook, i)
+		var pods corev1.PodList
+		err := c.List(ctx, &pods, client.InNamespace(ns), client.MatchingLabels(hook.PodSelector))
+		var running []corev1.Pod
+		for _, p := range pods.Items {
+			if p.Status.Phase == corev1.PodRunning && p.DeletionTimestamp.IsZero() {
+				running = append(running, p)
+			}
+		}
+		if err == nil && len(running) == 0 {
+			err = fmt.Errorf("no running pod in %s matches %v", ns, hook.PodSelector)
+		}
+		if err != nil {
+			results = append(results, nasv1.SnapshotHookResult{Hook: name, Stage: stage, Error: err.Error(), Time: time.Now().UTC().Format(time.RFC3339)})
+			if first == nil {
+				first = fmt.Errorf("%s hook %s: %w", stage, name, err)
+			}
+			if stage == "pre" {
+				return results, first
+			}
+			continue
+		}
+		for _, p := range running {
+			container := strings.TrimSpace(hook.Container)
+			if container == "" && len(p.Spec.Containers) > 0 {
+				container = p.Spec.Containers[0].Name
+			}
+			res := nasv1.SnapshotHookResult{Hook: name, Stage: stage, Pod: p.Name, Container: container, Time: time.Now().UTC().Format(time.RFC3339)}
+			output, code, err := execInPod(ctx, cfg, ns, p.Name, container, hook.Command, timeout)
+			res.Output = output
+			res.ExitCode = code
+			if err != nil {
+				res.Error = err.Error()
+				if first == nil {
+					first = fmt.Errorf("%s hook %s in pod %s: %w", stage, name, p.Name, err)
+				}
+			}
+			results = append(results, res)
+			if err != nil && stage == "pre" {
+				return results, first
+			}
+		}
+	}
+	return results, first
+}
+
+func hookName(hook nasv1.SnapshotHook, i int) string {
+	if n := strings.TrimSpace(hook.Name); n != "" {
+		return n
+	}
+	return fmt.Sprintf("#%d", i)
+}
+
+// execInPod runs command through the pods/exec subresource and returns the
+// tail of its combined output and its exit code.
+func execInPod(ctx context.Context, cfg *rest.Config, ns, pod, container string, command []string, timeout time.Duration) (string, int32, error) {
+	if cfg == nil {
+		return "", 0, errors.New("no REST config for pod exec")
+	}
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return "", 0, err
+	}
+	req := cs.CoreV1().RESTClient().Post().
+		Namespace(ns).Resource("pods").Name(pod).SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	exec, err := remotecommand.NewSPDYExecutor(cfg, "POST", req.URL())
+	if err != nil {
+		return "", 0, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var buf lockedBuffer
+	err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{Stdout: &buf, Stderr: &buf})
+	output := buf.tail(hookOutputLimit)
+	if ctx.Err() == context.DeadlineExceeded {
+		return output, 0, fmt.Errorf("timed out after %s", timeout)
+	}
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) {
+		return output, int32(exitErr.ExitStatus()), fmt.Errorf("exit code %d", exitErr.ExitStatus())
+	}
+	return output, 0, err
+}
+
+// lockedBuffer takes stdout and stderr, which are copied concurrently.
+type lockedBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (l *lockedBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.Write(p)
+}
+
+func (l *lockedBuffer) tail(n int) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.b.Bytes()
+	if len(b) > n {
+		b = b[len(b)-n:]
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	nasv1 "mnemosyne/api/v1alpha1"
+	"mnemosyne/internal/smbconf"
+	"mnemosyne/internal/strftime"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	defaultSnapshotPrefix = nasv1.DefaultSnapshotNamePrefix
+	defaultSnapshotFormat = nasv1.DefaultSnapshotFormat
+)
+
+// shadowSampleTime exercises every field of a format: no component is zero
+// or ambiguous between day and month.
+var shadowSampleTime = time.Date(2025, time.November, 30, 21, 58, 57, 0, time.UTC)
+
+var zfsSnapshotNameRe = regexp.MustCompile(`^[A-Za-z0-9_.: -]+$`)
+
+type snapshotNaming struct {
+	Prefix string
+	Format string
+	Loc    *time.Location
+}
+
+func scheduleNaming(spec nasv1.ZSnapshotScheduleSpec) (snapshotNaming, error) {
+	n := snapshotNaming{
+		Prefix: strings.TrimSpace(spec.NamePrefix),
+		Format: strings.TrimSpace(spec.Format),
+		Loc:    time.UTC,
+	}
+	if n.Prefix == "" {
+		n.Prefix = defaultSnapshotPrefix
+	}
+	if n.Format == "" {
+		n.Format = defaultSnapshotFormat
+	}
+	if err := strftime.Validate(n.Format); err != nil {
+		return n, err
+	}
+	if tz := strings.TrimSpace(spec.TimeZone); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return n, fmt.Errorf("invalid timeZone %q: %w", tz, err)
+		}
+		n.Loc = loc
+	}
+	// ZFS names allow alphanumerics plus "-_.: ". strftime.Validate accepts
+	// %z and %%, but they always render "+" or "%" and are refused here.
+	if name := n.name(shadowSampleTime); !zfsSnapshotNameRe.MatchString(name) {
+		return n, fmt.Errorf("namePrefix and format render %q, which is not a valid snapshot name", name)
+	}
+	return n, nil
+}
+
+func (n snapshotNaming) name(t time.Time) string {
+	return fmt.Sprintf("%s-%s", n.Prefix, strftime.Format(t.In(n.Loc), n.Format))
+}
+
+// repeats reports whether t falls in the hour repeated when a DST change
+// turns the clocks back and renders the same name as the instant one
+// offset-change earlier, i.e. a snapshot taken in the first pass through
+// that hour. Formats with %Z or %s tell the two apart and never repeat.
+func (n snapshotNaming) repeats(t time.Time) bool {
+	lt := t.In(n.Loc)
+	start, _ := lt.ZoneBounds()
+	if start.IsZero() {
+		return false
+	}
+	_, off := lt.Zone()
+	_, prevOff := start.Add(-time.Second).Zone()
+	shift := time.Duration(prevOff-off) * time.Second
+	if shift <= 0 || lt.Sub(start) >= shift {
+		return false
+	}
+	return n.name(t) == n.name(t.Add(-shift))
+}
+
+// exposedBy reports whether the share's shadow:format is meant for this
+// schedule's snapshots, i.e. it starts with the same prefix.
+func (n snapshotNaming) exposedBy(se *smbconf.SnapshotExposure) bool {
+	return se != nil && se.Enabled && strings.HasPrefix(se.Format, n.Prefix+"-")
+}
+
+// checkShadowFormat renders a sample name and requires shadow_copy2 to read
+// it back as the same time, taking shadow:localtime and the Samba TZ into
+// account.
+func (n snapshotNaming) checkShadowFormat(se *smbconf.SnapshotExposure) error {
+	shareLoc := time.UTC
+	if se.LocalTime == nil || *se.LocalTime {
+		if tz := strings.TrimSpace(se.TimeZone); tz != "" {
+			loc, err := time.LoadLocation(tz)
+			if err != nil {
+				return fmt.Errorf("invalid snapshotExposure.timeZone %q: %w", tz, err)
+			}
+			shareLoc = loc
+		}
+	}
+	name := n.name(shadowSampleTime)
+	t, err := strftime.Parse(se.Format, name, shareLoc)
+	if err != nil {
+		return fmt.Errorf("snapshot %s does not parse under shadow:format %q: %v", name, se.Format, err)
+	}
+	if got := n.name(t); got != name {
+		return fmt.Errorf("shadow:format %q reads %s as %s; align timeZone and localTime", se.Format, name, t.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// shareSnapshotExposure returns the parsed snapshotExposure of an SMB share
+// backed by a dataset, or nil.
+func shareSnapshotExposure(share *nasv1.NASShare) *smbconf.SnapshotExposure {
+	if !strings.EqualFold(strings.TrimSpace(share.Spec.Protocol), "smb") || strings.TrimSpace(share.Spec.DatasetName) == "" {
+		return nil
+	}
+	se := parseOptions(share.Spec.Options).SnapshotExposure
+	if se == nil || !se.Enabled {
+		return nil
+	}
+	return se
+}
+
+// checkScheduleShares validates a schedule against every SMB share in its
+// namespace that exposes its dataset's snapshots.
+func checkScheduleShares(ctx context.Context, c client.Client, sched *nasv1.ZSnapshotSchedule, n snapshotNaming) error {
+	var shares nasv1.NASShareList
+	if err := c.List(ctx, &shares, client.InNamespace(sched.Namespace)); err != nil {
+		return err
+	}
+	for i := range shares.Items {
+		share := &shares.Items[i]
+		if strings.TrimSpace(share.Spec.DatasetName) != strings.TrimSpace(sched.Spec.DatasetName) {
+			continue
+		}
+		se := shareSnapshotExposure(share)
+		if !n.exposedBy(se) {
+			continue
+		}
+		if err := n.checkShadowFormat(se); err != nil {
+			return fmt.Errorf("nasshare %s: %w", share.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkShareSchedules is the share-side counterpart of checkScheduleShares.
+func checkShareSchedules(ctx context.Context, c client.Client, share *nasv1.NASShare, se *smbconf.SnapshotExposure) error {
+	var schedules nasv1.ZSnapshotScheduleList
+	if err := c.List(ctx, &schedules, client.InNamespace(share.Namespace)); err != nil {
+		return err
+	}
+	for i := range schedules.Items {
+		sched := &schedules.Items[i]
+		if strings.TrimSpace(sched.Spec.DatasetName) != strings.TrimSpace(share.Spec.DatasetName) {
+			continue
+		}
+		n, err := scheduleNaming(sched.Spec)
+		if err != nil || !n.exposedBy(se) {
+			continue
+		}
+		if err := n.checkShadowFormat(se); err != nil {
+			return fmt.Errorf("zsnapshotschedule %s: %w", sched.Name, err)
+		}
+	}
+	return nil
+}
+
+// schedulesForShare and sharesForSchedule requeue the other side of a
+// dataset's snapshot naming when one side changes.
+func (r *ZSnapshotScheduleReconciler) schedulesForShare(ctx context.Context, obj client.Object) []reconcile.Request {
+	share, ok := obj.(*nasv1.NASShare)
+	if !ok {
+		return nil
+	}
+	var schedules nasv1.ZSnapshotScheduleList
+	if err := r.List(ctx, &schedules, client.InNamespace(share.Namespace)); err != nil {
+		return nil
+	}
+	var reqs []reconcile.Request
+	for _, s := range schedules.Items {
+		if strings.TrimSpace(s.Spec.DatasetName) == strings.TrimSpace(share.Spec.DatasetName) {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: s.Namespace, Name: s.Name}})
+		}
+	}
+	return reqs
+}
+
+func (r *NASShareReconciler) sharesForSchedule(ctx context.Context, obj client.Object) []reconcile.Request {
+	sched, ok := obj.(*nasv1.ZSnapshotSchedule)
+	if !ok {
+		return nil
+	}
+	var shares nasv1.NASShareList
+	if err := r.List(ctx, &shares, client.InNamespace(sched.Namespace)); err != nil {
+		return nil
+	}
+	var reqs []reconcile.Request
+	for _, s := range shares.Items {
+		if strings.TrimSpace(s.Spec.DatasetName) == strings.TrimSpace(sched.Spec.DatasetName) && shareSnapshotExposure(&s) != nil {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: s.Namespace, Name: s.Name}})
+		}
+	}
+	return reqs
+}
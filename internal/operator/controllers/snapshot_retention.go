@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	nasv1 "mnemosyne/api/v1alpha1"
+	"mnemosyne/internal/strftime"
+)
+
+type retentionSnapshot struct {
+	Name string
+	Time time.Time
+}
+
+// retentionActive reports whether any rule is set; without rules nothing is
+// ever pruned.
+func retentionActive(ret *nasv1.ZSnapshotScheduleRetention) bool {
+	if ret == nil {
+		return false
+	}
+	return ret.KeepLast > 0 || ret.KeepHourly > 0 || ret.KeepDaily > 0 ||
+		ret.KeepWeekly > 0 || ret.KeepMonthly > 0 || strings.TrimSpace(ret.KeepWithin) != ""
+}
+
+// parseRetentionDuration accepts Go durations plus whole days ("7d") and
+// weeks ("2w").
+func parseRetentionDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(s, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(s, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+	if unit > 0 {
+		n, err := strconv.Atoi(strings.TrimSpace(s[:len(s)-1]))
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * unit, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// managedSnapshots parses the timestamp of every <dataset>@<prefix>-<format>
+// snapshot, or snapshot family when recursive. Snapshots whose suffix does
+// not parse are left out, so they are never pruned.
+func managedSnapshots(items []string, ds string, n snapshotNaming, recursive bool) []retentionSnapshot {
+	var out []retentionSnapshot
+	for _, full := range filterManaged(items, ds, n.Prefix, recursive) {
+		suffix := strings.TrimPrefix(full[strings.Index(full, "@")+1:], n.Prefix+"-")
+		t, err := strftime.Parse(n.Format, suffix, n.Loc)
+		if err != nil {
+			continue
+		}
+		out = append(out, retentionSnapshot{Name: full, Time: t})
+	}
+	return out
+}
+
+// planRetention splits snapshots into kept and pruned according to ret as of
+// now. Pruned names are returned oldest first.
+func planRetention(snaps []retentionSnapshot, ret *nasv1.ZSnapshotScheduleRetention, keepWithin time.Duration, now time.Time) []string {
+	if !retentionActive(ret) {
+		return nil
+	}
+	sorted := append([]retentionSnapshot(nil), snaps...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.After(sorted[j].Time) })
+
+	keep := make([]bool, len(sorted))
+	for i := 0; i < len(sorted) && int64(i) < ret.KeepLast; i++ {
+		keep[i] = true
+	}
+	if keepWithin > 0 {
+		cutoff := now.Add(-keepWithin)
+		for i, s := range sorted {
+			if !s.Time.Before(cutoff) {
+				keep[i] = true
+			}
+		}
+	}
+	buckets := []struct {
+		limit int64
+		key   func(time.Time) string
+	}{
+		{ret.KeepHourly, func(t time.Time) string { return t.Format("2006-01-02T15") }},
+		{ret.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{ret.KeepWeekly, func(t time.Time) string {
+			y, w := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", y, w)
+		}},
+		{ret.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+	for _, b := range buckets {
+		if b.limit <= 0 {
+			continue
+		}
+		// Newest first, so the first snapshot seen in a bucket is its newest.
+		last := ""
+		var n int64
+		for i, s := range sorted {
+			if n >= b.limit {
+				break
+			}
+			if k := b.key(s.Time); k != last {
+				keep[i] = true
+				last = k
+				n++
+			}
+		}
+	}
+
+	var prune []string
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if !keep[i] {
+			prune = append(prune, sorted[i].Name)
+		}
+	}
+	return prune
+}
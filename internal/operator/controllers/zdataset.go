@@ -2,13 +2,18 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"strings"
 	"time"
 
 	nasv1 "mnemosyne/api/v1alpha1"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 type ZDatasetReconciler struct {
@@ -26,6 +31,30 @@ func (r *ZDatasetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	preset := strings.TrimSpace(obj.Spec.Preset)
 
 	na := NewNodeAgentClient(r.Cfg)
+
+	applied := strings.TrimSpace(obj.Status.AppliedDatasetName)
+	if applied != "" && applied != strings.TrimSpace(ds) {
+		if err := r.renameDataset(ctx, na, &obj, applied, strings.TrimSpace(ds)); err != nil {
+			obj.Status.Phase = "Error"
+			obj.Status.Message = fmt.Sprintf("rename %s -> %s: %v", applied, ds, err)
+			_ = r.Status().Update(ctx, &obj)
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+	}
+
+	obj.Status.Preset = nil
+	if preset != "" {
+		p, source, err := lookupDatasetPreset(ctx, r.Client, r.Cfg.Namespace, preset)
+		if err != nil {
+			obj.Status.Phase = "Error"
+			obj.Status.Message = err.Error()
+			_ = r.Status().Update(ctx, &obj)
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+		props, obj.Status.Preset = mergePresetProperties(strings.ToLower(preset), source, p, props)
+		preset = p.ACL
+	}
+
 	body := map[string]any{
 		"dataset":    ds,
 		"properties": props,
@@ -33,7 +62,12 @@ func (r *ZDatasetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	if preset != "" {
 		body["preset"] = preset
 	}
-	var out any
+	if obj.Spec.Owner != nil {
+		body["owner"] = obj.Spec.Owner
+	}
+	var out struct {
+		Mountpoint string `json:"mountpoint,omitempty"`
+	}
 	if err := na.do(ctx, "POST", "/v1/zfs/dataset/ensure", body, &out, nil); err != nil {
 		obj.Status.Phase = "Error"
 		obj.Status.Message = err.Error()
@@ -41,14 +75,234 @@ func (r *ZDatasetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
 	}
 
+	obj.Status.AppliedDatasetName = strings.TrimSpace(ds)
+	obj.Status.AppliedMountpoint = cleanMountpoint(out.Mountpoint)
+	if err := r.applyQuotas(ctx, na, &obj); err != nil {
+		obj.Status.Phase = "Error"
+		obj.Status.Message = fmt.Sprintf("quotas: %v", err)
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	resetting, err := r.applyACL(ctx, na, &obj)
+	if err != nil {
+		obj.Status.Phase = "Error"
+		obj.Status.Message = fmt.Sprintf("acl: %v", err)
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
 	obj.Status.Phase = "Ready"
 	obj.Status.Message = "OK"
+	if missing := obj.Status.MissingQuotaPrincipals; len(missing) > 0 {
+		obj.Status.Message = fmt.Sprintf("quotas skipped for missing %s", strings.Join(missing, ", "))
+	}
 	_ = r.Status().Update(ctx, &obj)
+	if resetting {
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
 	return ctrl.Result{RequeueAfter: 10 * time.Minute}, nil
 }
 
+// renameDataset renames (or reparents) the dataset on the node and rewrites
+// everything that still points at the old name: descendant ZDatasets and the
+// NASShares (including their NFS exports) that lived under the old mountpoint.
+func (r *ZDatasetReconciler) renameDataset(ctx context.Context, na *NodeAgentClient, obj *nasv1.ZDataset, from, to string) error {
+	body := map[string]any{
+		"source": from,
+		"target": to,
+	}
+	if mp := datasetProperty(obj.Spec.Properties, "mountpoint"); mp != "" {
+		body["mountpoint"] = mp
+	}
+	var out struct {
+		OK            bool   `json:"ok"`
+		OldMountpoint string `json:"oldMountpoint,omitempty"`
+		NewMountpoint string `json:"newMountpoint,omitempty"`
+	}
+	if err := na.do(ctx, "POST", "/v1/zfs/dataset/rename", body, &out, nil); err != nil {
+		return err
+	}
+
+	if err := r.renameChildDatasets(ctx, obj, from, to); err != nil {
+		return err
+	}
+	// A retried rename finds the source already gone and cannot report the
+	// old mountpoint; fall back to the one recorded by the last ensure.
+	oldMP, newMP := cleanMountpoint(out.OldMountpoint), cleanMountpoint(out.NewMountpoint)
+	if oldMP == "" {
+		oldMP = obj.Status.AppliedMountpoint
+	}
+	if err := r.retargetShares(ctx, na, obj.Namespace, from, to, oldMP, newMP); err != nil {
+		return err
+	}
+
+	obj.Status.AppliedDatasetName = to
+	obj.Status.AppliedMountpoint = newMP
+	obj.Status.Message = fmt.Sprintf("renamed from %s", from)
+	return r.Status().Update(ctx, obj)
+}
+
+func (r *ZDatasetReconciler) renameChildDatasets(ctx context.Context, obj *nasv1.ZDataset, from, to string) error {
+	var list nasv1.ZDatasetList
+	if err := r.List(ctx, &list, client.InNamespace(obj.Namespace)); err != nil {
+		return err
+	}
+	for i := range list.Items {
+		child := &list.Items[i]
+		if child.Name == obj.Name {
+			continue
+		}
+		name, ok := replaceDatasetPrefix(strings.TrimSpace(child.Spec.DatasetName), from, to)
+		if !ok {
+			continue
+		}
+		child.Spec.DatasetName = name
+		if err := r.Update(ctx, child); err != nil {
+			return fmt.Errorf("update zdataset %s: %w", child.Name, err)
+		}
+		// The node already moved the child along with its parent.
+		child.Status.AppliedDatasetName = name
+		_ = r.Status().Update(ctx, child)
+	}
+	return nil
+}
+
+func (r *ZDatasetReconciler) retargetShares(ctx context.Context, na *NodeAgentClient, ns, from, to, oldMP, newMP string) error {
+	var shares nasv1.NASShareList
+	if err := r.List(ctx, &shares, client.InNamespace(ns)); err != nil {
+		return err
+	}
+	for i := range shares.Items {
+		share := &shares.Items[i]
+		changed := false
+		if name, ok := replaceDatasetPrefix(strings.TrimSpace(share.Spec.DatasetName), from, to); ok {
+			share.Spec.DatasetName = name
+			changed = true
+		}
+		oldPath := strings.TrimSpace(share.Spec.MountPath)
+		newPath := oldPath
+		if oldMP != "" && newMP != "" && oldMP != newMP {
+			if p, ok := replaceDatasetPrefix(oldPath, oldMP, newMP); ok {
+				newPath = p
+			}
+		}
+		if newPath != oldPath {
+			share.Spec.MountPath = newPath
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		if newPath != oldPath && strings.EqualFold(strings.TrimSpace(share.Spec.Protocol), "nfs") {
+			// The new export goes up first, so a failure leaves the old one
+			// serving rather than no export at all.
+			if err := na.do(ctx, "POST", "/v1/nfs/export/ensure", nfsExportBody(share.Spec), nil, nil); err != nil {
+				return fmt.Errorf("ensure nfs export %s: %w", newPath, err)
+			}
+			if err := na.do(ctx, "POST", "/v1/nfs/export/delete", map[string]any{"path": oldPath}, nil, nil); err != nil {
+				return fmt.Errorf("remove nfs export %s: %w", oldPath, err)
+			}
+		}
+		if err := r.Update(ctx, share); err != nil {
+			return fmt.Errorf("update nasshare %s: %w", share.Name, err)
+		}
+	}
+	return nil
+}
+
+// replaceDatasetPrefix swaps a leading path component (dataset name or
+// mountpoint) from "from" to "to". It only matches on whole components.
+func replaceDatasetPrefix(name, from, to string) (string, bool) {
+	if name == "" || from == "" {
+		return name, false
+	}
+	if name == from {
+		return to, true
+	}
+	if strings.HasPrefix(name, from+"/") {
+		return to + strings.TrimPrefix(name, from), true
+	}
+	return name, false
+}
+
+func datasetProperty(props map[string]string, key string) string {
+	for k, v := range props {
+		if strings.EqualFold(strings.TrimSpace(k), key) {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+func cleanMountpoint(mp string) string {
+	mp = strings.TrimSpace(mp)
+	switch mp {
+	case "", "-", "none", "legacy":
+		return ""
+	}
+	return strings.TrimRight(mp, "/")
+}
+
 func (r *ZDatasetReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&nasv1.ZDataset{}).
+		Watches(&nasv1.NASUser{}, handler.EnqueueRequestsFromMapFunc(
+			func(ctx context.Context, obj client.Object) []reconcile.Request {
+				return r.datasetsForPrincipal(ctx, obj, func(ds *nasv1.ZDataset, name string) bool {
+					return quotaReferences(ds.Spec.UserQuotas, name) || aclReferences(ds.Spec.ACL, name, false)
+				})
+			}),
+		).
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(
+			func(ctx context.Context, obj client.Object) []reconcile.Request {
+				if obj.GetName() != datasetPresetsConfigMap || obj.GetNamespace() != r.Cfg.Namespace {
+					return nil
+				}
+				return r.datasetsWithPreset(ctx)
+			}),
+		).
+		Watches(&nasv1.NASGroup{}, handler.EnqueueRequestsFromMapFunc(
+			func(ctx context.Context, obj client.Object) []reconcile.Request {
+				return r.datasetsForPrincipal(ctx, obj, func(ds *nasv1.ZDataset, name string) bool {
+					return quotaReferences(ds.Spec.GroupQuotas, name) || aclReferences(ds.Spec.ACL, name, true)
+				})
+			}),
+		).
 		Complete(r)
 }
+
+func (r *ZDatasetReconciler) datasetsForPrincipal(ctx context.Context, obj client.Object, references func(*nasv1.ZDataset, string) bool) []reconcile.Request {
+	var list nasv1.ZDatasetList
+	if err := r.List(ctx, &list, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	var out []reconcile.Request
+	for i := range list.Items {
+		ds := &list.Items[i]
+		if references(ds, obj.GetName()) {
+			out = append(out, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: ds.Name, Namespace: ds.Namespace},
+			})
+		}
+	}
+	return out
+}
+
+func (r *ZDatasetReconciler) datasetsWithPreset(ctx context.Context) []reconcile.Request {
+	var list nasv1.ZDatasetList
+	if err := r.List(ctx, &list); err != nil {
+		return nil
+	}
+	var out []reconcile.Request
+	for i := range list.Items {
+		ds := &list.Items[i]
+		if strings.TrimSpace(ds.Spec.Preset) == "" {
+			continue
+		}
+		out = append(out, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: ds.Name, Namespace: ds.Namespace},
+		})
+	}
+	return out
+}
@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	nasv1 "mnemosyne/api/v1alpha1"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const zdatasetSnapshotFinalizer = "nas.io/zdatasetsnapshot-finalizer"
+
+type ZDatasetSnapshotReconciler struct {
+	client.Client
+	Cfg Config
+}
+
+type snapshotInfo struct {
+	Name            string `json:"name"`
+	Creation        int64  `json:"creation"`
+	UsedBytes       int64  `json:"usedBytes"`
+	ReferencedBytes int64  `json:"referencedBytes"`
+}
+
+type snapshotStatusResponse struct {
+	OK       bool          `json:"ok"`
+	Exists   bool          `json:"exists"`
+	Snapshot *snapshotInfo `json:"snapshot,omitempty"`
+}
+
+func (r *ZDatasetSnapshotReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var obj nasv1.ZDatasetSnapshot
+	if err := r.Get(ctx, req.NamespacedName, &obj); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	na := NewNodeAgentClient(r.Cfg)
+	full := datasetSnapshotName(&obj)
+	deleteOnRemove := strings.EqualFold(strings.TrimSpace(obj.Spec.DeletionPolicy), "Delete")
+
+	if !obj.DeletionTimestamp.IsZero() {
+		if slices.Contains(obj.Finalizers, zdatasetSnapshotFinalizer) {
+			// Only what the operator took is destroyed; an adopted snapshot
+			// (and, with recursive, its children) predates the object.
+			if deleteOnRemove && obj.Status.Snapshot != "" && obj.Status.Created {
+				if err := destroySnapshotIfExists(ctx, na, obj.Status.Snapshot, obj.Spec.Recursive); err != nil {
+					obj.Status.Phase = "Error"
+					obj.Status.Message = err.Error()
+					_ = r.Status().Update(ctx, &obj)
+					return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+				}
+			}
+			obj.Finalizers = slices.DeleteFunc(obj.Finalizers, func(n string) bool {
+				return n == zdatasetSnapshotFinalizer
+			})
+			_ = r.Update(ctx, &obj)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if full == "" {
+		obj.Status.Phase = "Error"
+		obj.Status.Message = "dataset is required"
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	// A snapshot cannot be renamed into another dataset; refuse rather than
+	// leave the old one behind.
+	if obj.Status.Snapshot != "" && obj.Status.Snapshot != full {
+		obj.Status.Phase = "Error"
+		obj.Status.Message = fmt.Sprintf("dataset and name are immutable once %s is taken", obj.Status.Snapshot)
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+
+	// The finalizer is only needed when deleting the object destroys the snapshot.
+	if deleteOnRemove != slices.Contains(obj.Finalizers, zdatasetSnapshotFinalizer) {
+		if deleteOnRemove {
+			obj.Finalizers = append(obj.Finalizers, zdatasetSnapshotFinalizer)
+		} else {
+			obj.Finalizers = slices.DeleteFunc(obj.Finalizers, func(n string) bool {
+				return n == zdatasetSnapshotFinalizer
+			})
+		}
+		if err := r.Update(ctx, &obj); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	st, err := getSnapshotStatus(ctx, na, full)
+	if err != nil {
+		obj.Status.Phase = "Error"
+		obj.Status.Message = err.Error()
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	if !st.Exists {
+		if obj.Status.Snapshot != "" {
+			// Taking it again would capture different data under the same name.
+			obj.Status.Phase = "Missing"
+			obj.Status.Message = fmt.Sprintf("%s was destroyed outside the operator", full)
+			_ = r.Status().Update(ctx, &obj)
+			return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+		}
+		at := strings.Index(full, "@")
+		body := map[string]any{"dataset": full[:at], "name": full[at+1:], "recursive": obj.Spec.Recursive}
+		if err := na.do(ctx, "POST", "/v1/zfs/snapshot/create", body, nil, nil); err != nil {
+			obj.Status.Phase = "Error"
+			obj.Status.Message = err.Error()
+			_ = r.Status().Update(ctx, &obj)
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+		if st, err = getSnapshotStatus(ctx, na, full); err != nil || !st.Exists {
+			obj.Status.Phase = "Error"
+			obj.Status.Message = fmt.Sprintf("%s not found after create", full)
+			_ = r.Status().Update(ctx, &obj)
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+		obj.Status.Created = true
+	}
+
+	obj.Status.Snapshot = full
+	if info := st.Snapshot; info != nil {
+		obj.Status.CreationTime = time.Unix(info.Creation, 0).UTC().Format(time.RFC3339)
+		obj.Status.UsedBytes = info.UsedBytes
+		obj.Status.ReferencedBytes = info.ReferencedBytes
+	}
+	obj.Status.Phase = "Ready"
+	obj.Status.Message = "OK"
+	if !obj.Status.Created {
+		obj.Status.Message = "adopted an existing snapshot; it is kept when this object is deleted"
+	}
+	_ = r.Status().Update(ctx, &obj)
+	// used grows as the dataset diverges; refresh it now and then.
+	return ctrl.Result{RequeueAfter: 10 * time.Minute}, nil
+}
+
+func datasetSnapshotName(obj *nasv1.ZDatasetSnapshot) string {
+	ds := strings.Trim(strings.TrimSpace(obj.Spec.Dataset), "/")
+	if ds == "" {
+		return ""
+	}
+	name := strings.TrimSpace(obj.Spec.Name)
+	if name == "" {
+		name = obj.Name
+	}
+	return ds + "@" + name
+}
+
+func getSnapshotStatus(ctx context.Context, na *NodeAgentClient, snap string) (snapshotStatusResponse, error) {
+	var out snapshotStatusResponse
+	q := make(url.Values)
+	q.Set("snapshot", snap)
+	err := na.do(ctx, "GET", "/v1/zfs/snapshot/status", nil, &out, q)
+	return out, err
+}
+
+func destroySnapshotIfExists(ctx context.Context, na *NodeAgentClient, snap string, recursive bool) error {
+	st, err := getSnapshotStatus(ctx, na, snap)
+	if err != nil {
+		return err
+	}
+	if !st.Exists && !recursive {
+		return nil
+	}
+	// A recursive destroy also clears children whose parent snapshot is gone.
+	return na.do(ctx, "POST", "/v1/zfs/snapshot/destroy", map[string]any{"snapshot": snap, "recursive": recursive}, nil, nil)
+}
+
+func (r *ZDatasetSnapshotReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&nasv1.ZDatasetSnapshot{}).
+		Complete(r)
+}
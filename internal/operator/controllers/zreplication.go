@@ -0,0 +1,516 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	nasv1 "mnemosyne/api/v1alpha1"
+
+	cron "github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// replicationTimeFormat ends every replication snapshot name, which starts
+// with replicationSnapshotPrefix, so both sides can be pruned by name alone.
+const replicationTimeFormat = "20060102-150405"
+
+const zreplicationFinalizer = "nas.io/zreplication-finalizer"
+
+type ZReplicationReconciler struct {
+	client.Client
+	Cfg Config
+}
+
+type replicationTargetResponse struct {
+	OK        bool     `json:"ok"`
+	Exists    bool     `json:"exists"`
+	Snapshots []string `json:"snapshots,omitempty"`
+}
+
+type replicationResponse struct {
+	OK          bool `json:"ok"`
+	Replication *struct {
+		State   string `json:"state"`
+		Message string `json:"message,omitempty"`
+		Bytes   int64  `json:"bytes"`
+	} `json:"replication,omitempty"`
+}
+
+func (r *ZReplicationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var obj nasv1.ZReplication
+	if err := r.Get(ctx, req.NamespacedName, &obj); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	spec := obj.Spec
+
+	if !obj.DeletionTimestamp.IsZero() {
+		if slices.Contains(obj.Finalizers, zreplicationFinalizer) {
+			if err := r.cleanupReplication(ctx, &obj); err != nil {
+				obj.Status.Phase = "Error"
+				obj.Status.Message = err.Error()
+				_ = r.Status().Update(ctx, &obj)
+				return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+			}
+			obj.Finalizers = slices.DeleteFunc(obj.Finalizers, func(n string) bool {
+				return n == zreplicationFinalizer
+			})
+			_ = r.Update(ctx, &obj)
+		}
+		return ctrl.Result{}, nil
+	}
+	if !slices.Contains(obj.Finalizers, zreplicationFinalizer) {
+		obj.Finalizers = append(obj.Finalizers, zreplicationFinalizer)
+		if err := r.Update(ctx, &obj); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	fail := func(msg string) (ctrl.Result, error) {
+		obj.Status.Phase = "Error"
+		obj.Status.Message = msg
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+	if p := strings.TrimSpace(spec.DeletionPolicy); p != "" && p != "Retain" && p != "Delete" {
+		return fail(fmt.Sprintf("deletionPolicy must be Retain or Delete, not %q", p))
+	}
+	src, tgt := spec.Source, spec.Target
+	src.Dataset = strings.Trim(strings.TrimSpace(src.Dataset), "/")
+	tgt.Dataset = strings.Trim(strings.TrimSpace(tgt.Dataset), "/")
+	if strings.TrimSpace(src.NodeName) == "" || src.Dataset == "" || strings.TrimSpace(tgt.NodeName) == "" || tgt.Dataset == "" {
+		return fail("source and target nodeName and dataset required")
+	}
+	if strings.Contains(src.Dataset, "@") || strings.Contains(tgt.Dataset, "@") {
+		return fail("source and target must be datasets, not snapshots")
+	}
+	if strings.TrimSpace(src.NodeName) == strings.TrimSpace(tgt.NodeName) && src.Dataset == tgt.Dataset {
+		return fail("source and target are the same dataset")
+	}
+	ret := spec.Retention
+	keepWithin := time.Duration(0)
+	if ret != nil {
+		d, err := parseRetentionDuration(ret.KeepWithin)
+		if err != nil {
+			return fail(fmt.Sprintf("retention.keepWithin: %v", err))
+		}
+		keepWithin = d
+	}
+	parser := cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
+	parsed, err := parser.Parse(strings.TrimSpace(spec.Schedule))
+	if err != nil {
+		return fail("invalid schedule")
+	}
+
+	srcNA, err := r.nodeAgent(ctx, src.NodeName)
+	if err != nil {
+		return fail(fmt.Sprintf("source node-agent: %v", err))
+	}
+	tgtNA, err := r.nodeAgent(ctx, tgt.NodeName)
+	if err != nil {
+		return fail(fmt.Sprintf("target node-agent: %v", err))
+	}
+
+	now := time.Now().UTC()
+	if obj.Status.Transfer != nil {
+		res := r.pollTransfer(ctx, &obj, srcNA, tgtNA, src.Dataset, tgt.Dataset, ret, keepWithin, now)
+		updateReplicationLag(&obj, now)
+		_ = r.Status().Update(ctx, &obj)
+		return res, nil
+	}
+
+	manual := false
+	if v := strings.TrimSpace(obj.Annotations[runNowAnnotation]); v != "" && v != obj.Status.LastManualTrigger {
+		obj.Status.LastManualTrigger = v
+		manual = true
+	}
+	var next time.Time
+	due := false
+	if spec.Suspend {
+		obj.Status.NextRunTime = ""
+	} else {
+		next = parsed.Next(now)
+		obj.Status.NextRunTime = next.Format(time.RFC3339)
+		last, _ := time.Parse(time.RFC3339, obj.Status.LastScheduleTime)
+		// Missed ticks collapse into one run; the increment covers them all.
+		if latest, missed := dueTicks(parsed, last, now); missed > 0 {
+			obj.Status.LastScheduleTime = latest.Format(time.RFC3339)
+			due = true
+		}
+	}
+
+	if due || manual {
+		if err := r.startTransfer(ctx, &obj, srcNA, tgtNA, src.Dataset, tgt.Dataset, now); err != nil {
+			recordReplicationFailure(&obj, err.Error(), now)
+		} else {
+			obj.Status.Phase = "Replicating"
+			obj.Status.Message = fmt.Sprintf("sending %s", obj.Status.Transfer.Snapshot)
+			updateReplicationLag(&obj, now)
+			_ = r.Status().Update(ctx, &obj)
+			return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+		}
+	} else if obj.Status.Phase == "" {
+		obj.Status.Phase = "Idle"
+	}
+	if spec.Suspend && obj.Status.Phase != "Error" {
+		obj.Status.Message = "suspended"
+	}
+	updateReplicationLag(&obj, now)
+	_ = r.Status().Update(ctx, &obj)
+
+	if spec.Suspend {
+		// Resuming or annotating the replication triggers the next reconcile.
+		return ctrl.Result{}, nil
+	}
+	wait := time.Until(next)
+	if wait < 5*time.Second {
+		wait = 5 * time.Second
+	}
+	if wait > 2*time.Minute {
+		wait = 2 * time.Minute
+	}
+	return ctrl.Result{RequeueAfter: wait}, nil
+}
+
+// startTransfer snapshots the source and starts streaming it to the target,
+// incrementally from the newest replication snapshot both sides have.
+func (r *ZReplicationReconciler) startTransfer(ctx context.Context, obj *nasv1.ZReplication, srcNA, tgtNA *NodeAgentClient, srcDS, tgtDS string, now time.Time) error {
+	prefix := replicationSnapshotPrefix(obj)
+	srcSnaps, err := replicationSourceSnapshots(ctx, srcNA, srcDS, prefix)
+	if err != nil {
+		return err
+	}
+	var target replicationTargetResponse
+	q := make(url.Values)
+	q.Set("dataset", tgtDS)
+	if err := tgtNA.do(ctx, "GET", "/v1/zfs/replication/target", nil, &target, q); err != nil {
+		return fmt.Errorf("target: %w", err)
+	}
+	base := ""
+	if target.Exists {
+		onTarget := map[string]bool{}
+		for _, s := range target.Snapshots {
+			onTarget[s] = true
+		}
+		for i := len(srcSnaps) - 1; i >= 0; i-- {
+			if onTarget[srcSnaps[i].Name] {
+				base = srcSnaps[i].Name
+				break
+			}
+		}
+		if base == "" {
+			return fmt.Errorf("target %s exists but has no snapshot in common with %s; remove it or choose another target", tgtDS, srcDS)
+		}
+	}
+
+	snapTime := now.Truncate(time.Second)
+	name := prefix + snapTime.Format(replicationTimeFormat)
+	if base == name {
+		return fmt.Errorf("%s@%s is already replicated", srcDS, name)
+	}
+	if !slices.ContainsFunc(srcSnaps, func(s retentionSnapshot) bool { return s.Name == name }) {
+		body := map[string]any{"dataset": srcDS, "name": name, "recursive": obj.Spec.Recursive}
+		if err := srcNA.do(ctx, "POST", "/v1/zfs/snapshot/create", body, nil, nil); err != nil {
+			return fmt.Errorf("snapshot source: %w", err)
+		}
+	}
+
+	token, err := randomHex(16)
+	if err != nil {
+		return err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return err
+	}
+	recv := map[string]any{"token": token, "secret": secret, "dataset": tgtDS, "base": base}
+	if err := tgtNA.do(ctx, "POST", "/v1/zfs/replication/receive/prepare", recv, nil, nil); err != nil {
+		return fmt.Errorf("prepare receive: %w", err)
+	}
+	send := map[string]any{
+		"token":     token,
+		"secret":    secret,
+		"snapshot":  srcDS + "@" + name,
+		"recursive": obj.Spec.Recursive,
+		"raw":       obj.Spec.Raw,
+		"targetURL": tgtNA.BaseURL,
+	}
+	if base != "" {
+		send["base"] = srcDS + "@" + base
+	}
+	var out replicationResponse
+	if err := srcNA.do(ctx, "POST", "/v1/zfs/replication/send", send, &out, nil); err != nil {
+		return fmt.Errorf("start send: %w", err)
+	}
+	obj.Status.Transfer = &nasv1.ZReplicationTransfer{
+		Token:        token,
+		Snapshot:     srcDS + "@" + name,
+		SnapshotTime: snapTime.Format(time.RFC3339),
+		Started:      now.Format(time.RFC3339),
+	}
+	if base != "" {
+		obj.Status.Transfer.Base = srcDS + "@" + base
+	}
+	return nil
+}
+
+// pollTransfer follows the stream in flight and, once it has finished,
+// records the result and prunes both sides.
+func (r *ZReplicationReconciler) pollTransfer(ctx context.Context, obj *nasv1.ZReplication, srcNA, tgtNA *NodeAgentClient, srcDS, tgtDS string, ret *nasv1.ZSnapshotScheduleRetention, keepWithin time.Duration, now time.Time) ctrl.Result {
+	tr := obj.Status.Transfer
+	var out replicationResponse
+	q := make(url.Values)
+	q.Set("token", tr.Token)
+	if err := srcNA.do(ctx, "GET", "/v1/zfs/replication/status", nil, &out, q); err != nil || out.Replication == nil {
+		if err == nil {
+			err = fmt.Errorf("no status")
+		}
+		// The source node-agent restarted or is unreachable; the stream
+		// cannot be resumed, so the next tick starts over.
+		obj.Status.Transfer = nil
+		recordReplicationFailure(obj, fmt.Sprintf("transfer of %s lost: %v", tr.Snapshot, err), now)
+		return ctrl.Result{RequeueAfter: time.Minute}
+	}
+	tr.Bytes = out.Replication.Bytes
+	switch out.Replication.State {
+	case "Complete":
+	case "Failed":
+		obj.Status.Transfer = nil
+		recordReplicationFailure(obj, fmt.Sprintf("transfer of %s failed: %s", tr.Snapshot, out.Replication.Message), now)
+		return ctrl.Result{RequeueAfter: time.Minute}
+	default:
+		obj.Status.Phase = "Replicating"
+		obj.Status.Message = fmt.Sprintf("sending %s (%d bytes)", tr.Snapshot, tr.Bytes)
+		return ctrl.Result{RequeueAfter: 10 * time.Second}
+	}
+
+	obj.Status.Transfer = nil
+	obj.Status.LastReplicatedSnapshot = tr.Snapshot
+	obj.Status.LastReplicatedTime = tr.SnapshotTime
+	obj.Status.LastSuccessTime = now.Format(time.RFC3339)
+	obj.Status.LastBytesTransferred = tr.Bytes
+	obj.Status.TotalBytesTransferred += tr.Bytes
+	obj.Status.ConsecutiveFailures = 0
+	obj.Status.Phase = "Idle"
+	obj.Status.Message = fmt.Sprintf("replicated %s", tr.Snapshot)
+
+	pruned, errs := r.pruneReplication(ctx, obj, srcNA, tgtNA, srcDS, tgtDS, tr.Snapshot, ret, keepWithin, now)
+	obj.Status.LastPruned = pruned
+	if len(errs) > 0 {
+		obj.Status.Message += "; prune failed: " + strings.Join(errs, "; ")
+	}
+	return ctrl.Result{RequeueAfter: 5 * time.Second}
+}
+
+// pruneReplication keeps only the new base on the source and applies
+// retention to the target, never touching the base or held snapshots.
+func (r *ZReplicationReconciler) pruneReplication(ctx context.Context, obj *nasv1.ZReplication, srcNA, tgtNA *NodeAgentClient, srcDS, tgtDS, latest string, ret *nasv1.ZSnapshotScheduleRetention, keepWithin time.Duration, now time.Time) (pruned, errs []string) {
+	prefix := replicationSnapshotPrefix(obj)
+	_, latestName, _ := strings.Cut(latest, "@")
+	recursive := obj.Spec.Recursive
+
+	srcSnaps, err := replicationSourceSnapshots(ctx, srcNA, srcDS, prefix)
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	for _, s := range srcSnaps {
+		if s.Name == latestName {
+			continue
+		}
+		full := srcDS + "@" + s.Name
+		if err := srcNA.do(ctx, "POST", "/v1/zfs/snapshot/destroy", map[string]any{"snapshot": full, "recursive": recursive}, nil, nil); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", full, err))
+		}
+	}
+
+	if !retentionActive(ret) {
+		return nil, errs
+	}
+	var target replicationTargetResponse
+	q := make(url.Values)
+	q.Set("dataset", tgtDS)
+	if err := tgtNA.do(ctx, "GET", "/v1/zfs/replication/target", nil, &target, q); err != nil {
+		return nil, append(errs, fmt.Sprintf("list target snapshots: %v", err))
+	}
+	held, err := heldSnapshots(ctx, tgtNA, tgtDS, recursive)
+	if err != nil {
+		return nil, append(errs, fmt.Sprintf("list target holds: %v", err))
+	}
+	var snaps []retentionSnapshot
+	for _, name := range target.Snapshots {
+		if t, ok := replicationSnapshotTime(name, prefix); ok {
+			snaps = append(snaps, retentionSnapshot{Name: name, Time: t})
+		}
+	}
+	for _, name := range planRetention(snaps, ret, keepWithin, now) {
+		full := tgtDS + "@" + name
+		if name == latestName || held[full] {
+			continue
+		}
+		if err := tgtNA.do(ctx, "POST", "/v1/zfs/snapshot/destroy", map[string]any{"snapshot": full, "recursive": recursive}, nil, nil); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", full, err))
+			continue
+		}
+		pruned = append(pruned, full)
+	}
+	return pruned, errs
+}
+
+// cleanupReplication destroys the replication's snapshots on the source and,
+// with deletionPolicy Delete, on the target. A node or dataset that no longer
+// exists has nothing left to clean up.
+func (r *ZReplicationReconciler) cleanupReplication(ctx context.Context, obj *nasv1.ZReplication) error {
+	type side struct {
+		what     string
+		nodeName string
+		dataset  string
+	}
+	sides := []side{{"source", obj.Spec.Source.NodeName, obj.Spec.Source.Dataset}}
+	if strings.TrimSpace(obj.Spec.DeletionPolicy) == "Delete" {
+		sides = append(sides, side{"target", obj.Spec.Target.NodeName, obj.Spec.Target.Dataset})
+	}
+	prefix := replicationSnapshotPrefix(obj)
+	for _, sd := range sides {
+		ds := strings.Trim(strings.TrimSpace(sd.dataset), "/")
+		if strings.TrimSpace(sd.nodeName) == "" || ds == "" || strings.Contains(ds, "@") {
+			continue
+		}
+		na, err := r.nodeAgent(ctx, sd.nodeName)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s node-agent: %w", sd.what, err)
+		}
+		var list replicationTargetResponse
+		q := make(url.Values)
+		q.Set("dataset", ds)
+		if err := na.do(ctx, "GET", "/v1/zfs/replication/target", nil, &list, q); err != nil {
+			return fmt.Errorf("list %s snapshots: %w", sd.what, err)
+		}
+		if !list.Exists {
+			continue
+		}
+		held, err := heldSnapshots(ctx, na, ds, obj.Spec.Recursive)
+		if err != nil {
+			return fmt.Errorf("list %s holds: %w", sd.what, err)
+		}
+		for _, name := range list.Snapshots {
+			full := ds + "@" + name
+			if _, ok := replicationSnapshotTime(name, prefix); !ok || held[full] {
+				continue
+			}
+			if err := na.do(ctx, "POST", "/v1/zfs/snapshot/destroy", map[string]any{"snapshot": full, "recursive": obj.Spec.Recursive}, nil, nil); err != nil {
+				return fmt.Errorf("destroy %s: %w", full, err)
+			}
+		}
+	}
+	return nil
+}
+
+// nodeAgent returns a client for the node-agent on nodeName.
+func (r *ZReplicationReconciler) nodeAgent(ctx context.Context, nodeName string) (*NodeAgentClient, error) {
+	nodeName = strings.TrimSpace(nodeName)
+	na := NewNodeAgentClient(r.Cfg)
+	if u := r.Cfg.NodeAgentURLs[nodeName]; u != "" {
+		na.BaseURL = strings.TrimRight(u, "/")
+		return na, nil
+	}
+	var node corev1.Node
+	if err := r.Get(ctx, client.ObjectKey{Name: nodeName}, &node); err != nil {
+		return nil, err
+	}
+	port := r.Cfg.NodeAgentPort
+	if port == 0 {
+		port = 9808
+	}
+	for _, a := range node.Status.Addresses {
+		if a.Type == corev1.NodeInternalIP {
+			na.BaseURL = "http://" + net.JoinHostPort(a.Address, strconv.Itoa(port))
+			return na, nil
+		}
+	}
+	return nil, fmt.Errorf("node %s has no InternalIP", nodeName)
+}
+
+// replicationSnapshotPrefix keeps the snapshots of different replications
+// of one dataset apart.
+func replicationSnapshotPrefix(obj *nasv1.ZReplication) string {
+	return "repl-" + obj.Name + "-"
+}
+
+func replicationSnapshotTime(name, prefix string) (time.Time, bool) {
+	rest, ok := strings.CutPrefix(name, prefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(replicationTimeFormat, rest)
+	return t, err == nil
+}
+
+// replicationSourceSnapshots lists the replication's snapshots of ds (not
+// its children), oldest first.
+func replicationSourceSnapshots(ctx context.Context, na *NodeAgentClient, ds, prefix string) ([]retentionSnapshot, error) {
+	var list struct {
+		OK    bool     `json:"ok"`
+		Items []string `json:"items"`
+	}
+	q := make(url.Values)
+	q.Set("dataset", ds)
+	if err := na.do(ctx, "GET", "/v1/zfs/snapshot/list", nil, &list, q); err != nil {
+		return nil, fmt.Errorf("list source snapshots: %w", err)
+	}
+	var snaps []retentionSnapshot
+	for _, full := range list.Items {
+		owner, name, ok := strings.Cut(full, "@")
+		if !ok || owner != ds {
+			continue
+		}
+		if t, ok := replicationSnapshotTime(name, prefix); ok {
+			snaps = append(snaps, retentionSnapshot{Name: name, Time: t})
+		}
+	}
+	slices.SortFunc(snaps, func(a, b retentionSnapshot) int { return a.Time.Compare(b.Time) })
+	return snaps, nil
+}
+
+func recordReplicationFailure(obj *nasv1.ZReplication, msg string, now time.Time) {
+	obj.Status.Phase = "Error"
+	obj.Status.Message = msg
+	obj.Status.LastError = msg
+	obj.Status.LastErrorTime = now.Format(time.RFC3339)
+	obj.Status.ConsecutiveFailures++
+}
+
+func updateReplicationLag(obj *nasv1.ZReplication, now time.Time) {
+	t, err := time.Parse(time.RFC3339, obj.Status.LastReplicatedTime)
+	if err != nil {
+		obj.Status.Lag = ""
+		return
+	}
+	obj.Status.Lag = now.Sub(t).Round(time.Second).String()
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (r *ZReplicationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&nasv1.ZReplication{}).
+		Complete(r)
+}
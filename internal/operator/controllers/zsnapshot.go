@@ -3,22 +3,37 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	nasv1 "mnemosyne/api/v1alpha1"
 
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 // ZSnapshot creates a CSI VolumeSnapshot for a PVC.
 
+const (
+	zsnapshotFinalizer = "nas.io/zsnapshot-finalizer"
+	// zsnapshotLabel names the ZSnapshot a VolumeSnapshot was created for.
+	zsnapshotLabel = "nas.io/zsnapshot"
+)
+
 type ZSnapshotReconciler struct {
 	client.Client
 	Cfg Config
+	// RESTConfig is used to exec snapshot hooks in pods.
+	RESTConfig *rest.Config
 }
 
 var volumeSnapshotGVK = schema.GroupVersionKind{Group: "snapshot.storage.k8s.io", Version: "v1", Kind: "VolumeSnapshot"}
@@ -29,6 +44,34 @@ func (r *ZSnapshotReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	// Desired VolumeSnapshot name: <zsnapshot name>
+	vsName := req.Name
+	vs := &unstructured.Unstructured{}
+	vs.SetGroupVersionKind(volumeSnapshotGVK)
+	vs.SetNamespace(req.Namespace)
+	vs.SetName(vsName)
+
+	policy := strings.TrimSpace(obj.Spec.DeletionPolicy)
+	deleteOnRemove := !strings.EqualFold(policy, "Retain")
+
+	if !obj.DeletionTimestamp.IsZero() {
+		if slices.Contains(obj.Finalizers, zsnapshotFinalizer) {
+			if deleteOnRemove {
+				if err := r.deleteVolumeSnapshot(ctx, &obj, vs); err != nil {
+					obj.Status.Phase = "Error"
+					obj.Status.Message = err.Error()
+					_ = r.Status().Update(ctx, &obj)
+					return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+				}
+			}
+			obj.Finalizers = slices.DeleteFunc(obj.Finalizers, func(n string) bool {
+				return n == zsnapshotFinalizer
+			})
+			_ = r.Update(ctx, &obj)
+		}
+		return ctrl.Result{}, nil
+	}
+
 	pvcName := obj.Spec.PVCName
 	if pvcName == "" {
 		obj.Status.Phase = "Pending"
@@ -36,52 +79,213 @@ func (r *ZSnapshotReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		_ = r.Status().Update(ctx, &obj)
 		return ctrl.Result{RequeueAfter: 20 * time.Second}, nil
 	}
+	if policy != "" && !strings.EqualFold(policy, "Delete") && !strings.EqualFold(policy, "Retain") {
+		obj.Status.Phase = "Failed"
+		obj.Status.Message = fmt.Sprintf("deletionPolicy must be Delete or Retain, not %q", policy)
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+
+	if err := validateSnapshotHooks(obj.Spec.Hooks); err != nil {
+		obj.Status.Phase = "Failed"
+		obj.Status.Message = err.Error()
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+
+	// The finalizer is only needed when deleting the object deletes the
+	// VolumeSnapshot.
+	if deleteOnRemove != slices.Contains(obj.Finalizers, zsnapshotFinalizer) {
+		if deleteOnRemove {
+			obj.Finalizers = append(obj.Finalizers, zsnapshotFinalizer)
+		} else {
+			obj.Finalizers = slices.DeleteFunc(obj.Finalizers, func(n string) bool {
+				return n == zsnapshotFinalizer
+			})
+		}
+		if err := r.Update(ctx, &obj); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
 
 	snapClass := obj.Spec.SnapshotClassName
 	if snapClass == "" {
 		snapClass = "nas-zfspv-snapclass"
 	}
 
-	// Desired VolumeSnapshot name: <zsnapshot name>
-	vsName := req.Name
-
-	// Create/patch VolumeSnapshot
-	vs := &unstructured.Unstructured{}
-	vs.SetGroupVersionKind(volumeSnapshotGVK)
-	vs.SetNamespace(req.Namespace)
-	vs.SetName(vsName)
-
 	mutate := func() error {
+		labels := vs.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[zsnapshotLabel] = obj.Name
+		vs.SetLabels(labels)
 		// spec
 		_ = unstructured.SetNestedField(vs.Object, snapClass, "spec", "volumeSnapshotClassName")
 		_ = unstructured.SetNestedField(vs.Object, map[string]any{"persistentVolumeClaimName": pvcName}, "spec", "source")
 		return nil
 	}
 
-	op, err := controllerutil.CreateOrPatch(ctx, r.Client, vs, mutate)
-	if err != nil {
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(volumeSnapshotGVK)
+	err := r.Get(ctx, client.ObjectKeyFromObject(vs), existing)
+	switch {
+	case errors.IsNotFound(err) && obj.Status.CreationTime != "":
+		// Taking it again would capture different data under the same name.
+		obj.Status.Phase = "Missing"
+		obj.Status.Message = fmt.Sprintf("VolumeSnapshot %s was deleted outside the operator", vsName)
+		obj.Status.ReadyToUse = false
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{}, nil
+	case errors.IsNotFound(err):
+		hooks := obj.Spec.Hooks
+		if hooks != nil && len(hooks.Pre)+len(hooks.Post) > 0 {
+			// Hooks run once, around the create; a failed attempt is not
+			// retried so the application is not quiesced over and over.
+			if obj.Status.Phase == "Failed" && len(obj.Status.HookResults) > 0 {
+				return ctrl.Result{}, nil
+			}
+			return r.snapshotWithHooks(ctx, &obj, vs, mutate)
+		}
+	case err != nil:
+		obj.Status.Phase = "Failed"
+		obj.Status.Message = fmt.Sprintf("get VolumeSnapshot: %v", err)
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	if _, err := controllerutil.CreateOrPatch(ctx, r.Client, vs, mutate); err != nil {
 		obj.Status.Phase = "Failed"
 		obj.Status.Message = fmt.Sprintf("create VolumeSnapshot: %v", err)
 		_ = r.Status().Update(ctx, &obj)
 		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
 	}
 
-	// Read status.readyToUse
-	ready, _, _ := unstructured.NestedBool(vs.Object, "status", "readyToUse")
-	if ready {
-		obj.Status.Phase = "Succeeded"
-		obj.Status.Message = "Ready"
-	} else {
+	// Status changes on the VolumeSnapshot trigger the next reconcile.
+	mirrorVolumeSnapshotStatus(&obj, vs)
+	_ = r.Status().Update(ctx, &obj)
+	return ctrl.Result{}, nil
+}
+
+// mirrorVolumeSnapshotStatus copies the VolumeSnapshot's status into the
+// ZSnapshot and derives the phase from it. CSI errors are often transient
+// and retried by the snapshotter, so Error is not terminal.
+func mirrorVolumeSnapshotStatus(obj *nasv1.ZSnapshot, vs *unstructured.Unstructured) {
+	st := &obj.Status
+	st.VolumeSnapshotName = vs.GetName()
+	st.ReadyToUse, _, _ = unstructured.NestedBool(vs.Object, "status", "readyToUse")
+	st.CreationTime, _, _ = unstructured.NestedString(vs.Object, "status", "creationTime")
+	st.BoundVolumeSnapshotContentName, _, _ = unstructured.NestedString(vs.Object, "status", "boundVolumeSnapshotContentName")
+	st.Error, _, _ = unstructured.NestedString(vs.Object, "status", "error", "message")
+	st.RestoreSize = ""
+	if v, ok, _ := unstructured.NestedFieldNoCopy(vs.Object, "status", "restoreSize"); ok && v != nil {
+		st.RestoreSize = fmt.Sprint(v)
+	}
+	switch {
+	case st.ReadyToUse:
+		st.Phase = "Succeeded"
+		st.Message = "Ready"
+	case st.Error != "":
+		st.Phase = "Error"
+		st.Message = fmt.Sprintf("VolumeSnapshot %s: %s", vs.GetName(), st.Error)
+	default:
+		st.Phase = "Creating"
+		st.Message = fmt.Sprintf("waiting for VolumeSnapshot %s", vs.GetName())
+	}
+}
+
+// deleteVolumeSnapshot deletes the ZSnapshot's VolumeSnapshot, but not one
+// of the same name that it did not create.
+func (r *ZSnapshotReconciler) deleteVolumeSnapshot(ctx context.Context, obj *nasv1.ZSnapshot, vs *unstructured.Unstructured) error {
+	if err := r.Get(ctx, client.ObjectKeyFromObject(vs), vs); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("get VolumeSnapshot: %w", err)
+	}
+	if vs.GetLabels()[zsnapshotLabel] != obj.Name {
+		return nil
+	}
+	if err := r.Delete(ctx, vs); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("delete VolumeSnapshot: %w", err)
+	}
+	return nil
+}
+
+// waitVolumeSnapshotCut waits until the CSI driver has cut vs
+// (status.creationTime), reports its error, or the deadline passes.
+func waitVolumeSnapshotCut(ctx context.Context, c client.Client, vs *unstructured.Unstructured, deadline time.Time) error {
+	for time.Now().Before(deadline) {
+		if err := c.Get(ctx, client.ObjectKeyFromObject(vs), vs); err == nil {
+			if t, _, _ := unstructured.NestedString(vs.Object, "status", "creationTime"); t != "" {
+				return nil
+			}
+			if msg, _, _ := unstructured.NestedString(vs.Object, "status", "error", "message"); msg != "" {
+				return fmt.Errorf("VolumeSnapshot %s: %s", vs.GetName(), msg)
+			}
+		}
+		time.Sleep(time.Second)
+	}
+	return nil
+}
+
+// snapshotWithHooks creates the VolumeSnapshot between the pre and post
+// hooks. The post hooks wait until the CSI driver has cut the snapshot
+// (status.creationTime), or the hook timeout, whichever comes first.
+func (r *ZSnapshotReconciler) snapshotWithHooks(ctx context.Context, obj *nasv1.ZSnapshot, vs *unstructured.Unstructured, mutate controllerutil.MutateFn) (ctrl.Result, error) {
+	hooks, err := runWithSnapshotHooks(ctx, r.Client, r.RESTConfig, obj.Namespace, obj.Spec.Hooks, func() error {
+		if _, err := controllerutil.CreateOrPatch(ctx, r.Client, vs, mutate); err != nil {
+			return fmt.Errorf("create VolumeSnapshot: %w", err)
+		}
+		return waitVolumeSnapshotCut(ctx, r.Client, vs, time.Now().Add(hookTimeout(obj.Spec.Hooks)))
+	})
+	obj.Status.HookResults = hooks.Results
+	obj.Status.VolumeSnapshotName = vs.GetName()
+	switch {
+	case hooks.Skipped:
+		obj.Status.Phase = "Failed"
+		obj.Status.Message = fmt.Sprintf("snapshot skipped: %v", hooks.PreErr)
+	case err != nil:
+		obj.Status.Phase = "Failed"
+		obj.Status.Message = err.Error()
+	default:
 		obj.Status.Phase = "Creating"
-		obj.Status.Message = fmt.Sprintf("VolumeSnapshot %s (%s)", vsName, op)
+		obj.Status.Message = fmt.Sprintf("VolumeSnapshot %s (created)", vs.GetName())
+		if hooks.PreErr != nil {
+			obj.Status.Message += fmt.Sprintf("; crash-consistent only: %v", hooks.PreErr)
+		}
 	}
-	obj.Status.VolumeSnapshotName = vsName
-	_ = r.Status().Update(ctx, &obj)
-	return ctrl.Result{RequeueAfter: 15 * time.Second}, nil
+	if hooks.PostErr != nil {
+		obj.Status.Message += fmt.Sprintf("; %v", hooks.PostErr)
+	}
+	_ = r.Status().Update(ctx, obj)
+	return ctrl.Result{}, nil
+}
+
+// zsnapshotsForVolumeSnapshot maps a VolumeSnapshot to the ZSnapshot that
+// created it.
+func (r *ZSnapshotReconciler) zsnapshotsForVolumeSnapshot(ctx context.Context, o client.Object) []reconcile.Request {
+	name := o.GetLabels()[zsnapshotLabel]
+	if name == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: o.GetNamespace(), Name: name}}}
+}
+
+// volumeSnapshotsServed reports whether the snapshot.storage.k8s.io CRDs are
+// installed. Without them the operator still starts, just without the
+// VolumeSnapshot watches; it has to be restarted to pick them up later.
+func volumeSnapshotsServed(mgr ctrl.Manager) bool {
+	_, err := mgr.GetRESTMapper().RESTMapping(volumeSnapshotGVK.GroupKind(), volumeSnapshotGVK.Version)
+	return err == nil
 }
 
 func (r *ZSnapshotReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&nasv1.ZSnapshot{}).
-		Complete(r)
+	b := ctrl.NewControllerManagedBy(mgr).For(&nasv1.ZSnapshot{})
+	if volumeSnapshotsServed(mgr) {
+		vs := &unstructured.Unstructured{}
+		vs.SetGroupVersionKind(volumeSnapshotGVK)
+		b = b.Watches(vs, handler.EnqueueRequestsFromMapFunc(r.zsnapshotsForVolumeSnapshot))
+	}
+	return b.Complete(r)
 }
@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	nasv1 "mnemosyne/api/v1alpha1"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type ZSnapshotFileRestoreReconciler struct {
+	client.Client
+	Cfg Config
+}
+
+type fileRestoreResponse struct {
+	OK      bool `json:"ok"`
+	Restore *struct {
+		State    string                             `json:"state"`
+		Message  string                             `json:"message,omitempty"`
+		Started  string                             `json:"started,omitempty"`
+		Finished string                             `json:"finished,omitempty"`
+		Results  []nasv1.ZSnapshotFileRestoreResult `json:"results,omitempty"`
+	} `json:"restore,omitempty"`
+}
+
+func (r *ZSnapshotFileRestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var obj nasv1.ZSnapshotFileRestore
+	if err := r.Get(ctx, req.NamespacedName, &obj); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	// A restore runs once; recreate the object to run it again.
+	if obj.Status.Phase == "Succeeded" || obj.Status.Phase == "Failed" {
+		return ctrl.Result{}, nil
+	}
+
+	spec := obj.Spec
+	if !strings.Contains(spec.Snapshot, "@") || len(spec.Paths) == 0 {
+		obj.Status.Phase = "Failed"
+		obj.Status.Message = "snapshot (dataset@name) and at least one path are required"
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{}, nil
+	}
+
+	// The node-agent runs the copy in the background keyed by the UID;
+	// posting again reports progress instead of starting over.
+	na := NewNodeAgentClient(r.Cfg)
+	body := map[string]any{
+		"token":           string(obj.UID),
+		"snapshot":        strings.TrimSpace(spec.Snapshot),
+		"paths":           spec.Paths,
+		"targetDirectory": strings.TrimSpace(spec.TargetDirectory),
+		"conflictPolicy":  strings.TrimSpace(spec.ConflictPolicy),
+	}
+	var out fileRestoreResponse
+	if err := na.do(ctx, "POST", "/v1/zfs/snapshot/files/restore", body, &out, nil); err != nil {
+		obj.Status.Message = err.Error()
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	job := out.Restore
+	if job == nil {
+		obj.Status.Message = "node-agent returned no restore status"
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	obj.Status.StartTime = job.Started
+	switch job.State {
+	case "Running":
+		obj.Status.Phase = "Running"
+		obj.Status.Message = "copying"
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	case "Complete":
+		obj.Status.Phase = "Succeeded"
+		obj.Status.Message = "OK"
+	default:
+		obj.Status.Phase = "Failed"
+		obj.Status.Message = job.Message
+	}
+	obj.Status.CompletionTime = job.Finished
+	obj.Status.Results = job.Results
+	_ = r.Status().Update(ctx, &obj)
+	return ctrl.Result{}, nil
+}
+
+func (r *ZSnapshotFileRestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&nasv1.ZSnapshotFileRestore{}).
+		Complete(r)
+}
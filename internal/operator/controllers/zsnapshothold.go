@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	nasv1 "mnemosyne/api/v1alpha1"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const zsnapshotHoldFinalizer = "nas.io/zsnapshothold-finalizer"
+
+type ZSnapshotHoldReconciler struct {
+	client.Client
+	Cfg Config
+}
+
+type snapshotHold struct {
+	Snapshot string `json:"snapshot"`
+	Tag      string `json:"tag"`
+	Created  int64  `json:"created,omitempty"`
+}
+
+type snapshotHoldsResponse struct {
+	OK    bool           `json:"ok"`
+	Items []snapshotHold `json:"items,omitempty"`
+}
+
+func (r *ZSnapshotHoldReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var obj nasv1.ZSnapshotHold
+	if err := r.Get(ctx, req.NamespacedName, &obj); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	na := NewNodeAgentClient(r.Cfg)
+	snap := strings.TrimSpace(obj.Spec.Snapshot)
+	tag := snapshotHoldTag(&obj)
+
+	if !obj.DeletionTimestamp.IsZero() {
+		if slices.Contains(obj.Finalizers, zsnapshotHoldFinalizer) {
+			if err := r.release(ctx, na, &obj); err != nil {
+				obj.Status.Phase = "Error"
+				obj.Status.Message = err.Error()
+				_ = r.Status().Update(ctx, &obj)
+				return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+			}
+			obj.Finalizers = slices.DeleteFunc(obj.Finalizers, func(n string) bool {
+				return n == zsnapshotHoldFinalizer
+			})
+			_ = r.Update(ctx, &obj)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if !strings.Contains(snap, "@") {
+		obj.Status.Phase = "Error"
+		obj.Status.Message = "snapshot must be a full dataset@name"
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	var expiresAt time.Time
+	if s := strings.TrimSpace(obj.Spec.ExpiresAt); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			obj.Status.Phase = "Error"
+			obj.Status.Message = fmt.Sprintf("invalid expiresAt %q: want RFC3339", s)
+			_ = r.Status().Update(ctx, &obj)
+			return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+		}
+		expiresAt = t
+	}
+
+	if !slices.Contains(obj.Finalizers, zsnapshotHoldFinalizer) {
+		obj.Finalizers = append(obj.Finalizers, zsnapshotHoldFinalizer)
+		if err := r.Update(ctx, &obj); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Release a hold placed for a previous snapshot or tag.
+	if obj.Status.Snapshot != "" && (obj.Status.Snapshot != snap || obj.Status.Tag != tag) {
+		if err := r.release(ctx, na, &obj); err != nil {
+			obj.Status.Phase = "Error"
+			obj.Status.Message = fmt.Sprintf("release previous hold: %v", err)
+			_ = r.Status().Update(ctx, &obj)
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+	}
+
+	if !expiresAt.IsZero() && !time.Now().Before(expiresAt) {
+		if obj.Status.Phase != "Expired" {
+			if err := r.release(ctx, na, &obj); err != nil {
+				obj.Status.Phase = "Error"
+				obj.Status.Message = err.Error()
+				_ = r.Status().Update(ctx, &obj)
+				return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+			}
+		}
+		obj.Status.Phase = "Expired"
+		obj.Status.Message = fmt.Sprintf("hold released at expiry %s", expiresAt.UTC().Format(time.RFC3339))
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{}, nil
+	}
+
+	body := map[string]any{"snapshot": snap, "tag": tag, "recursive": obj.Spec.Recursive}
+	if err := na.do(ctx, "POST", "/v1/zfs/snapshot/hold", body, nil, nil); err != nil {
+		obj.Status.Phase = "Error"
+		obj.Status.Message = err.Error()
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	if obj.Status.Snapshot != snap || obj.Status.Tag != tag || obj.Status.HeldAt == "" || obj.Status.Phase == "Expired" {
+		obj.Status.HeldAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	obj.Status.Snapshot = snap
+	obj.Status.Tag = tag
+
+	var holds snapshotHoldsResponse
+	q := make(url.Values)
+	q.Set("snapshot", snap)
+	obj.Status.Holds = nil
+	if err := na.do(ctx, "GET", "/v1/zfs/snapshot/holds", nil, &holds, q); err == nil {
+		for _, h := range holds.Items {
+			obj.Status.Holds = append(obj.Status.Holds, h.Tag)
+		}
+	}
+	obj.Status.Phase = "Held"
+	obj.Status.Message = "OK"
+	_ = r.Status().Update(ctx, &obj)
+
+	wait := 10 * time.Minute
+	if !expiresAt.IsZero() {
+		if d := time.Until(expiresAt); d < wait {
+			wait = d + time.Second
+		}
+	}
+	return ctrl.Result{RequeueAfter: wait}, nil
+}
+
+func (r *ZSnapshotHoldReconciler) release(ctx context.Context, na *NodeAgentClient, obj *nasv1.ZSnapshotHold) error {
+	snap, tag := obj.Status.Snapshot, obj.Status.Tag
+	if snap == "" {
+		return nil
+	}
+	body := map[string]any{"snapshot": snap, "tag": tag, "recursive": obj.Spec.Recursive}
+	if err := na.do(ctx, "POST", "/v1/zfs/snapshot/release", body, nil, nil); err != nil {
+		return err
+	}
+	obj.Status.Snapshot = ""
+	obj.Status.Tag = ""
+	obj.Status.HeldAt = ""
+	obj.Status.Holds = nil
+	return nil
+}
+
+func snapshotHoldTag(obj *nasv1.ZSnapshotHold) string {
+	if tag := strings.TrimSpace(obj.Spec.Tag); tag != "" {
+		return tag
+	}
+	return "nas-" + obj.Name
+}
+
+// heldSnapshots returns the held ds@name snapshots under ds. With recursive,
+// a hold on any child pins the whole ds@name family, since "zfs destroy -r"
+// would fail on it.
+func heldSnapshots(ctx context.Context, na *NodeAgentClient, ds string, recursive bool) (map[string]bool, error) {
+	var holds snapshotHoldsResponse
+	q := make(url.Values)
+	q.Set("dataset", ds)
+	if err := na.do(ctx, "GET", "/v1/zfs/snapshot/holds", nil, &holds, q); err != nil {
+		return nil, err
+	}
+	held := map[string]bool{}
+	for _, h := range holds.Items {
+		at := strings.Index(h.Snapshot, "@")
+		if at < 0 {
+			continue
+		}
+		owner := h.Snapshot[:at]
+		if owner == ds || (recursive && strings.HasPrefix(owner, ds+"/")) {
+			held[ds+h.Snapshot[at:]] = true
+		}
+	}
+	return held, nil
+}
+
+func (r *ZSnapshotHoldReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&nasv1.ZSnapshotHold{}).
+		Complete(r)
+}
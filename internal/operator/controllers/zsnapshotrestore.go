@@ -2,20 +2,41 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
 	"strings"
 	"time"
 
 	nasv1 "mnemosyne/api/v1alpha1"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
+const zsnapshotRestoreFinalizer = "nas.io/zsnapshotrestore-finalizer"
+
+type rollbackResponse struct {
+	OK       bool `json:"ok"`
+	Rollback *struct {
+		State          string `json:"state"`
+		Message        string `json:"message,omitempty"`
+		SafetySnapshot string `json:"safetySnapshot,omitempty"`
+	} `json:"rollback,omitempty"`
+}
+
 type ZSnapshotRestoreReconciler struct {
 	client.Client
 	Cfg Config
+	// APIReader reads PVC events without caching every event in the cluster.
+	APIReader client.Reader
 }
 
 func (r *ZSnapshotRestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -29,121 +50,453 @@ func (r *ZSnapshotRestoreReconciler) Reconcile(ctx context.Context, req ctrl.Req
 	if mode == "csi" {
 		return r.reconcileCSI(ctx, &obj)
 	}
+	if mode == "rollback" {
+		return r.reconcileRollback(ctx, &obj)
+	}
 
 	// default/legacy: clone
-	source := obj.Spec.SourceSnapshot
-	target := obj.Spec.TargetDataset
 	if mode == "" {
 		mode = "clone"
 	}
 	if mode != "clone" {
 		obj.Status.Phase = "Failed"
-		obj.Status.Message = "mode must be 'clone' or 'csi'"
+		obj.Status.Message = "mode must be 'clone', 'rollback' or 'csi'"
 		_ = r.Status().Update(ctx, &obj)
 		return ctrl.Result{}, nil
 	}
+	return r.reconcileClone(ctx, &obj)
+}
+
+// reconcileClone clones the snapshot into targetDataset, optionally promotes
+// it, and destroys it once ttl has passed or, with deletionPolicy Delete,
+// when the object is deleted.
+func (r *ZSnapshotRestoreReconciler) reconcileClone(ctx context.Context, obj *nasv1.ZSnapshotRestore) (ctrl.Result, error) {
+	source := obj.Spec.SourceSnapshot
+	target := obj.Spec.TargetDataset
+	na := NewNodeAgentClient(r.Cfg)
+	deleteOnRemove := strings.EqualFold(strings.TrimSpace(obj.Spec.DeletionPolicy), "Delete")
+
+	if !obj.DeletionTimestamp.IsZero() {
+		if slices.Contains(obj.Finalizers, zsnapshotRestoreFinalizer) {
+			if deleteOnRemove && obj.Status.ResultDataset != "" {
+				if err := na.do(ctx, "POST", "/v1/zfs/snapshot/clone/destroy", map[string]any{"dataset": obj.Status.ResultDataset}, nil, nil); err != nil {
+					obj.Status.Message = err.Error()
+					_ = r.Status().Update(ctx, obj)
+					return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+				}
+			}
+			obj.Finalizers = slices.DeleteFunc(obj.Finalizers, func(n string) bool {
+				return n == zsnapshotRestoreFinalizer
+			})
+			_ = r.Update(ctx, obj)
+		}
+		return ctrl.Result{}, nil
+	}
+
 	if source == "" || target == "" {
 		obj.Status.Phase = "Pending"
 		obj.Status.Message = "sourceSnapshot and targetDataset required for clone mode"
-		_ = r.Status().Update(ctx, &obj)
+		_ = r.Status().Update(ctx, obj)
 		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
 	}
+	ttl, err := parseRetentionDuration(obj.Spec.TTL)
+	if err != nil {
+		obj.Status.Phase = "Failed"
+		obj.Status.Message = fmt.Sprintf("ttl: %v", err)
+		_ = r.Status().Update(ctx, obj)
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+	// After a promote the origin's older snapshots belong to the clone, so
+	// destroying it would take them along.
+	if obj.Spec.Promote && (ttl > 0 || deleteOnRemove) {
+		obj.Status.Phase = "Failed"
+		obj.Status.Message = "promote cannot be combined with ttl or deletionPolicy Delete"
+		_ = r.Status().Update(ctx, obj)
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
 
-	phase := obj.Status.Phase
-	if phase == "Succeeded" {
-		return ctrl.Result{RequeueAfter: 10 * time.Minute}, nil
+	// The finalizer is only needed when deleting the object destroys the clone.
+	if deleteOnRemove != slices.Contains(obj.Finalizers, zsnapshotRestoreFinalizer) {
+		if deleteOnRemove {
+			obj.Finalizers = append(obj.Finalizers, zsnapshotRestoreFinalizer)
+		} else {
+			obj.Finalizers = slices.DeleteFunc(obj.Finalizers, func(n string) bool {
+				return n == zsnapshotRestoreFinalizer
+			})
+		}
+		if err := r.Update(ctx, obj); err != nil {
+			return ctrl.Result{}, err
+		}
 	}
 
-	na := NewNodeAgentClient(r.Cfg)
-	body := map[string]any{"sourceSnapshot": source, "targetDataset": target}
-	var out any
-	if err := na.do(ctx, "POST", "/v1/zfs/snapshot/clone", body, &out, nil); err != nil {
-		obj.Status.Phase = "Failed"
-		obj.Status.Message = err.Error()
-		_ = r.Status().Update(ctx, &obj)
-		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	if obj.Status.Phase == "Expired" {
+		return ctrl.Result{}, nil
+	}
+	if obj.Status.Phase != "Succeeded" {
+		body := map[string]any{"sourceSnapshot": source, "targetDataset": target}
+		var out any
+		if err := na.do(ctx, "POST", "/v1/zfs/snapshot/clone", body, &out, nil); err != nil {
+			obj.Status.Phase = "Failed"
+			obj.Status.Message = err.Error()
+			_ = r.Status().Update(ctx, obj)
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+		obj.Status.ResultDataset = target
+		obj.Status.ClonedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	if obj.Spec.Promote && !obj.Status.Promoted {
+		if err := na.do(ctx, "POST", "/v1/zfs/snapshot/clone/promote", map[string]any{"dataset": obj.Status.ResultDataset}, nil, nil); err != nil {
+			obj.Status.Phase = "Succeeded"
+			obj.Status.Message = fmt.Sprintf("cloned; promote failed: %v", err)
+			_ = r.Status().Update(ctx, obj)
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+		obj.Status.Promoted = true
 	}
 
 	obj.Status.Phase = "Succeeded"
 	obj.Status.Message = "OK"
-	obj.Status.ResultDataset = target
-	_ = r.Status().Update(ctx, &obj)
-	return ctrl.Result{RequeueAfter: 10 * time.Minute}, nil
+	obj.Status.ExpiresAt = ""
+	wait := 10 * time.Minute
+	if ttl > 0 {
+		cloned, err := time.Parse(time.RFC3339, obj.Status.ClonedAt)
+		if err != nil {
+			// Clones made before ttl existed count from now.
+			cloned = time.Now()
+			obj.Status.ClonedAt = cloned.UTC().Format(time.RFC3339)
+		}
+		expires := cloned.Add(ttl)
+		obj.Status.ExpiresAt = expires.UTC().Format(time.RFC3339)
+		if !time.Now().Before(expires) {
+			if err := na.do(ctx, "POST", "/v1/zfs/snapshot/clone/destroy", map[string]any{"dataset": obj.Status.ResultDataset}, nil, nil); err != nil {
+				obj.Status.Message = fmt.Sprintf("ttl expired; destroy failed: %v", err)
+				_ = r.Status().Update(ctx, obj)
+				return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+			}
+			obj.Status.Phase = "Expired"
+			obj.Status.Message = fmt.Sprintf("%s destroyed after ttl %s", obj.Status.ResultDataset, strings.TrimSpace(obj.Spec.TTL))
+			_ = r.Status().Update(ctx, obj)
+			return ctrl.Result{}, nil
+		}
+		if d := time.Until(expires); d < wait {
+			wait = d + time.Second
+		}
+	}
+	_ = r.Status().Update(ctx, obj)
+	return ctrl.Result{RequeueAfter: wait}, nil
 }
 
-func (r *ZSnapshotRestoreReconciler) reconcileCSI(ctx context.Context, obj *nasv1.ZSnapshotRestore) (ctrl.Result, error) {
-	src := obj.Spec.SourceVolumeSnapshot
-	tgt := obj.Spec.TargetPVC
-	sc := obj.Spec.StorageClassName
-	if sc == "" {
-		sc = "nas-zfspv"
+// reconcileRollback rolls the snapshot's dataset back in place. It runs once
+// the confirmation token matches, with the dataset's SMB and NFS shares
+// paused until the rollback has finished or failed. Before pausing them the
+// node-agent copies the current state to a separate dataset, which needs as
+// much free space again as the dataset references and can take hours; once
+// they are paused only the changes made meanwhile are copied.
+func (r *ZSnapshotRestoreReconciler) reconcileRollback(ctx context.Context, obj *nasv1.ZSnapshotRestore) (ctrl.Result, error) {
+	if !obj.DeletionTimestamp.IsZero() {
+		if slices.Contains(obj.Finalizers, zsnapshotRestoreFinalizer) {
+			if err := r.resumeShares(ctx, obj); err != nil {
+				obj.Status.Message = err.Error()
+				_ = r.Status().Update(ctx, obj)
+				return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+			}
+			obj.Finalizers = slices.DeleteFunc(obj.Finalizers, func(n string) bool {
+				return n == zsnapshotRestoreFinalizer
+			})
+			_ = r.Update(ctx, obj)
+		}
+		return ctrl.Result{}, nil
 	}
-	if src == "" || tgt == "" {
-		obj.Status.Phase = "Pending"
-		obj.Status.Message = "sourceVolumeSnapshot and targetPVC required for csi mode"
+	// A rollback runs once; recreate the object to run it again.
+	if obj.Status.Phase == "Succeeded" || obj.Status.Phase == "Failed" {
+		return ctrl.Result{}, nil
+	}
+
+	source := strings.TrimSpace(obj.Spec.SourceSnapshot)
+	at := strings.Index(source, "@")
+	if at <= 0 {
+		obj.Status.Phase = "Failed"
+		obj.Status.Message = "sourceSnapshot (dataset@name) required for rollback mode"
 		_ = r.Status().Update(ctx, obj)
-		return ctrl.Result{RequeueAfter: 20 * time.Second}, nil
+		return ctrl.Result{}, nil
 	}
+	ds := source[:at]
+	// The token is re-checked on every pass, so changing sourceSnapshot or
+	// the token while shares are pausing withdraws the confirmation. Once
+	// the node-agent job has started it cannot be stopped and is finished
+	// for the snapshot that was confirmed.
+	token := rollbackConfirmationToken(source)
+	confirmed := strings.TrimSpace(obj.Spec.ConfirmationToken) == token &&
+		(obj.Status.ConfirmedSnapshot == "" || obj.Status.ConfirmedSnapshot == source)
+	if !confirmed {
+		if obj.Status.Phase == "RollingBack" && obj.Status.ConfirmedSnapshot != "" {
+			source = obj.Status.ConfirmedSnapshot
+			ds = source[:strings.Index(source, "@")]
+		} else {
+			if err := r.resumeShares(ctx, obj); err != nil {
+				obj.Status.Message = fmt.Sprintf("resume shares: %v", err)
+				_ = r.Status().Update(ctx, obj)
+				return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+			}
+			obj.Status.Phase = "AwaitingConfirmation"
+			obj.Status.ConfirmedSnapshot = ""
+			obj.Status.PausedShares = nil
+			obj.Status.Message = fmt.Sprintf("rolling %s back to %s discards every change made since; set confirmationToken to %q to proceed", ds, source, token)
+			_ = r.Status().Update(ctx, obj)
+			return ctrl.Result{}, nil
+		}
+	}
+	obj.Status.ConfirmedSnapshot = source
 
-	// Create PVC if missing
-	pvcGVK := schema.GroupVersionKind{Group: "", Version: "v1", Kind: "PersistentVolumeClaim"}
-	pvc := &unstructured.Unstructured{}
-	pvc.SetGroupVersionKind(pvcGVK)
-	err := r.Get(ctx, client.ObjectKey{Namespace: obj.GetNamespace(), Name: tgt}, pvc)
-	if err != nil {
-		// Build PVC manifest
-		pvc = &unstructured.Unstructured{}
-		pvc.SetGroupVersionKind(pvcGVK)
-		pvc.SetNamespace(obj.GetNamespace())
-		pvc.SetName(tgt)
-		_ = unstructured.SetNestedField(pvc.Object, sc, "spec", "storageClassName")
-
-		accessModes := obj.Spec.AccessModes
-		if len(accessModes) == 0 {
-			accessModes = []string{"ReadWriteOnce"}
-		}
-		modes := make([]any, 0, len(accessModes))
-		for _, mode := range accessModes {
-			modes = append(modes, mode)
-		}
-		_ = unstructured.SetNestedField(pvc.Object, modes, "spec", "accessModes")
-
-		resources := obj.Spec.Resources
-		if resources == nil {
-			resources = map[string]any{"requests": map[string]any{"storage": "1Gi"}}
-		}
-		_ = unstructured.SetNestedField(pvc.Object, resources, "spec", "resources")
-		_ = unstructured.SetNestedField(pvc.Object, map[string]any{
-			"apiGroup": "snapshot.storage.k8s.io",
-			"kind":     "VolumeSnapshot",
-			"name":     src,
-		}, "spec", "dataSource")
-		if err2 := r.Create(ctx, pvc); err2 != nil {
-			obj.Status.Phase = "Failed"
-			obj.Status.Message = err2.Error()
+	if !slices.Contains(obj.Finalizers, zsnapshotRestoreFinalizer) {
+		obj.Finalizers = append(obj.Finalizers, zsnapshotRestoreFinalizer)
+		if err := r.Update(ctx, obj); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// The safety copy is named after the restore's creation so every poll
+	// asks for the same one.
+	stamp := obj.CreationTimestamp.UTC().Format("20060102-150405")
+	safetyName := "pre-rollback-" + stamp
+	safetyDS := ds + "/" + safetyName
+	if i := strings.LastIndex(ds, "/"); i > 0 {
+		safetyDS = ds[:i] + "/" + ds[i+1:] + "-" + safetyName
+	}
+	na := NewNodeAgentClient(r.Cfg)
+	body := map[string]any{
+		"token":         string(obj.UID),
+		"snapshot":      source,
+		"force":         obj.Spec.ForceRollback,
+		"safetyName":    safetyName,
+		"safetyDataset": safetyDS,
+	}
+
+	// The full safety copy is taken while the shares are still up; only the
+	// changes made during it are copied once they are paused.
+	if obj.Status.Phase != "Pausing" && obj.Status.Phase != "RollingBack" {
+		copyBody := maps.Clone(body)
+		copyBody["token"] = string(obj.UID) + "-copy"
+		copyBody["copyOnly"] = true
+		var out rollbackResponse
+		if err := na.do(ctx, "POST", "/v1/zfs/snapshot/rollback", copyBody, &out, nil); err != nil {
+			return r.finishRollback(ctx, obj, "", err.Error())
+		}
+		job := out.Rollback
+		if job == nil {
+			obj.Status.Message = "node-agent returned no rollback status"
 			_ = r.Status().Update(ctx, obj)
 			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
 		}
+		switch job.State {
+		case "Running":
+			obj.Status.Phase = "Copying"
+			obj.Status.Message = fmt.Sprintf("copying current state to %s before pausing shares", safetyDS)
+			_ = r.Status().Update(ctx, obj)
+			return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+		case "Complete":
+		default:
+			return r.finishRollback(ctx, obj, "", job.Message)
+		}
+	}
+
+	if obj.Status.Phase != "RollingBack" {
+		waiting, err := r.pauseShares(ctx, obj, ds)
+		if err != nil {
+			return r.finishRollback(ctx, obj, "", err.Error())
+		}
+		if waiting > 0 {
+			obj.Status.Phase = "Pausing"
+			obj.Status.Message = fmt.Sprintf("waiting for %d share(s) to stop", waiting)
+			_ = r.Status().Update(ctx, obj)
+			return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+		}
 	}
 
-	bound, _, _ := unstructured.NestedString(pvc.Object, "status", "phase")
-	if bound == "Bound" {
-		obj.Status.Phase = "Succeeded"
-		obj.Status.Message = "OK"
-		obj.Status.ResultPVC = tgt
+	var out rollbackResponse
+	if err := na.do(ctx, "POST", "/v1/zfs/snapshot/rollback", body, &out, nil); err != nil {
+		if obj.Status.Phase == "RollingBack" {
+			// The job may still be running; keep the shares down until it reports.
+			obj.Status.Message = err.Error()
+			_ = r.Status().Update(ctx, obj)
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+		return r.finishRollback(ctx, obj, "", err.Error())
+	}
+	job := out.Rollback
+	if job == nil {
+		obj.Status.Message = "node-agent returned no rollback status"
+		_ = r.Status().Update(ctx, obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	switch job.State {
+	case "Running":
+		obj.Status.Phase = "RollingBack"
+		obj.Status.Message = fmt.Sprintf("copying changes since the safety copy to %s@%s, then rolling back", safetyDS, safetyName)
 		_ = r.Status().Update(ctx, obj)
-		return ctrl.Result{RequeueAfter: 10 * time.Minute}, nil
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	case "Complete":
+		obj.Status.ResultDataset = ds
+		return r.finishRollback(ctx, obj, job.SafetySnapshot, "")
+	default:
+		return r.finishRollback(ctx, obj, job.SafetySnapshot, job.Message)
 	}
+}
 
-	obj.Status.Phase = "Restoring"
-	obj.Status.Message = "PVC creation in progress"
-	obj.Status.ResultPVC = tgt
+// finishRollback brings the shares back and records the outcome; an empty
+// failure means success.
+func (r *ZSnapshotRestoreReconciler) finishRollback(ctx context.Context, obj *nasv1.ZSnapshotRestore, safety, failure string) (ctrl.Result, error) {
+	if safety != "" {
+		obj.Status.SafetySnapshot = safety
+	}
+	if err := r.resumeShares(ctx, obj); err != nil {
+		obj.Status.Message = fmt.Sprintf("resume shares: %v", err)
+		_ = r.Status().Update(ctx, obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	obj.Status.Phase = "Succeeded"
+	obj.Status.Message = "OK"
+	if failure != "" {
+		obj.Status.Phase = "Failed"
+		obj.Status.Message = failure
+	}
 	_ = r.Status().Update(ctx, obj)
-	return ctrl.Result{RequeueAfter: 15 * time.Second}, nil
+	return ctrl.Result{}, nil
+}
+
+// pauseShares marks the SMB and NFS shares serving ds or a child of it as
+// paused by obj, and returns how many have not stopped yet. iSCSI and
+// NVMe-oF shares of a zvol under ds refuse the rollback: their initiators
+// cache the block device and would corrupt it if it changed underneath.
+func (r *ZSnapshotRestoreReconciler) pauseShares(ctx context.Context, obj *nasv1.ZSnapshotRestore, ds string) (int, error) {
+	var shares nasv1.NASShareList
+	if err := r.List(ctx, &shares); err != nil {
+		return 0, err
+	}
+	for i := range shares.Items {
+		sh := &shares.Items[i]
+		proto := strings.ToLower(strings.TrimSpace(sh.Spec.Protocol))
+		if proto != "iscsi" && proto != "nvmeof" {
+			continue
+		}
+		if zvol := r.blockShareZVol(ctx, sh); zvol == ds || strings.HasPrefix(zvol, ds+"/") {
+			return 0, fmt.Errorf("share %s/%s exports %s over %s and cannot be paused; delete it before rolling back", sh.Namespace, sh.Name, zvol, proto)
+		}
+	}
+	key := obj.Namespace + "/" + obj.Name
+	waiting := 0
+	for i := range shares.Items {
+		sh := &shares.Items[i]
+		proto := strings.ToLower(strings.TrimSpace(sh.Spec.Protocol))
+		dataset := strings.Trim(strings.TrimSpace(sh.Spec.DatasetName), "/")
+		if (proto != "smb" && proto != "nfs") || strings.TrimSpace(sh.Spec.PVCName) != "" {
+			continue
+		}
+		if dataset != ds && !strings.HasPrefix(dataset, ds+"/") {
+			continue
+		}
+		switch by := sh.Annotations[sharePausedByAnnotation]; by {
+		case key:
+		case "":
+			if sh.Annotations == nil {
+				sh.Annotations = map[string]string{}
+			}
+			sh.Annotations[sharePausedByAnnotation] = key
+			if err := r.Update(ctx, sh); err != nil {
+				return 0, err
+			}
+		default:
+			return 0, fmt.Errorf("share %s/%s is paused by %s", sh.Namespace, sh.Name, by)
+		}
+		name := sh.Namespace + "/" + sh.Name
+		if !slices.Contains(obj.Status.PausedShares, name) {
+			obj.Status.PausedShares = append(obj.Status.PausedShares, name)
+		}
+		// The message tells this pause apart from a previous one the share
+		// has not yet recovered from.
+		if sh.Status.Phase != "Paused" || sh.Status.Message != "paused by "+key {
+			waiting++
+		}
+	}
+	return waiting, nil
+}
+
+// blockShareZVol returns the zvol an iSCSI or NVMe-oF share exports, or ""
+// if its ZVolume cannot be read.
+func (r *ZSnapshotRestoreReconciler) blockShareZVol(ctx context.Context, sh *nasv1.NASShare) string {
+	ref := ""
+	if sh.Spec.ISCSI != nil {
+		ref = sh.Spec.ISCSI.ZVolumeRef
+	}
+	if sh.Spec.NVMeoF != nil {
+		ref = sh.Spec.NVMeoF.ZVolumeRef
+	}
+	if name := strings.TrimSpace(ref); name != "" {
+		var zv nasv1.ZVolume
+		if err := r.Get(ctx, client.ObjectKey{Namespace: sh.Namespace, Name: name}, &zv); err != nil {
+			return ""
+		}
+		return zvolumeName(zv.Spec)
+	}
+	return strings.Trim(strings.TrimSpace(sh.Spec.DatasetName), "/")
+}
+
+// resumeShares clears the pause obj placed, including on shares that were
+// annotated before the status recording them was written.
+func (r *ZSnapshotRestoreReconciler) resumeShares(ctx context.Context, obj *nasv1.ZSnapshotRestore) error {
+	var shares nasv1.NASShareList
+	if err := r.List(ctx, &shares); err != nil {
+		return err
+	}
+	key := obj.Namespace + "/" + obj.Name
+	for i := range shares.Items {
+		sh := &shares.Items[i]
+		if sh.Annotations[sharePausedByAnnotation] != key {
+			continue
+		}
+		delete(sh.Annotations, sharePausedByAnnotation)
+		if err := r.Update(ctx, sh); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollbackConfirmationToken is derived from the snapshot so a token copied
+// from another rollback does not confirm this one.
+func rollbackConfirmationToken(snapshot string) string {
+	sum := sha256.Sum256([]byte("rollback:" + snapshot))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// restoresForShare wakes the rollback that paused a share when the share's
+// status changes.
+func (r *ZSnapshotRestoreReconciler) restoresForShare(ctx context.Context, o client.Object) []reconcile.Request {
+	ns, name, ok := strings.Cut(o.GetAnnotations()[sharePausedByAnnotation], "/")
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: ns, Name: name}}}
 }
 
 func (r *ZSnapshotRestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&nasv1.ZSnapshotRestore{}).
-		Complete(r)
+		Watches(&nasv1.NASShare{}, handler.EnqueueRequestsFromMapFunc(r.restoresForShare)).
+		Watches(&corev1.PersistentVolumeClaim{}, handler.EnqueueRequestsFromMapFunc(
+			func(ctx context.Context, o client.Object) []reconcile.Request {
+				return r.csiRestoresFor(ctx, o, func(rs *nasv1.ZSnapshotRestore) string { return rs.Spec.TargetPVC })
+			}),
+		)
+	if volumeSnapshotsServed(mgr) {
+		vs := &unstructured.Unstructured{}
+		vs.SetGroupVersionKind(volumeSnapshotGVK)
+		b = b.Watches(vs, handler.EnqueueRequestsFromMapFunc(
+			func(ctx context.Context, o client.Object) []reconcile.Request {
+				return r.csiRestoresFor(ctx, o, func(rs *nasv1.ZSnapshotRestore) string { return rs.Spec.SourceVolumeSnapshot })
+			}),
+		)
+	}
+	return b.Complete(r)
 }
@@ -0,0 +1,292 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	nasv1 "mnemosyne/api/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var (
+	volumeSnapshotContentGVK = volumeSnapshotGVK.GroupVersion().WithKind("VolumeSnapshotContent")
+	volumeSnapshotClassGVK   = volumeSnapshotGVK.GroupVersion().WithKind("VolumeSnapshotClass")
+)
+
+// reconcileCSI restores a VolumeSnapshot into a new PVC, sized from the
+// snapshot's restoreSize unless resources are given. Changes to the
+// VolumeSnapshot and the PVC trigger reconciles; while the PVC is pending
+// its warning events are checked periodically.
+func (r *ZSnapshotRestoreReconciler) reconcileCSI(ctx context.Context, obj *nasv1.ZSnapshotRestore) (ctrl.Result, error) {
+	// A restore runs once: deleting the PVC afterwards must not bring it
+	// back. Recreate the object to restore again.
+	if obj.Status.Phase == "Succeeded" {
+		return ctrl.Result{}, nil
+	}
+	src := obj.Spec.SourceVolumeSnapshot
+	tgt := obj.Spec.TargetPVC
+	sc := obj.Spec.StorageClassName
+	if sc == "" {
+		sc = "nas-zfspv"
+	}
+	if src == "" || tgt == "" {
+		obj.Status.Phase = "Pending"
+		obj.Status.Message = "sourceVolumeSnapshot and targetPVC required for csi mode"
+		_ = r.Status().Update(ctx, obj)
+		return ctrl.Result{RequeueAfter: 20 * time.Second}, nil
+	}
+
+	var pvc corev1.PersistentVolumeClaim
+	err := r.Get(ctx, client.ObjectKey{Namespace: obj.Namespace, Name: tgt}, &pvc)
+	if errors.IsNotFound(err) {
+		return r.createRestorePVC(ctx, obj, sc)
+	}
+	if err != nil {
+		obj.Status.Phase = "Failed"
+		obj.Status.Message = fmt.Sprintf("get PVC %s: %v", tgt, err)
+		_ = r.Status().Update(ctx, obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	if ds := pvc.Spec.DataSource; ds == nil || ds.Kind != "VolumeSnapshot" || ds.Name != src {
+		obj.Status.Phase = "Failed"
+		obj.Status.Message = fmt.Sprintf("PVC %s already exists and is not restored from %s", tgt, src)
+		_ = r.Status().Update(ctx, obj)
+		return ctrl.Result{}, nil
+	}
+
+	obj.Status.ResultPVC = tgt
+	switch pvc.Status.Phase {
+	case corev1.ClaimBound:
+		obj.Status.Phase = "Succeeded"
+		obj.Status.Message = "OK"
+		_ = r.Status().Update(ctx, obj)
+		return ctrl.Result{}, nil
+	case corev1.ClaimLost:
+		obj.Status.Phase = "Failed"
+		obj.Status.Message = fmt.Sprintf("PVC %s lost its volume", tgt)
+		_ = r.Status().Update(ctx, obj)
+		return ctrl.Result{}, nil
+	}
+
+	obj.Status.Phase = "Restoring"
+	obj.Status.Message = "PVC creation in progress"
+	if warn := r.pvcWarning(ctx, &pvc); warn != "" {
+		obj.Status.Message = fmt.Sprintf("PVC %s pending: %s", tgt, warn)
+	}
+	_ = r.Status().Update(ctx, obj)
+	// Binding is picked up by the PVC watch; events are not watched.
+	return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+}
+
+// createRestorePVC checks the VolumeSnapshot and the StorageClass and then
+// creates the target PVC.
+func (r *ZSnapshotRestoreReconciler) createRestorePVC(ctx context.Context, obj *nasv1.ZSnapshotRestore, sc string) (ctrl.Result, error) {
+	src := obj.Spec.SourceVolumeSnapshot
+	vs := &unstructured.Unstructured{}
+	vs.SetGroupVersionKind(volumeSnapshotGVK)
+	if err := r.Get(ctx, client.ObjectKey{Namespace: obj.Namespace, Name: src}, vs); err != nil {
+		obj.Status.Phase = "Pending"
+		obj.Status.Message = fmt.Sprintf("VolumeSnapshot %s: %v", src, err)
+		_ = r.Status().Update(ctx, obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	// The VolumeSnapshot watch reconciles again once it is ready.
+	if ready, _, _ := unstructured.NestedBool(vs.Object, "status", "readyToUse"); !ready {
+		obj.Status.Phase = "Pending"
+		obj.Status.Message = fmt.Sprintf("waiting for VolumeSnapshot %s to be ready", src)
+		if msg, _, _ := unstructured.NestedString(vs.Object, "status", "error", "message"); msg != "" {
+			obj.Status.Message += ": " + msg
+		}
+		_ = r.Status().Update(ctx, obj)
+		return ctrl.Result{}, nil
+	}
+
+	fail := func(msg string) (ctrl.Result, error) {
+		obj.Status.Phase = "Failed"
+		obj.Status.Message = msg
+		_ = r.Status().Update(ctx, obj)
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+
+	restoreSize := ""
+	if v, ok, _ := unstructured.NestedFieldNoCopy(vs.Object, "status", "restoreSize"); ok && v != nil {
+		restoreSize = fmt.Sprint(v)
+	}
+	resources, err := restorePVCResources(obj.Spec.Resources, restoreSize)
+	if err != nil {
+		return fail(fmt.Sprintf("VolumeSnapshot %s: %v", src, err))
+	}
+
+	driver, err := r.snapshotDriver(ctx, vs)
+	if err != nil {
+		return fail(fmt.Sprintf("VolumeSnapshot %s: %v", src, err))
+	}
+	var class storagev1.StorageClass
+	if err := r.Get(ctx, client.ObjectKey{Name: sc}, &class); err != nil {
+		return fail(fmt.Sprintf("storageClass %s: %v", sc, err))
+	}
+	if driver != "" && class.Provisioner != driver {
+		return fail(fmt.Sprintf("storageClass %s provisions %s volumes but VolumeSnapshot %s was taken by %s", sc, class.Provisioner, src, driver))
+	}
+
+	accessModes := obj.Spec.AccessModes
+	if len(accessModes) == 0 {
+		accessModes = []string{"ReadWriteOnce"}
+	}
+	modes := make([]corev1.PersistentVolumeAccessMode, 0, len(accessModes))
+	for _, mode := range accessModes {
+		modes = append(modes, corev1.PersistentVolumeAccessMode(mode))
+	}
+	apiGroup := volumeSnapshotGVK.Group
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: obj.Spec.TargetPVC, Namespace: obj.Namespace},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &sc,
+			AccessModes:      modes,
+			Resources:        resources,
+			DataSource: &corev1.TypedLocalObjectReference{
+				APIGroup: &apiGroup,
+				Kind:     "VolumeSnapshot",
+				Name:     src,
+			},
+		},
+	}
+	if err := r.Create(ctx, pvc); err != nil && !errors.IsAlreadyExists(err) {
+		obj.Status.Phase = "Failed"
+		obj.Status.Message = err.Error()
+		_ = r.Status().Update(ctx, obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	obj.Status.Phase = "Restoring"
+	obj.Status.Message = fmt.Sprintf("PVC %s created from %s", obj.Spec.TargetPVC, src)
+	obj.Status.ResultPVC = obj.Spec.TargetPVC
+	_ = r.Status().Update(ctx, obj)
+	return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+}
+
+// restorePVCResources returns spec.resources with the storage request
+// defaulted to the snapshot's restoreSize. A smaller request is refused,
+// since the provisioner cannot restore into it.
+func restorePVCResources(spec map[string]any, restoreSize string) (corev1.VolumeResourceRequirements, error) {
+	var res corev1.VolumeResourceRequirements
+	if spec != nil {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(runtime.DeepCopyJSON(spec), &res); err != nil {
+			return res, fmt.Errorf("resources: %w", err)
+		}
+	}
+	var size resource.Quantity
+	if restoreSize != "" {
+		q, err := resource.ParseQuantity(restoreSize)
+		if err != nil {
+			return res, fmt.Errorf("restoreSize %q: %w", restoreSize, err)
+		}
+		size = q
+	}
+	req, ok := res.Requests[corev1.ResourceStorage]
+	switch {
+	case !ok && size.IsZero():
+		return res, fmt.Errorf("no restoreSize reported; set resources.requests.storage")
+	case !ok:
+		if res.Requests == nil {
+			res.Requests = corev1.ResourceList{}
+		}
+		res.Requests[corev1.ResourceStorage] = size
+	case !size.IsZero() && req.Cmp(size) < 0:
+		return res, fmt.Errorf("resources.requests.storage %s is smaller than restoreSize %s", req.String(), size.String())
+	}
+	return res, nil
+}
+
+// snapshotDriver returns the CSI driver that took the VolumeSnapshot: the
+// bound content's, or else its class's. It is empty if neither is known.
+func (r *ZSnapshotRestoreReconciler) snapshotDriver(ctx context.Context, vs *unstructured.Unstructured) (string, error) {
+	if name, _, _ := unstructured.NestedString(vs.Object, "status", "boundVolumeSnapshotContentName"); name != "" {
+		content := &unstructured.Unstructured{}
+		content.SetGroupVersionKind(volumeSnapshotContentGVK)
+		if err := r.Get(ctx, client.ObjectKey{Name: name}, content); err != nil {
+			return "", fmt.Errorf("VolumeSnapshotContent %s: %w", name, err)
+		}
+		driver, _, _ := unstructured.NestedString(content.Object, "spec", "driver")
+		return driver, nil
+	}
+	if name, _, _ := unstructured.NestedString(vs.Object, "spec", "volumeSnapshotClassName"); name != "" {
+		class := &unstructured.Unstructured{}
+		class.SetGroupVersionKind(volumeSnapshotClassGVK)
+		if err := r.Get(ctx, client.ObjectKey{Name: name}, class); err != nil {
+			return "", fmt.Errorf("VolumeSnapshotClass %s: %w", name, err)
+		}
+		driver, _, _ := unstructured.NestedString(class.Object, "driver")
+		return driver, nil
+	}
+	return "", nil
+}
+
+// pvcWarning returns the newest warning event on the PVC, which is where the
+// external provisioner reports why it cannot provision. Events are read
+// directly rather than cached.
+func (r *ZSnapshotRestoreReconciler) pvcWarning(ctx context.Context, pvc *corev1.PersistentVolumeClaim) string {
+	if r.APIReader == nil {
+		return ""
+	}
+	var events corev1.EventList
+	if err := r.APIReader.List(ctx, &events, client.InNamespace(pvc.Namespace), client.MatchingFields{
+		"involvedObject.kind": "PersistentVolumeClaim",
+		"involvedObject.name": pvc.Name,
+	}); err != nil {
+		return ""
+	}
+	var newest *corev1.Event
+	for i := range events.Items {
+		e := &events.Items[i]
+		if e.Type != corev1.EventTypeWarning || e.InvolvedObject.UID != pvc.UID {
+			continue
+		}
+		if newest == nil || eventTime(e).After(eventTime(newest)) {
+			newest = e
+		}
+	}
+	if newest == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s: %s", newest.Reason, strings.TrimSpace(newest.Message))
+}
+
+func eventTime(e *corev1.Event) time.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	}
+	return e.CreationTimestamp.Time
+}
+
+// csiRestoresFor maps a PVC or VolumeSnapshot to the csi restores that
+// reference it.
+func (r *ZSnapshotRestoreReconciler) csiRestoresFor(ctx context.Context, o client.Object, ref func(*nasv1.ZSnapshotRestore) string) []reconcile.Request {
+	var list nasv1.ZSnapshotRestoreList
+	if err := r.List(ctx, &list, client.InNamespace(o.GetNamespace())); err != nil {
+		return nil
+	}
+	var reqs []reconcile.Request
+	for i := range list.Items {
+		rs := &list.Items[i]
+		if strings.EqualFold(strings.TrimSpace(rs.Spec.Mode), "csi") && ref(rs) == o.GetName() {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: rs.Namespace, Name: rs.Name}})
+		}
+	}
+	return reqs
+}
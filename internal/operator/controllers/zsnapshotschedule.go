@@ -4,20 +4,46 @@ import (
 	"context"
 	"fmt"
 	"net/url"
-	"sort"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	nasv1 "mnemosyne/api/v1alpha1"
 
 	cron "github.com/robfig/cron/v3"
+	apiMeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+// runNowAnnotation takes a snapshot once per distinct value, even while the
+// schedule is suspended.
+const runNowAnnotation = "nas.io/run-now"
+
+const (
+	catchUpOnce = "Once"
+	catchUpSkip = "Skip"
+
+	runTriggerSchedule = "Schedule"
+	runTriggerCatchUp  = "CatchUp"
+	runTriggerManual   = "Manual"
+	runTriggerSkipped  = "Skipped"
+
+	defaultRunHistoryLimit   = 10
+	maxRunHistoryLimit       = 100
+	scheduleFailureThreshold = 3
+	maxCatchUpWindow         = 31 * 24 * time.Hour
 )
 
 type ZSnapshotScheduleReconciler struct {
 	client.Client
 	Cfg Config
+	// RESTConfig is used to exec snapshot hooks in pods.
+	RESTConfig *rest.Config
 }
 
 func (r *ZSnapshotScheduleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -29,15 +55,56 @@ func (r *ZSnapshotScheduleReconciler) Reconcile(ctx context.Context, req ctrl.Re
 	spec := obj.Spec
 	ds := spec.DatasetName
 	schedExpr := spec.Schedule
-	prefix := spec.NamePrefix
-	if strings.TrimSpace(prefix) == "" {
-		prefix = "GMT"
+	naming, err := scheduleNaming(spec)
+	if err != nil {
+		obj.Status.Message = err.Error()
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+	pvcSource := schedulePVCSource(spec)
+	switch {
+	case pvcSource && strings.TrimSpace(ds) != "":
+		obj.Status.Message = "datasetName cannot be combined with pvcName or pvcSelector"
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	case !pvcSource && strings.TrimSpace(ds) == "":
+		obj.Status.Message = "datasetName, pvcName or pvcSelector required"
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+	// Names that Previous Versions cannot read back are refused up front.
+	// VolumeSnapshots are not exposed through shares.
+	if !pvcSource {
+		if err := checkScheduleShares(ctx, r.Client, &obj, naming); err != nil {
+			obj.Status.Message = err.Error()
+			_ = r.Status().Update(ctx, &obj)
+			return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+		}
+	}
+	excludes, err := scheduleExcludes(spec)
+	if err != nil {
+		obj.Status.Message = err.Error()
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
 	}
-	format := spec.Format
-	if strings.TrimSpace(format) == "" {
-		format = "%Y.%m.%d-%H.%M.%S"
+	if err := validateSnapshotHooks(spec.Hooks); err != nil {
+		obj.Status.Message = err.Error()
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
 	}
+	// A retention typo must not stop snapshots: they are still taken and
+	// only pruning is skipped, with the error reported like a failed prune.
 	ret := spec.Retention
+	keepWithin := time.Duration(0)
+	retentionErr := ""
+	if ret != nil {
+		d, err := parseRetentionDuration(ret.KeepWithin)
+		if err != nil {
+			retentionErr = fmt.Sprintf("retention.keepWithin: %v", err)
+			ret = nil
+		}
+		keepWithin = d
+	}
 
 	na := NewNodeAgentClient(r.Cfg)
 
@@ -49,70 +116,270 @@ func (r *ZSnapshotScheduleReconciler) Reconcile(ctx context.Context, req ctrl.Re
 		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
 	}
 
-	now := time.Now().UTC()
-	lastRunStr := obj.Status.LastRunTime
-	var lastRun time.Time
-	if lastRunStr != "" {
-		// best-effort parse (RFC3339)
-		t, _ := time.Parse(time.RFC3339, lastRunStr)
-		lastRun = t
+	now := time.Now().In(naming.Loc)
+	policy := strings.TrimSpace(spec.CatchUpPolicy)
+	if policy == "" {
+		policy = catchUpOnce
+	}
+	if policy != catchUpOnce && policy != catchUpSkip {
+		obj.Status.Message = fmt.Sprintf("invalid catchUpPolicy %q (Once or Skip)", policy)
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+
+	trigger := ""
+	if v := strings.TrimSpace(obj.Annotations[runNowAnnotation]); v != "" && v != obj.Status.LastManualTrigger {
+		// Consumed even if the run fails, so one annotation value means one
+		// attempt.
+		obj.Status.LastManualTrigger = v
+		trigger = runTriggerManual
 	}
-	due := false
-	if lastRunStr == "" {
-		due = true
+
+	var next, scheduled time.Time
+	if spec.Suspend {
+		obj.Status.NextRunTime = ""
 	} else {
-		if !now.Before(parsed.Next(lastRun.UTC())) {
-			due = true
+		next = parsed.Next(now)
+		obj.Status.NextRunTime = next.UTC().Format(time.RFC3339)
+		latest, missed := dueTicks(parsed, lastScheduleTime(&obj.Status), now)
+		if missed > 0 {
+			skip := ""
+			late := now.Sub(latest)
+			switch {
+			case spec.StartingDeadlineSeconds != nil && late > time.Duration(*spec.StartingDeadlineSeconds)*time.Second:
+				skip = fmt.Sprintf("missed starting deadline by %s", (late - time.Duration(*spec.StartingDeadlineSeconds)*time.Second).Round(time.Second))
+			case missed > 1 && policy == catchUpSkip:
+				skip = fmt.Sprintf("skipped %d missed tick(s)", missed)
+			}
+			if skip != "" {
+				obj.Status.LastScheduleTime = latest.UTC().Format(time.RFC3339)
+				recordScheduleRun(&obj, nasv1.ZSnapshotScheduleRun{
+					Time:          now.UTC().Format(time.RFC3339),
+					ScheduledTime: latest.UTC().Format(time.RFC3339),
+					Trigger:       runTriggerSkipped,
+					Message:       skip,
+				})
+			} else {
+				scheduled = latest
+				if trigger == "" {
+					trigger = runTriggerSchedule
+					if missed > 1 {
+						trigger = runTriggerCatchUp
+					}
+				}
+			}
 		}
 	}
 
-	next := parsed.Next(now)
-	obj.Status.NextRunTime = next.Format(time.RFC3339)
+	if trigger != "" && !pvcSource && naming.repeats(now) {
+		// A snapshot from the first pass through the repeated hour already
+		// has this name; the tick is consumed rather than failing on it.
+		skipped := nasv1.ZSnapshotScheduleRun{
+			Time:    now.UTC().Format(time.RFC3339),
+			Trigger: runTriggerSkipped,
+			Message: "local time repeats after a DST change and would reuse a snapshot name",
+		}
+		if !scheduled.IsZero() {
+			skipped.ScheduledTime = scheduled.UTC().Format(time.RFC3339)
+			obj.Status.LastScheduleTime = skipped.ScheduledTime
+		}
+		recordScheduleRun(&obj, skipped)
+		trigger = ""
+	}
 
-	if due {
-		snapName := fmt.Sprintf("%s-%s", prefix, now.Format(strftimeToGo(format)))
+	var run *nasv1.ZSnapshotScheduleRun
+	if trigger != "" {
+		run = &nasv1.ZSnapshotScheduleRun{Time: now.UTC().Format(time.RFC3339), Trigger: trigger}
+		if !scheduled.IsZero() {
+			run.ScheduledTime = scheduled.UTC().Format(time.RFC3339)
+		}
+		snapName := naming.name(now)
 		full := fmt.Sprintf("%s@%s", ds, snapName)
-		body := map[string]any{"dataset": ds, "name": snapName, "recursive": spec.Recursive}
-		var out any
-		if err := na.do(ctx, "POST", "/v1/zfs/snapshot/create", body, &out, nil); err != nil {
-			obj.Status.Message = err.Error()
+		take := func() error {
+			body := map[string]any{"dataset": ds, "name": snapName, "recursive": spec.Recursive, "exclude": excludes}
+			var out any
+			return na.do(ctx, "POST", "/v1/zfs/snapshot/create", body, &out, nil)
+		}
+		if pvcSource {
+			at := now
+			if !scheduled.IsZero() {
+				at = scheduled
+			}
+			take = func() error {
+				wait := time.Duration(0)
+				if spec.Hooks != nil && len(spec.Hooks.Post) > 0 {
+					wait = hookTimeout(spec.Hooks)
+				}
+				names, err := r.createVolumeSnapshots(ctx, &obj, at, wait)
+				full = strings.Join(names, ", ")
+				return err
+			}
+		}
+		hooks, err := runWithSnapshotHooks(ctx, r.Client, r.RESTConfig, obj.Namespace, spec.Hooks, take)
+		if len(hooks.Results) > 0 {
+			obj.Status.LastHookResults = hooks.Results
+		}
+		hooked := spec.Hooks != nil && len(spec.Hooks.Pre)+len(spec.Hooks.Post) > 0
+		if hooks.Skipped || (err != nil && hooked) {
+			// The tick is consumed: retrying would run the hooks against the
+			// application again. The next tick tries again.
+			if hooks.Skipped {
+				run.Error = fmt.Sprintf("snapshot skipped: %v", hooks.PreErr)
+			} else {
+				run.Error = err.Error()
+			}
+			if hooks.PostErr != nil {
+				run.Error += fmt.Sprintf("; %v", hooks.PostErr)
+			}
+			if run.ScheduledTime != "" {
+				obj.Status.LastScheduleTime = run.ScheduledTime
+			}
+			obj.Status.ConsecutiveFailures++
+			recordScheduleRun(&obj, *run)
+			setScheduleFailingCondition(&obj)
+			obj.Status.Message = run.Error
+			_ = r.Status().Update(ctx, &obj)
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+		if err != nil {
+			// Without hooks the tick stays unhandled so it is retried until
+			// its deadline.
+			run.Error = err.Error()
+			if recordScheduleRun(&obj, *run) {
+				obj.Status.ConsecutiveFailures++
+			}
+			setScheduleFailingCondition(&obj)
+			obj.Status.Message = run.Error
 			_ = r.Status().Update(ctx, &obj)
 			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
 		}
-		obj.Status.LastRunTime = now.Format(time.RFC3339)
+		run.Snapshot = full
+		obj.Status.LastRunTime = now.UTC().Format(time.RFC3339)
 		obj.Status.LastSnapshotName = full
+		if run.ScheduledTime != "" {
+			obj.Status.LastScheduleTime = run.ScheduledTime
+		}
+		obj.Status.ConsecutiveFailures = 0
+		if hooks.PreErr != nil {
+			run.Message = fmt.Sprintf("crash-consistent only: %v", hooks.PreErr)
+		}
+		if hooks.PostErr != nil {
+			run.Error = hooks.PostErr.Error()
+			obj.Status.ConsecutiveFailures++
+		}
+	}
 
-		// retention: keepLast (and keepHourly treated as extra keepLast for MVP)
-		keepLast := int64(0)
-		if ret != nil {
-			if ret.KeepLast > keepLast {
-				keepLast = ret.KeepLast
-			}
-			if ret.KeepHourly > keepLast {
-				keepLast = ret.KeepHourly
-			}
+	pruneErrs := []string{}
+	if retentionErr != "" {
+		pruneErrs = append(pruneErrs, retentionErr)
+	}
+	if spec.Suspend {
+		obj.Status.PruneCandidates = nil
+	} else if retentionActive(ret) && pvcSource {
+		pruned, candidates, errs := r.pruneVolumeSnapshots(ctx, &obj, ret, keepWithin, now, next)
+		pruneErrs = append(pruneErrs, errs...)
+		if len(pruned) > 0 {
+			obj.Status.LastPruned = pruned
+		}
+		if run != nil {
+			run.Pruned = pruned
 		}
-		if keepLast > 0 {
-			var list struct {
-				OK    bool     `json:"ok"`
-				Items []string `json:"items"`
+		obj.Status.PruneCandidates = candidates
+		obj.Status.HeldSnapshots = nil
+		obj.Status.CloneBlockedSnapshots = nil
+	} else if retentionActive(ret) {
+		var list struct {
+			OK    bool     `json:"ok"`
+			Items []string `json:"items"`
+		}
+		q := make(url.Values)
+		q.Set("dataset", ds)
+		if err := na.do(ctx, "GET", "/v1/zfs/snapshot/list", nil, &list, q); err != nil {
+			pruneErrs = append(pruneErrs, fmt.Sprintf("list snapshots: %v", err))
+		} else {
+			snaps := managedSnapshots(list.Items, ds, naming, spec.Recursive)
+			held, err := heldSnapshots(ctx, na, ds, spec.Recursive)
+			if err != nil {
+				// Without the holds nothing can be pruned safely.
+				pruneErrs = append(pruneErrs, fmt.Sprintf("list holds: %v", err))
+				snaps = nil
+			}
+			cloned, err := clonedSnapshots(ctx, na, ds, spec.Recursive)
+			if err != nil {
+				pruneErrs = append(pruneErrs, fmt.Sprintf("list clones: %v", err))
+				snaps = nil
 			}
-			q := make(url.Values)
-			q.Set("dataset", ds)
-			_ = na.do(ctx, "GET", "/v1/zfs/snapshot/list", nil, &list, q)
-			managed := filterManaged(list.Items, ds, prefix)
-			sort.Strings(managed)
-			// newest last, so delete from beginning
-			if int64(len(managed)) > keepLast {
-				toDelete := managed[:int64(len(managed))-keepLast]
-				for _, s := range toDelete {
-					_ = na.do(ctx, "POST", "/v1/zfs/snapshot/destroy", map[string]any{"snapshot": s}, &out, nil)
+			// Pruning runs on every reconcile so keepWithin expiry and failed
+			// destroys do not wait for the next scheduled snapshot.
+			var pruned, skipped []string
+			var blocked []nasv1.ZSnapshotCloneDependency
+			gone := map[string]bool{}
+			for _, s := range planRetention(snaps, ret, keepWithin, now) {
+				if held[s] {
+					skipped = append(skipped, s)
+					continue
+				}
+				if c := cloned[s]; len(c) > 0 {
+					blocked = append(blocked, nasv1.ZSnapshotCloneDependency{Snapshot: s, Clones: c})
+					continue
+				}
+				if err := na.do(ctx, "POST", "/v1/zfs/snapshot/destroy", map[string]any{"snapshot": s, "recursive": spec.Recursive}, nil, nil); err != nil {
+					pruneErrs = append(pruneErrs, fmt.Sprintf("%s: %v", s, err))
+					continue
 				}
+				pruned = append(pruned, s)
+				gone[s] = true
 			}
+			if len(pruned) > 0 {
+				obj.Status.LastPruned = pruned
+				snaps = slices.DeleteFunc(snaps, func(s retentionSnapshot) bool { return gone[s.Name] })
+			}
+			if run != nil {
+				run.Pruned = pruned
+			}
+			// Dry run for the next run, which will add one more snapshot.
+			candidates := planRetention(append(snaps, retentionSnapshot{Time: next}), ret, keepWithin, next)
+			obj.Status.PruneCandidates = slices.DeleteFunc(candidates, func(n string) bool { return n == "" || held[n] || len(cloned[n]) > 0 })
+			obj.Status.HeldSnapshots = skipped
+			obj.Status.CloneBlockedSnapshots = blocked
 		}
+	} else {
+		obj.Status.PruneCandidates = nil
+		obj.Status.LastPruned = nil
+		obj.Status.HeldSnapshots = nil
+		obj.Status.CloneBlockedSnapshots = nil
 	}
+	setSchedulePruneBlockedCondition(&obj)
 
+	if run != nil {
+		if len(pruneErrs) > 0 {
+			msg := "prune failed: " + strings.Join(pruneErrs, "; ")
+			if run.Error == "" {
+				run.Error = msg
+				obj.Status.ConsecutiveFailures++
+			} else {
+				run.Error += "; " + msg
+			}
+		}
+		recordScheduleRun(&obj, *run)
+		setScheduleFailingCondition(&obj)
+	}
+
+	if len(pruneErrs) > 0 {
+		obj.Status.Message = "prune failed: " + strings.Join(pruneErrs, "; ")
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	if spec.Suspend {
+		obj.Status.Message = "suspended"
+		_ = r.Status().Update(ctx, &obj)
+		// Resuming or annotating the schedule triggers the next reconcile.
+		return ctrl.Result{}, nil
+	}
 	obj.Status.Message = "OK"
+	if run != nil && run.Error != "" {
+		obj.Status.Message = run.Error
+	}
 	_ = r.Status().Update(ctx, &obj)
 
 	wait := time.Until(next)
@@ -125,36 +392,182 @@ func (r *ZSnapshotScheduleReconciler) Reconcile(ctx context.Context, req ctrl.Re
 	return ctrl.Result{RequeueAfter: wait}, nil
 }
 
-func filterManaged(items []string, ds, prefix string) []string {
+// lastScheduleTime is the tick missed runs are counted from. Schedules
+// created before LastScheduleTime existed fall back to LastRunTime.
+func lastScheduleTime(st *nasv1.ZSnapshotScheduleStatus) time.Time {
+	for _, s := range []string{st.LastScheduleTime, st.LastRunTime} {
+		if s == "" {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dueTicks returns the latest tick after last that is not after now and how
+// many ticks were due. A schedule that never ran is due immediately.
+func dueTicks(sched cron.Schedule, last, now time.Time) (time.Time, int) {
+	if last.IsZero() {
+		return now, 1
+	}
+	// Ticks older than the window are counted as if the window started
+	// there; this bounds the walk for frequent schedules.
+	if cutoff := now.Add(-maxCatchUpWindow); last.Before(cutoff) {
+		last = cutoff
+	}
+	var latest time.Time
+	n := 0
+	for t := sched.Next(last.In(now.Location())); !t.IsZero() && !t.After(now); t = sched.Next(t) {
+		latest = t
+		n++
+	}
+	return latest, n
+}
+
+// recordScheduleRun adds run to the history, newest first. A retry of the
+// tick recorded last replaces that entry instead, so retries do not crowd
+// the bounded history; it reports whether a new entry was added.
+func recordScheduleRun(obj *nasv1.ZSnapshotSchedule, run nasv1.ZSnapshotScheduleRun) bool {
+	if h := obj.Status.History; run.ScheduledTime != "" && len(h) > 0 && h[0].ScheduledTime == run.ScheduledTime {
+		h[0] = run
+		return false
+	}
+	limit := int(obj.Spec.RunHistoryLimit)
+	if limit <= 0 {
+		limit = defaultRunHistoryLimit
+	}
+	if limit > maxRunHistoryLimit {
+		limit = maxRunHistoryLimit
+	}
+	hist := append([]nasv1.ZSnapshotScheduleRun{run}, obj.Status.History...)
+	if len(hist) > limit {
+		hist = hist[:limit]
+	}
+	obj.Status.History = hist
+	return true
+}
+
+func setScheduleFailingCondition(obj *nasv1.ZSnapshotSchedule) {
+	cond := metav1.Condition{
+		Type:               "Failing",
+		Status:             metav1.ConditionFalse,
+		Reason:             "RunSucceeded",
+		Message:            "last run succeeded",
+		LastTransitionTime: metav1.Now(),
+	}
+	if n := obj.Status.ConsecutiveFailures; n > 0 {
+		cond.Reason = "RunFailed"
+		cond.Message = fmt.Sprintf("%d consecutive failed run(s): %s", n, obj.Status.History[0].Error)
+		if n >= scheduleFailureThreshold {
+			cond.Status = metav1.ConditionTrue
+			cond.Reason = "ConsecutiveFailures"
+		}
+	}
+	apiMeta.SetStatusCondition(&obj.Status.Conditions, cond)
+}
+
+// setSchedulePruneBlockedCondition reports snapshots retention keeps only
+// because clones depend on them.
+func setSchedulePruneBlockedCondition(obj *nasv1.ZSnapshotSchedule) {
+	cond := metav1.Condition{
+		Type:               "PruneBlocked",
+		Status:             metav1.ConditionFalse,
+		Reason:             "NoDependentClones",
+		Message:            "no expired snapshot has dependent clones",
+		LastTransitionTime: metav1.Now(),
+	}
+	if b := obj.Status.CloneBlockedSnapshots; len(b) > 0 {
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = "DependentClones"
+		cond.Message = fmt.Sprintf("%d expired snapshot(s) kept for dependent clones, e.g. %s (clones: %s); promote or destroy the clones",
+			len(b), b[0].Snapshot, strings.Join(b[0].Clones, ", "))
+	}
+	apiMeta.SetStatusCondition(&obj.Status.Conditions, cond)
+}
+
+// clonedSnapshots maps each ds@name under ds to the clones made from it.
+// With recursive, clones of any child's snapshot count for the family.
+func clonedSnapshots(ctx context.Context, na *NodeAgentClient, ds string, recursive bool) (map[string][]string, error) {
+	var details struct {
+		OK    bool `json:"ok"`
+		Items []struct {
+			Name   string   `json:"name"`
+			Clones []string `json:"clones,omitempty"`
+		} `json:"items,omitempty"`
+	}
+	q := make(url.Values)
+	q.Set("dataset", ds)
+	q.Set("recursive", strconv.FormatBool(recursive))
+	if err := na.do(ctx, "GET", "/v1/zfs/snapshot/details", nil, &details, q); err != nil {
+		return nil, err
+	}
+	cloned := map[string][]string{}
+	for _, it := range details.Items {
+		at := strings.Index(it.Name, "@")
+		if at < 0 || len(it.Clones) == 0 {
+			continue
+		}
+		family := ds + it.Name[at:]
+		cloned[family] = append(cloned[family], it.Clones...)
+	}
+	return cloned, nil
+}
+
+// filterManaged returns the <ds>@<prefix>-* snapshots in items. With
+// recursive, a snapshot on any child counts as its ds@name family, so
+// families whose parent snapshot is already gone are still found.
+func filterManaged(items []string, ds, prefix string, recursive bool) []string {
 	var out []string
+	seen := map[string]bool{}
 	for _, full := range items {
 		parts := strings.Split(full, "@")
 		if len(parts) != 2 {
 			continue
 		}
-		if parts[0] != ds {
+		if parts[0] != ds && !(recursive && strings.HasPrefix(parts[0], ds+"/")) {
 			continue
 		}
-		if strings.HasPrefix(parts[1], prefix+"-") {
-			out = append(out, full)
+		if !strings.HasPrefix(parts[1], prefix+"-") {
+			continue
+		}
+		name := ds + "@" + parts[1]
+		if !seen[name] {
+			seen[name] = true
+			out = append(out, name)
 		}
 	}
 	return out
 }
 
-func strftimeToGo(f string) string {
-	out := f
-	out = strings.ReplaceAll(out, "%Y", "2006")
-	out = strings.ReplaceAll(out, "%m", "01")
-	out = strings.ReplaceAll(out, "%d", "02")
-	out = strings.ReplaceAll(out, "%H", "15")
-	out = strings.ReplaceAll(out, "%M", "04")
-	out = strings.ReplaceAll(out, "%S", "05")
-	return out
+// scheduleExcludes resolves spec.exclude to full dataset names. Entries are
+// relative to datasetName unless they already start with it.
+func scheduleExcludes(spec nasv1.ZSnapshotScheduleSpec) ([]string, error) {
+	ds := strings.Trim(strings.TrimSpace(spec.DatasetName), "/")
+	var out []string
+	for _, e := range spec.Exclude {
+		e = strings.Trim(strings.TrimSpace(e), "/")
+		if e == "" {
+			continue
+		}
+		if !strings.HasPrefix(e, ds+"/") {
+			e = ds + "/" + e
+		}
+		if strings.Contains(e, "@") {
+			return nil, fmt.Errorf("invalid exclude entry %q", e)
+		}
+		out = append(out, e)
+	}
+	if len(out) > 0 && !spec.Recursive {
+		return nil, fmt.Errorf("exclude requires recursive: true")
+	}
+	return out, nil
 }
 
 func (r *ZSnapshotScheduleReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&nasv1.ZSnapshotSchedule{}).
+		Watches(&nasv1.NASShare{}, handler.EnqueueRequestsFromMapFunc(r.schedulesForShare)).
 		Complete(r)
 }
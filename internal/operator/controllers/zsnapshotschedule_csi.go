@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	nasv1 "mnemosyne/api/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// VolumeSnapshots taken by a PVC schedule carry the schedule's UID, so
+// retention only ever deletes its own, and the time they were taken for.
+const (
+	scheduleUIDLabel        = "nas.io/snapshot-schedule-uid"
+	scheduleNameAnnotation  = "nas.io/snapshot-schedule"
+	snapshotTimeAnnotation  = "nas.io/snapshot-time"
+	defaultSnapshotClassCSI = "nas-zfspv-snapclass"
+)
+
+var volumeSnapshotListGVK = volumeSnapshotGVK.GroupVersion().WithKind("VolumeSnapshotList")
+
+// schedulePVCSource reports whether the schedule snapshots PVCs through CSI
+// rather than a dataset through the node-agent.
+func schedulePVCSource(spec nasv1.ZSnapshotScheduleSpec) bool {
+	return strings.TrimSpace(spec.PVCName) != "" || len(spec.PVCSelector) > 0
+}
+
+// schedulePVCs returns the names of the PVCs the schedule snapshots, sorted.
+func (r *ZSnapshotScheduleReconciler) schedulePVCs(ctx context.Context, obj *nasv1.ZSnapshotSchedule) ([]string, error) {
+	if name := strings.TrimSpace(obj.Spec.PVCName); name != "" {
+		return []string{name}, nil
+	}
+	var pvcs corev1.PersistentVolumeClaimList
+	if err := r.List(ctx, &pvcs, client.InNamespace(obj.Namespace), client.MatchingLabels(obj.Spec.PVCSelector)); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, p := range pvcs.Items {
+		if p.DeletionTimestamp.IsZero() {
+			names = append(names, p.Name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// createVolumeSnapshots takes a VolumeSnapshot of every selected PVC for the
+// tick at t. Names derive from the PVC, the schedule and t, so two schedules
+// on one PVC do not collide and retrying a tick does not duplicate the
+// snapshots that already succeeded. With wait set, it returns only once
+// the CSI driver has cut every snapshot or wait has passed, so post hooks
+// do not release the application early.
+func (r *ZSnapshotScheduleReconciler) createVolumeSnapshots(ctx context.Context, obj *nasv1.ZSnapshotSchedule, t time.Time, wait time.Duration) ([]string, error) {
+	pvcs, err := r.schedulePVCs(ctx, obj)
+	if err != nil {
+		return nil, fmt.Errorf("list PVCs: %w", err)
+	}
+	if len(pvcs) == 0 {
+		return nil, fmt.Errorf("no PVC matches pvcSelector %v", obj.Spec.PVCSelector)
+	}
+	class := strings.TrimSpace(obj.Spec.SnapshotClassName)
+	if class == "" {
+		class = defaultSnapshotClassCSI
+	}
+	suffix := "-" + t.UTC().Format("20060102-150405")
+	var names, errs []string
+	for _, pvc := range pvcs {
+		name := pvc + "-" + obj.Name
+		if len(name)+len(suffix) > 253 {
+			name = name[:253-len(suffix)]
+		}
+		name += suffix
+		vs := &unstructured.Unstructured{}
+		vs.SetGroupVersionKind(volumeSnapshotGVK)
+		vs.SetNamespace(obj.Namespace)
+		vs.SetName(name)
+		vs.SetLabels(map[string]string{scheduleUIDLabel: string(obj.UID)})
+		vs.SetAnnotations(map[string]string{
+			scheduleNameAnnotation: obj.Name,
+			snapshotTimeAnnotation: t.UTC().Format(time.RFC3339),
+		})
+		_ = unstructured.SetNestedField(vs.Object, class, "spec", "volumeSnapshotClassName")
+		_ = unstructured.SetNestedField(vs.Object, map[string]any{"persistentVolumeClaimName": pvc}, "spec", "source")
+		if err := r.Create(ctx, vs); err != nil {
+			if !errors.IsAlreadyExists(err) {
+				errs = append(errs, fmt.Sprintf("%s: %v", pvc, err))
+				continue
+			}
+			// Only a snapshot this schedule took for the same tick counts.
+			existing := &unstructured.Unstructured{}
+			existing.SetGroupVersionKind(volumeSnapshotGVK)
+			if err := r.Get(ctx, client.ObjectKey{Namespace: obj.Namespace, Name: name}, existing); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", pvc, err))
+				continue
+			}
+			if existing.GetLabels()[scheduleUIDLabel] != string(obj.UID) {
+				errs = append(errs, fmt.Sprintf("%s: VolumeSnapshot %s already exists and was not taken by this schedule", pvc, name))
+				continue
+			}
+		}
+		names = append(names, name)
+	}
+	if wait > 0 {
+		deadline := time.Now().Add(wait)
+		for _, name := range names {
+			vs := &unstructured.Unstructured{}
+			vs.SetGroupVersionKind(volumeSnapshotGVK)
+			vs.SetNamespace(obj.Namespace)
+			vs.SetName(name)
+			if err := waitVolumeSnapshotCut(ctx, r.Client, vs, deadline); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return names, fmt.Errorf("create VolumeSnapshot: %s", strings.Join(errs, "; "))
+	}
+	return names, nil
+}
+
+// pruneVolumeSnapshots applies retention to the schedule's VolumeSnapshots,
+// separately for each source PVC, and returns what it deleted plus the dry
+// run for the next tick. Snapshots of PVCs no longer selected are still
+// pruned.
+func (r *ZSnapshotScheduleReconciler) pruneVolumeSnapshots(ctx context.Context, obj *nasv1.ZSnapshotSchedule, ret *nasv1.ZSnapshotScheduleRetention, keepWithin time.Duration, now, next time.Time) (pruned, candidates, errs []string) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(volumeSnapshotListGVK)
+	if err := r.List(ctx, list, client.InNamespace(obj.Namespace), client.MatchingLabels{scheduleUIDLabel: string(obj.UID)}); err != nil {
+		return nil, nil, []string{fmt.Sprintf("list VolumeSnapshots: %v", err)}
+	}
+	byPVC := map[string][]retentionSnapshot{}
+	for _, vs := range list.Items {
+		if !vs.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+		pvc, _, _ := unstructured.NestedString(vs.Object, "spec", "source", "persistentVolumeClaimName")
+		t, err := time.Parse(time.RFC3339, vs.GetAnnotations()[snapshotTimeAnnotation])
+		if err != nil {
+			t = vs.GetCreationTimestamp().Time
+		}
+		byPVC[pvc] = append(byPVC[pvc], retentionSnapshot{Name: vs.GetName(), Time: t.In(now.Location())})
+	}
+	pvcs := make([]string, 0, len(byPVC))
+	for pvc := range byPVC {
+		pvcs = append(pvcs, pvc)
+	}
+	slices.Sort(pvcs)
+	for _, pvc := range pvcs {
+		snaps := byPVC[pvc]
+		gone := map[string]bool{}
+		for _, name := range planRetention(snaps, ret, keepWithin, now) {
+			vs := &unstructured.Unstructured{}
+			vs.SetGroupVersionKind(volumeSnapshotGVK)
+			vs.SetNamespace(obj.Namespace)
+			vs.SetName(name)
+			if err := r.Delete(ctx, vs); err != nil && !errors.IsNotFound(err) {
+				errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+				continue
+			}
+			pruned = append(pruned, name)
+			gone[name] = true
+		}
+		snaps = slices.DeleteFunc(snaps, func(s retentionSnapshot) bool { return gone[s.Name] })
+		if !next.IsZero() {
+			for _, name := range planRetention(append(snaps, retentionSnapshot{Time: next}), ret, keepWithin, next) {
+				if name != "" {
+					candidates = append(candidates, name)
+				}
+			}
+		}
+	}
+	return pruned, candidates, errs
+}
@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	nasv1 "mnemosyne/api/v1alpha1"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const zvolumeFinalizer = "nas.io/zvolume-finalizer"
+
+type ZVolumeReconciler struct {
+	client.Client
+	Cfg Config
+}
+
+type zvolumeInfo struct {
+	DevicePath   string `json:"devicePath"`
+	SizeBytes    int64  `json:"sizeBytes"`
+	UsedBytes    int64  `json:"usedBytes"`
+	Referenced   int64  `json:"referencedBytes"`
+	VolBlockSize string `json:"volblocksize,omitempty"`
+}
+
+type zvolumeResponse struct {
+	OK      bool         `json:"ok"`
+	Volume  *zvolumeInfo `json:"volume,omitempty"`
+	Created bool         `json:"created,omitempty"`
+	Resized bool         `json:"resized,omitempty"`
+	Output  string       `json:"output,omitempty"`
+}
+
+func (r *ZVolumeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var obj nasv1.ZVolume
+	if err := r.Get(ctx, req.NamespacedName, &obj); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	na := NewNodeAgentClient(r.Cfg)
+	full := zvolumeName(obj.Spec)
+	deleteOnRemove := strings.EqualFold(strings.TrimSpace(obj.Spec.DeletionPolicy), "Delete")
+
+	if !obj.DeletionTimestamp.IsZero() {
+		if slices.Contains(obj.Finalizers, zvolumeFinalizer) {
+			if deleteOnRemove && full != "" {
+				if err := na.do(ctx, "POST", "/v1/zfs/zvol/destroy", map[string]any{"name": full}, nil, nil); err != nil {
+					obj.Status.Phase = "Error"
+					obj.Status.Message = err.Error()
+					_ = r.Status().Update(ctx, &obj)
+					return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+				}
+			}
+			obj.Finalizers = slices.DeleteFunc(obj.Finalizers, func(n string) bool {
+				return n == zvolumeFinalizer
+			})
+			_ = r.Update(ctx, &obj)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if full == "" || strings.TrimSpace(obj.Spec.Size) == "" {
+		obj.Status.Phase = "Error"
+		obj.Status.Message = "pool, name and size are required"
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	// The finalizer is only needed when deleting the ZVolume destroys the zvol.
+	if deleteOnRemove != slices.Contains(obj.Finalizers, zvolumeFinalizer) {
+		if deleteOnRemove {
+			obj.Finalizers = append(obj.Finalizers, zvolumeFinalizer)
+		} else {
+			obj.Finalizers = slices.DeleteFunc(obj.Finalizers, func(n string) bool {
+				return n == zvolumeFinalizer
+			})
+		}
+		if err := r.Update(ctx, &obj); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	body := map[string]any{
+		"name":        full,
+		"size":        strings.TrimSpace(obj.Spec.Size),
+		"sparse":      obj.Spec.Sparse,
+		"compression": strings.TrimSpace(obj.Spec.Compression),
+		"properties":  obj.Spec.Properties,
+	}
+	if bs := strings.TrimSpace(obj.Spec.VolBlockSize); bs != "" {
+		body["volblocksize"] = bs
+	}
+	var out zvolumeResponse
+	if err := na.do(ctx, "POST", "/v1/zfs/zvol/ensure", body, &out, nil); err != nil {
+		obj.Status.Phase = "Error"
+		obj.Status.Message = err.Error()
+		_ = r.Status().Update(ctx, &obj)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	if v := out.Volume; v != nil {
+		obj.Status.DevicePath = v.DevicePath
+		obj.Status.SizeBytes = v.SizeBytes
+		obj.Status.UsedBytes = v.UsedBytes
+		obj.Status.ReferencedBytes = v.Referenced
+		obj.Status.VolBlockSize = v.VolBlockSize
+	}
+	obj.Status.Phase = "Ready"
+	switch {
+	case out.Created:
+		obj.Status.Message = "created"
+	case out.Resized:
+		obj.Status.Message = fmt.Sprintf("resized to %s", obj.Spec.Size)
+	case strings.TrimSpace(out.Output) != "":
+		obj.Status.Message = strings.TrimSpace(out.Output)
+	default:
+		obj.Status.Message = "OK"
+	}
+	_ = r.Status().Update(ctx, &obj)
+	return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+}
+
+func zvolumeName(spec nasv1.ZVolumeSpec) string {
+	pool := strings.Trim(strings.TrimSpace(spec.Pool), "/")
+	name := strings.Trim(strings.TrimSpace(spec.Name), "/")
+	if pool == "" || name == "" {
+		return ""
+	}
+	return path.Join(pool, name)
+}
+
+func (r *ZVolumeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&nasv1.ZVolume{}).
+		Complete(r)
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 
 	nasv1 "mnemosyne/api/v1alpha1"
 	"mnemosyne/internal/operator/controllers"
@@ -45,11 +47,30 @@ func Run(opts Options) error {
 		baseURL = "http://nas-node-agent.nas-system.svc.cluster.local:9808"
 	}
 
+	// NODE_AGENT_URLS is "node=url,node=url"; e.g. two node-agents on one
+	// host listening on different ports.
+	agentURLs := map[string]string{}
+	for _, kv := range strings.Split(os.Getenv("NODE_AGENT_URLS"), ",") {
+		if node, u, ok := strings.Cut(strings.TrimSpace(kv), "="); ok && node != "" && u != "" {
+			agentURLs[strings.TrimSpace(node)] = strings.TrimSpace(u)
+		}
+	}
+	agentPort := 9808
+	if v := os.Getenv("NODE_AGENT_PORT"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("NODE_AGENT_PORT: %w", err)
+		}
+		agentPort = p
+	}
+
 	cfg := controllers.Config{
 		NodeAgentBaseURL: baseURL,
 		AuthHeader:       authHeader,
 		AuthValue:        authValue,
 		Namespace:        "nas-system",
+		NodeAgentURLs:    agentURLs,
+		NodeAgentPort:    agentPort,
 	}
 
 	if err := controllers.SetupAll(mgr, cfg); err != nil {
@@ -12,6 +12,9 @@ type SnapshotExposure struct {
 	Mode      string
 	Format    string
 	LocalTime *bool
+	// TimeZone is the IANA zone smbd uses for shadow:localtime; it is set
+	// as TZ on the Samba container. Empty means UTC.
+	TimeZone string
 }
 
 type TimeMachine struct {
@@ -37,6 +40,10 @@ type Options struct {
 
 	SnapshotExposure *SnapshotExposure
 	TimeMachine      *TimeMachine
+
+	// Homes renders a per-user share: path is <path>/%S and only the
+	// connecting user is allowed in.
+	Homes bool
 }
 
 var (
@@ -173,6 +180,13 @@ func Render(shareName, path string, readOnly bool, o Options) (string, error) {
 		}
 	}
 
+	if o.Homes {
+		path = strings.TrimRight(path, "/") + "/%S"
+		browseable = "no"
+		o.ValidUsers = []string{"%S"}
+		o.WriteList = nil
+	}
+
 	shareLines := []string{
 		fmt.Sprintf("[%s]", shareName),
 		fmt.Sprintf("  path = %s", path),
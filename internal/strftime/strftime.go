@@ -0,0 +1,297 @@
+// Package strftime formats and parses C strftime patterns so snapshot names
+// agree with what Samba's shadow_copy2 expects from shadow:format.
+package strftime
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	shortDays   = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+	longDays    = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+	shortMonths = []string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+	longMonths  = []string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"}
+)
+
+// supported lists the conversions Format and Parse understand.
+const supported = "YymdejHIMSpaAbBhzZsuwV%"
+
+// Validate reports unknown or dangling conversions.
+func Validate(f string) error {
+	for i := 0; i < len(f); i++ {
+		if f[i] != '%' {
+			continue
+		}
+		if i+1 >= len(f) {
+			return fmt.Errorf("format %q ends with %%", f)
+		}
+		i++
+		if !strings.ContainsRune(supported, rune(f[i])) {
+			return fmt.Errorf("unsupported conversion %%%c in %q", f[i], f)
+		}
+	}
+	return nil
+}
+
+// Format renders t with f. Unknown conversions are copied through.
+func Format(t time.Time, f string) string {
+	var b strings.Builder
+	for i := 0; i < len(f); i++ {
+		c := f[i]
+		if c != '%' || i+1 >= len(f) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch f[i] {
+		case 'Y':
+			fmt.Fprintf(&b, "%04d", t.Year())
+		case 'y':
+			fmt.Fprintf(&b, "%02d", t.Year()%100)
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'e':
+			fmt.Fprintf(&b, "%2d", t.Day())
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'I':
+			h := t.Hour() % 12
+			if h == 0 {
+				h = 12
+			}
+			fmt.Fprintf(&b, "%02d", h)
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 'p':
+			if t.Hour() < 12 {
+				b.WriteString("AM")
+			} else {
+				b.WriteString("PM")
+			}
+		case 'a':
+			b.WriteString(shortDays[t.Weekday()])
+		case 'A':
+			b.WriteString(longDays[t.Weekday()])
+		case 'b', 'h':
+			b.WriteString(shortMonths[t.Month()-1])
+		case 'B':
+			b.WriteString(longMonths[t.Month()-1])
+		case 'z':
+			b.WriteString(t.Format("-0700"))
+		case 'Z':
+			name, _ := t.Zone()
+			b.WriteString(name)
+		case 's':
+			b.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case 'u':
+			wd := int(t.Weekday())
+			if wd == 0 {
+				wd = 7
+			}
+			b.WriteString(strconv.Itoa(wd))
+		case 'w':
+			b.WriteString(strconv.Itoa(int(t.Weekday())))
+		case 'V':
+			_, w := t.ISOWeek()
+			fmt.Fprintf(&b, "%02d", w)
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(f[i])
+		}
+	}
+	return b.String()
+}
+
+// Parse reads s according to f. Fields not present default to the start of
+// the period; times without %z or %s are interpreted in loc. Conversions
+// that cannot pin a date on their own (%a, %A, %u, %w, %V, %Z) must still
+// match but are otherwise ignored.
+func Parse(f, s string, loc *time.Location) (time.Time, error) {
+	year, month, day, yday := 1970, 1, 1, 0
+	hour, minute, sec := 0, 0, 0
+	pm, twelve := false, false
+	var unix *int64
+	var zone *time.Location
+	pos := 0
+
+	num := func(width int, min, max int) (int, error) {
+		end := pos
+		for end < len(s) && end-pos < width && s[end] >= '0' && s[end] <= '9' {
+			end++
+		}
+		if end-pos != width {
+			return 0, fmt.Errorf("expected %d digits at %q", width, s[pos:])
+		}
+		n, _ := strconv.Atoi(s[pos:end])
+		if n < min || n > max {
+			return 0, fmt.Errorf("value %d out of range at %q", n, s[pos:])
+		}
+		pos = end
+		return n, nil
+	}
+	name := func(names []string) (int, error) {
+		for i, n := range names {
+			if len(s)-pos >= len(n) && strings.EqualFold(s[pos:pos+len(n)], n) {
+				pos += len(n)
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("expected name at %q", s[pos:])
+	}
+
+	for i := 0; i < len(f); i++ {
+		c := f[i]
+		if c != '%' || i+1 >= len(f) {
+			if pos >= len(s) || s[pos] != c {
+				return time.Time{}, fmt.Errorf("%q does not match %q", s, f)
+			}
+			pos++
+			continue
+		}
+		i++
+		var err error
+		switch f[i] {
+		case 'Y':
+			year, err = num(4, 0, 9999)
+		case 'y':
+			var y int
+			if y, err = num(2, 0, 99); err == nil {
+				// POSIX: 69-99 are 1900s, 00-68 are 2000s.
+				year = 2000 + y
+				if y >= 69 {
+					year = 1900 + y
+				}
+			}
+		case 'm':
+			month, err = num(2, 1, 12)
+		case 'd':
+			day, err = num(2, 1, 31)
+		case 'e':
+			if pos < len(s) && s[pos] == ' ' {
+				pos++
+				day, err = num(1, 1, 9)
+			} else {
+				day, err = num(2, 10, 31)
+			}
+		case 'j':
+			yday, err = num(3, 1, 366)
+		case 'H':
+			hour, err = num(2, 0, 23)
+		case 'I':
+			twelve = true
+			hour, err = num(2, 1, 12)
+		case 'M':
+			minute, err = num(2, 0, 59)
+		case 'S':
+			sec, err = num(2, 0, 60)
+		case 'p':
+			var v int
+			if v, err = name([]string{"AM", "PM"}); err == nil {
+				pm = v == 1
+			}
+		case 'a':
+			_, err = name(shortDays)
+		case 'A':
+			_, err = name(longDays)
+		case 'b', 'h':
+			var m int
+			if m, err = name(shortMonths); err == nil {
+				month = m + 1
+			}
+		case 'B':
+			var m int
+			if m, err = name(longMonths); err == nil {
+				month = m + 1
+			}
+		case 'u':
+			_, err = num(1, 1, 7)
+		case 'w':
+			_, err = num(1, 0, 6)
+		case 'V':
+			_, err = num(2, 1, 53)
+		case 'z':
+			if pos+5 > len(s) || (s[pos] != '+' && s[pos] != '-') {
+				return time.Time{}, fmt.Errorf("expected ±hhmm at %q", s[pos:])
+			}
+			sign := 1
+			if s[pos] == '-' {
+				sign = -1
+			}
+			pos++
+			var hh, mm int
+			if hh, err = num(2, 0, 23); err == nil {
+				if mm, err = num(2, 0, 59); err == nil {
+					zone = time.FixedZone("", sign*(hh*3600+mm*60))
+				}
+			}
+		case 'Z':
+			end := pos
+			for end < len(s) && (s[end] >= 'A' && s[end] <= 'Z' || s[end] >= 'a' && s[end] <= 'z') {
+				end++
+			}
+			if end == pos {
+				err = fmt.Errorf("expected zone name at %q", s[pos:])
+			}
+			pos = end
+		case 's':
+			end := pos
+			if end < len(s) && s[end] == '-' {
+				end++
+			}
+			for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+				end++
+			}
+			var v int64
+			if v, err = strconv.ParseInt(s[pos:end], 10, 64); err == nil {
+				unix = &v
+				pos = end
+			}
+		case '%':
+			if pos >= len(s) || s[pos] != '%' {
+				err = fmt.Errorf("expected %% at %q", s[pos:])
+			} else {
+				pos++
+			}
+		default:
+			err = fmt.Errorf("unsupported conversion %%%c", f[i])
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	if pos != len(s) {
+		return time.Time{}, fmt.Errorf("trailing text %q", s[pos:])
+	}
+
+	if unix != nil {
+		return time.Unix(*unix, 0).In(loc), nil
+	}
+	if twelve {
+		hour %= 12
+		if pm {
+			hour += 12
+		}
+	}
+	if zone != nil {
+		loc = zone
+	}
+	if yday > 0 {
+		return time.Date(year, 1, yday, hour, minute, sec, 0, loc), nil
+	}
+	t := time.Date(year, time.Month(month), day, hour, minute, sec, 0, loc)
+	if t.Day() != day {
+		return time.Time{}, fmt.Errorf("invalid date %04d-%02d-%02d", year, month, day)
+	}
+	return t, nil
+}